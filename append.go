@@ -0,0 +1,276 @@
+package sftpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// AppendStrategy is how UploadAppend adds data to the end of a remote file.
+type AppendStrategy int
+
+const (
+	// AppendAuto probes the server once per connection and picks
+	// AppendFlag when it honors O_APPEND, AppendOffset otherwise.
+	AppendAuto AppendStrategy = iota
+	// AppendFlag opens the file with O_APPEND and leaves positioning to
+	// the server. Concurrent appenders cannot interleave mid-write.
+	AppendFlag
+	// AppendOffset stats the file and writes at explicit offsets from its
+	// end. It works on every server but races with other appenders.
+	AppendOffset
+)
+
+func (s AppendStrategy) String() string {
+	switch s {
+	case AppendAuto:
+		return "auto"
+	case AppendFlag:
+		return "append-flag"
+	case AppendOffset:
+		return "offset"
+	}
+	return fmt.Sprintf("AppendStrategy(%d)", int(s))
+}
+
+// WithAppendStrategy forces the append strategy instead of probing the
+// server for it.
+func WithAppendStrategy(strategy AppendStrategy) Options {
+	return func(params *SFTPClientParams) error {
+		if strategy < AppendAuto || strategy > AppendOffset {
+			return fmt.Errorf("invalid append strategy: %d", int(strategy))
+		}
+		params.appendStrategy = strategy
+		params.record("WithAppendStrategy", strategy.String())
+		return nil
+	}
+}
+
+// ServerInfo describes the capabilities of the connected server.
+type ServerInfo struct {
+	Version string
+	// PosixRename tells whether the server supports renames over existing
+	// files.
+	PosixRename bool
+	// AppendStrategy is the forced strategy or the one detected by the
+	// append probe.
+	AppendStrategy AppendStrategy
+}
+
+// ServerInfo reports the capabilities of the server, probing its append
+// semantics in the working directory unless they were forced or already
+// probed on this connection. The probe writes and removes a temporary
+// file named like those of WithAtomic.
+func (client *SFTPClient) ServerInfo() (_ ServerInfo, err error) {
+	if client == nil {
+		return ServerInfo{}, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ServerInfo", "")(&err)
+
+	err = client.ensureConnected()
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	info := ServerInfo{Version: string(client.sshConn().ServerVersion())}
+	info.PosixRename = client.hasPosixRename()
+
+	wd, err := client.sftpConn().Getwd()
+	if err != nil {
+		return info, fmt.Errorf("failed to get working directory: %w", err)
+	}
+	info.AppendStrategy, err = client.appendStrategyIn(wd)
+	return info, err
+}
+
+// appendStrategyIn returns the strategy to append with, probing in dir on
+// first use per connection.
+func (client *SFTPClient) appendStrategyIn(dir string) (AppendStrategy, error) {
+	if forced := client.params.AppendStrategy(); forced != AppendAuto {
+		return forced, nil
+	}
+
+	client.appendMu.Lock()
+	defer client.appendMu.Unlock()
+	if client.appendProbed != AppendAuto && client.appendConn == client.sftpConn() {
+		return client.appendProbed, nil
+	}
+	strategy, err := client.probeAppend(dir)
+	if err != nil {
+		return AppendAuto, err
+	}
+	client.appendProbed = strategy
+	client.appendConn = client.sftpConn()
+	return strategy, nil
+}
+
+var appendProbeData = []byte("sftpc-append-probe")
+
+// probeAppend appends to a temporary file in dir with both strategies and
+// reads it back. Servers that ignore O_APPEND write at the offset the
+// client sends, which is 0 for a freshly opened handle.
+func (client *SFTPClient) probeAppend(dir string) (AppendStrategy, error) {
+	name, err := client.tempName(path.Join(dir, "append-probe"))
+	if err != nil {
+		return AppendAuto, err
+	}
+	defer client.sftpConn().Remove(name)
+
+	for _, strategy := range []AppendStrategy{AppendFlag, AppendOffset} {
+		err = client.writeAppendProbe(name, strategy)
+		if err != nil {
+			if strategy == AppendFlag {
+				continue
+			}
+			return AppendAuto, fmt.Errorf("failed to probe append support: %w", err)
+		}
+		got, err := client.readAppendProbe(name)
+		if err != nil {
+			return AppendAuto, fmt.Errorf("failed to probe append support: %w", err)
+		}
+		if bytes.Equal(got, append(appendProbeData, appendProbeData...)) {
+			return strategy, nil
+		}
+	}
+	return AppendAuto, fmt.Errorf("failed to probe append support: server appends with neither O_APPEND nor explicit offsets")
+}
+
+// writeAppendProbe writes the probe data into name twice, the second time with
+// strategy.
+func (client *SFTPClient) writeAppendProbe(name string, strategy AppendStrategy) error {
+	f, err := client.openRemote(context.Background(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(appendProbeData)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	f, _, err = client.openAppend(context.Background(), name, strategy)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(appendProbeData)
+	closeErr = f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (client *SFTPClient) readAppendProbe(name string) ([]byte, error) {
+	f, err := client.openRemote(context.Background(), name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, int64(3*len(appendProbeData))))
+}
+
+// openAppend opens remotePath, creating it if needed, for appending with
+// strategy and returns the offset writes start at.
+func (client *SFTPClient) openAppend(ctx context.Context, remotePath string, strategy AppendStrategy) (*remoteFile, int64, error) {
+	if strategy == AppendFlag {
+		f, err := client.openRemote(ctx, remotePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		return f, 0, err
+	}
+
+	f, err := client.openRemote(ctx, remotePath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Seek(info.Size(), io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// UploadAppend appends r to remotePath, creating it if missing, with the
+// strategy of WithAppendStrategy or the one probed on first use in the
+// directory of remotePath. StartOffset of the stats is the size the file
+// had with AppendOffset, 0 with AppendFlag.
+func (client *SFTPClient) UploadAppend(r io.Reader, remotePath string, opts ...TransferOption) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadAppend", remotePath)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.refuseDryRun("UploadAppend"); err != nil {
+		return nil, err
+	}
+	params.ctx = ctx
+	if params.resume || params.atomic || params.autoTempCleanup || params.verify {
+		return nil, fmt.Errorf("resume, atomic, temporary file and verification options do not apply to appends")
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	strategy, err := client.appendStrategyIn(path.Dir(remotePath))
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := client.startTransfer(params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	remoteFile, offset, err := client.openAppend(params.context(), remotePath, strategy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file for appending: %w", err)
+	}
+	defer remoteFile.Close()
+	stats := &TransferStats{RemotePath: remotePath, TotalSize: -1, StartOffset: offset, Attempts: 1}
+
+	src := newProgressReader(client.source(r, params), ProgressInfo{
+		Phase: PhaseTransfer,
+		Path:  remotePath,
+		Total: -1,
+	}, params.progress)
+
+	// Concurrent writes may reach the server out of order, which an O_APPEND
+	// handle turns into reordered data, so they are only used with offsets.
+	var dst io.Writer = remoteFile
+	if strategy == AppendFlag {
+		dst = struct{ io.Writer }{remoteFile}
+	}
+	stats.BytesTransferred, err = io.CopyBuffer(dst, src, make([]byte, streamChunkSize))
+	client.addBytes(params.context(), DirectionUpload, stats.BytesTransferred)
+	if err != nil {
+		err = fmt.Errorf("failed to append stream to remote: %w", err)
+	} else {
+		err = remoteFile.Close()
+		if err != nil {
+			err = fmt.Errorf("failed to close remote file: %w", err)
+		}
+	}
+
+	stats.TotalSize = offset + stats.BytesTransferred
+	stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+	stats.Duration = time.Since(start)
+	return stats, err
+}
@@ -0,0 +1,215 @@
+package sftpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// DefaultTempCleanupAge is the age after which WithAutoTempCleanup considers
+// a temporary file left behind by a crashed atomic upload to be orphaned.
+const DefaultTempCleanupAge = 24 * time.Hour
+
+var (
+	clientIDPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	clientIDReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// defaultClientID derives the client identifier from the host name.
+func defaultClientID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "sftpc"
+	}
+	id := clientIDReplacer.ReplaceAllString(hostname, "_")
+	if id == "" {
+		return "sftpc"
+	}
+	return id
+}
+
+// WithAtomic uploads into a temporary file next to the destination and
+// renames it into place once complete, so readers never observe a partial
+// file. Temporary files are named
+//
+//	.<name>.sftpc-<client id>-<8 hex digits>.tmp
+//
+// where the client id is set with WithClientID. It cannot be combined with
+// WithResume.
+func WithAtomic() TransferOption {
+	return func(params *transferParams) error {
+		params.atomic = true
+		return nil
+	}
+}
+
+// WithAutoTempCleanup removes this client's orphaned temporary files older
+// than DefaultTempCleanupAge from the destination directory before an atomic
+// upload. Cleanup failures are logged and do not fail the upload.
+func WithAutoTempCleanup() TransferOption {
+	return func(params *transferParams) error {
+		params.autoTempCleanup = true
+		return nil
+	}
+}
+
+// tempName returns a fresh temporary name in the directory of remotePath.
+func (client *SFTPClient) tempName(remotePath string) (string, error) {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate temporary name: %w", err)
+	}
+	dir, name := path.Split(remotePath)
+	return dir + "." + name + ".sftpc-" + client.params.ClientID() + "-" + hex.EncodeToString(suffix) + ".tmp", nil
+}
+
+// tempNamePattern matches only the temporary names generated by this client.
+// Destination names may contain newlines, hence the s flag.
+func (client *SFTPClient) tempNamePattern() *regexp.Regexp {
+	return regexp.MustCompile(`(?s)^\..+\.sftpc-` + regexp.QuoteMeta(client.params.ClientID()) + `-[0-9a-f]{8}\.tmp$`)
+}
+
+func (client *SFTPClient) putAtomic(localPath, remotePath string, params *transferParams) (*TransferStats, error) {
+	if params.resume {
+		return nil, fmt.Errorf("%w: atomic uploads always write a fresh temporary file, remove WithResume", ErrResumeUnsupported)
+	}
+
+	if params.autoTempCleanup {
+		_, err := client.cleanupTempFiles(path.Dir(remotePath), DefaultTempCleanupAge, false)
+		if err != nil {
+			client.params.Logger().Warnf("Temporary file cleanup failed: %v", err)
+		}
+	}
+
+	tmpPath, err := client.tempName(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The target is only replaced once complete and never removed, the
+	// temporary file is cleaned up here
+	tmpParams := *params
+	tmpParams.opened = nil
+	stats, err := client.putFile(localPath, tmpPath, &tmpParams)
+	if stats != nil {
+		stats.RemotePath = remotePath
+	}
+	if err != nil {
+		client.sftpConn().Remove(tmpPath)
+		return stats, err
+	}
+
+	err = client.replaceRemote(tmpPath, remotePath)
+	if err != nil {
+		client.sftpConn().Remove(tmpPath)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// WithoutPosixRename never uses the posix-rename extension, for servers
+// that advertise it but implement it wrongly. Atomic uploads then remove
+// the target before renaming over it.
+func WithoutPosixRename() Options {
+	return func(params *SFTPClientParams) error {
+		params.noPosixRename = true
+		params.record("WithoutPosixRename", "true")
+		return nil
+	}
+}
+
+// hasPosixRename reports whether renames may use posix-rename.
+func (client *SFTPClient) hasPosixRename() bool {
+	if client.params.NoPosixRename() {
+		return false
+	}
+	_, ok := client.sftpConn().HasExtension("posix-rename@openssh.com")
+	return ok
+}
+
+// replaceRemote renames oldPath over newPath, using posix-rename when the
+// server supports it since plain SFTP rename refuses existing targets.
+func (client *SFTPClient) replaceRemote(oldPath, newPath string) error {
+	if client.hasPosixRename() {
+		err := client.sftpConn().PosixRename(oldPath, newPath)
+		if err != nil {
+			return fmt.Errorf("failed to rename %q to %q: %w", oldPath, newPath, err)
+		}
+		return nil
+	}
+
+	err := client.sftpConn().Remove(newPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %q before rename: %w", newPath, err)
+	}
+	err = client.sftpConn().Rename(oldPath, newPath)
+	if err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %w", oldPath, newPath, err)
+	}
+	return nil
+}
+
+// CleanupTempFiles removes the temporary files left in dir by crashed atomic
+// uploads of this client (see WithAtomic for the naming scheme) whose
+// modification time is older than olderThan. Files of other clients and
+// tools are never touched.
+func (client *SFTPClient) CleanupTempFiles(dir string, olderThan time.Duration, recursive bool) (_ *BatchResult, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("CleanupTempFiles", dir)(&err)
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	return client.cleanupTempFiles(dir, olderThan, recursive)
+}
+
+func (client *SFTPClient) cleanupTempFiles(dir string, olderThan time.Duration, recursive bool) (*BatchResult, error) {
+	start := time.Now()
+	cutoff := start.Add(-olderThan)
+	pattern := client.tempNamePattern()
+	result := &BatchResult{Started: start}
+
+	visit := func(info RemoteFileInfo) error {
+		if info.IsDir() || !pattern.MatchString(info.Name()) || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		item := BatchItem{RemotePath: info.Path, Status: StatusRemoved}
+		err := client.sftpConn().Remove(info.Path)
+		if err != nil && !os.IsNotExist(err) {
+			item.Status = StatusFailed
+			item.Err = fmt.Errorf("failed to remove %q: %w", info.Path, err)
+		}
+		result.add(item)
+		return nil
+	}
+
+	var err error
+	if recursive {
+		err = client.walk(dir, &walkParams{sorted: true}, visit)
+	} else {
+		var entries []os.FileInfo
+		entries, err = client.readDir(dir)
+		if err == nil {
+			for _, entry := range entries {
+				visit(RemoteFileInfo{FileInfo: entry, Path: path.Join(dir, entry.Name())})
+			}
+		} else {
+			err = fmt.Errorf("failed to list directory %q: %w", dir, err)
+		}
+	}
+
+	result.Duration = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+	return result, result.err()
+}
@@ -0,0 +1,120 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrAborted is returned by Transfer.Wait for a transfer stopped by Abort.
+var ErrAborted = errors.New("transfer aborted")
+
+// Transfer is a transfer running in the background, see StartUpload and
+// StartDownload. Its methods are safe for concurrent use.
+type Transfer struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	transferred int64
+	total       int64
+	stats       *TransferStats
+	err         error
+}
+
+// StartUpload starts Put of localPath to remotePath in the background and
+// returns its handle.
+func (client *SFTPClient) StartUpload(localPath, remotePath string, opts ...TransferOption) (*Transfer, error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local file info: %w", quotePath(err))
+	}
+	return startTransfer(info.Size(), opts, func(ctx context.Context, opts []TransferOption) (*TransferStats, error) {
+		return client.PutContext(ctx, localPath, remotePath, opts...)
+	})
+}
+
+// StartDownload starts Get of remotePath into localPath in the background
+// and returns its handle.
+func (client *SFTPClient) StartDownload(remotePath, localPath string, opts ...TransferOption) (*Transfer, error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	return startTransfer(-1, opts, func(ctx context.Context, opts []TransferOption) (*TransferStats, error) {
+		return client.GetContext(ctx, remotePath, localPath, opts...)
+	})
+}
+
+func startTransfer(total int64, opts []TransferOption, run func(context.Context, []TransferOption) (*TransferStats, error)) (*Transfer, error) {
+	if _, err := newTransferParams(opts...); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	t := &Transfer{cancel: cancel, done: make(chan struct{}), total: total}
+	opts = append(opts[:len(opts):len(opts)], t.tapProgress)
+	go func() {
+		defer close(t.done)
+		defer cancel(nil)
+		stats, err := run(ctx, opts)
+		if err != nil && context.Cause(ctx) == ErrAborted {
+			err = fmt.Errorf("%w: %w", ErrAborted, err)
+		}
+		t.mu.Lock()
+		t.stats, t.err = stats, err
+		t.mu.Unlock()
+	}()
+	return t, nil
+}
+
+// tapProgress records the progress of the transfer, before passing it on to
+// the callback of WithProgress, if any. It must come after WithProgress.
+func (t *Transfer) tapProgress(params *transferParams) error {
+	next := params.progress
+	params.progress = func(info ProgressInfo) {
+		if info.Phase == PhaseTransfer {
+			t.mu.Lock()
+			t.transferred, t.total = info.Transferred, info.Total
+			t.mu.Unlock()
+		}
+		if next != nil {
+			next(info)
+		}
+	}
+	return nil
+}
+
+// Abort stops the transfer, closing its remote file, and makes Wait return
+// ErrAborted unless the transfer already completed. It does not wait for
+// the transfer to stop; Wait does.
+func (t *Transfer) Abort() {
+	t.cancel(ErrAborted)
+}
+
+// Wait waits for the transfer to end and returns its error.
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Progress returns the bytes of the file transferred so far, resumed bytes
+// included, and its total size, -1 while unknown.
+func (t *Transfer) Progress() (transferred, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.transferred, t.total
+}
+
+// Stats returns what was transferred once the transfer ended, nil before.
+func (t *Transfer) Stats() *TransferStats {
+	select {
+	case <-t.done:
+		return t.stats
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,301 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// throughputWindow is the number of whole seconds LabelStats.BytesPerSecond
+// averages over, the current one left out.
+const throughputWindow = 5
+
+// WithBandwidthLimit caps the transfer throughput of the client at
+// bytesPerSecond. Transfers in flight share the budget by label, see
+// WithTransferLabel and WithLabelWeight; the share of idle labels goes to
+// the busy ones.
+func WithBandwidthLimit(bytesPerSecond int64) Options {
+	return func(params *SFTPClientParams) error {
+		if bytesPerSecond <= 0 {
+			return fmt.Errorf("bandwidth limit must be positive, got %d", bytesPerSecond)
+		}
+		params.bandwidthLimit = bytesPerSecond
+		params.record("WithBandwidthLimit", strconv.FormatInt(bytesPerSecond, 10))
+		return nil
+	}
+}
+
+// WithLabelWeight gives the transfers labelled label weight shares of the
+// bandwidth limit, against one share for labels without a weight.
+func WithLabelWeight(label string, weight int) Options {
+	return func(params *SFTPClientParams) error {
+		if label == "" || weight <= 0 {
+			return fmt.Errorf("invalid weight %d for label %q", weight, label)
+		}
+		if params.labelWeights == nil {
+			params.labelWeights = make(map[string]int)
+		}
+		params.labelWeights[label] = weight
+		params.record("WithLabelWeight("+label+")", strconv.Itoa(weight))
+		return nil
+	}
+}
+
+// WithLabelMaxConcurrentFiles lets at most n files labelled label transfer
+// at once; further transfers of the label, including batch items, wait for
+// one of them to finish.
+func WithLabelMaxConcurrentFiles(label string, n int) Options {
+	return func(params *SFTPClientParams) error {
+		if label == "" || n <= 0 {
+			return fmt.Errorf("invalid concurrent file limit %d for label %q", n, label)
+		}
+		if params.labelMaxFiles == nil {
+			params.labelMaxFiles = make(map[string]int)
+		}
+		params.labelMaxFiles[label] = n
+		params.record("WithLabelMaxConcurrentFiles("+label+")", strconv.Itoa(n))
+		return nil
+	}
+}
+
+// WithTransferLabel accounts the transfer to label, typically a tenant, for
+// bandwidth sharing and ClientStats.Labels. Unlabelled transfers share the
+// empty label.
+func WithTransferLabel(label string) TransferOption {
+	return func(params *transferParams) error {
+		if label == "" {
+			return fmt.Errorf("transfer label must not be empty")
+		}
+		params.label = label
+		return nil
+	}
+}
+
+// LabelStats is the traffic of one transfer label.
+type LabelStats struct {
+	// Active is the number of files of the label transferring now.
+	Active int
+	Bytes  int64
+	// BytesPerSecond is the throughput over the last few seconds.
+	BytesPerSecond float64
+}
+
+// bandwidth shares the client's bandwidth limit between transfer labels by
+// weight and counts their traffic. Every active label drains its own debt at
+// its current share, so a label going idle speeds the others up at once.
+type bandwidth struct {
+	mu       sync.Mutex
+	rate     float64
+	weights  map[string]int
+	maxFiles map[string]int
+	labels   map[string]*labelState
+}
+
+type labelState struct {
+	active int
+	slots  chan struct{}
+	debt   float64
+	last   time.Time
+
+	bytes     int64
+	buckets   [throughputWindow + 1]int64
+	bucketSec int64
+}
+
+func newBandwidth(params *SFTPClientParams) *bandwidth {
+	return &bandwidth{
+		rate:     float64(params.BandwidthLimit()),
+		weights:  params.LabelWeights(),
+		maxFiles: params.LabelMaxConcurrentFiles(),
+		labels:   make(map[string]*labelState),
+	}
+}
+
+func (b *bandwidth) label(label string) *labelState {
+	st, ok := b.labels[label]
+	if !ok {
+		st = &labelState{}
+		if n := b.maxFiles[label]; n > 0 {
+			st.slots = make(chan struct{}, n)
+		}
+		b.labels[label] = st
+	}
+	return st
+}
+
+func (b *bandwidth) weight(label string) float64 {
+	if w, ok := b.weights[label]; ok {
+		return float64(w)
+	}
+	return 1
+}
+
+// start registers a file transfer of label, waiting for a slot when the
+// label has a concurrent file limit, and returns its release.
+func (b *bandwidth) start(ctx context.Context, label string) (func(), error) {
+	b.mu.Lock()
+	st := b.label(label)
+	b.mu.Unlock()
+
+	if st.slots != nil {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		select {
+		case st.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	b.mu.Lock()
+	st.active++
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			st.active--
+			b.mu.Unlock()
+			if st.slots != nil {
+				<-st.slots
+			}
+		})
+	}, nil
+}
+
+// reserve accounts n bytes to label at now and returns how long the
+// transfer must wait to stay within the label's share.
+func (b *bandwidth) reserve(label string, n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := b.label(label)
+	st.count(int64(n), now)
+	if b.rate == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for name, other := range b.labels {
+		if other.active > 0 || name == label {
+			total += b.weight(name)
+		}
+	}
+	share := b.rate * b.weight(label) / total
+
+	if !st.last.IsZero() {
+		st.debt -= now.Sub(st.last).Seconds() * share
+	}
+	if st.debt < 0 {
+		st.debt = 0
+	}
+	st.last = now
+	st.debt += float64(n)
+	return time.Duration(st.debt / share * float64(time.Second))
+}
+
+// chunk is the largest read worth throttling at once, about a tenth of a
+// second of the whole budget.
+func (b *bandwidth) chunk() int {
+	if b.rate == 0 {
+		return 0
+	}
+	return max(512, int(b.rate/10))
+}
+
+func (b *bandwidth) stats(now time.Time) map[string]LabelStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.labels) == 0 {
+		return nil
+	}
+	stats := make(map[string]LabelStats, len(b.labels))
+	for name, st := range b.labels {
+		sec := now.Unix()
+		st.advance(sec)
+		var recent int64
+		for i, n := range st.buckets {
+			if int64(i) != sec%int64(len(st.buckets)) {
+				recent += n
+			}
+		}
+		stats[name] = LabelStats{
+			Active:         st.active,
+			Bytes:          st.bytes,
+			BytesPerSecond: float64(recent) / throughputWindow,
+		}
+	}
+	return stats
+}
+
+func (st *labelState) count(n int64, now time.Time) {
+	sec := now.Unix()
+	st.advance(sec)
+	st.buckets[sec%int64(len(st.buckets))] += n
+	st.bytes += n
+}
+
+// advance clears the throughput buckets of the seconds elapsed up to sec.
+func (st *labelState) advance(sec int64) {
+	if sec <= st.bucketSec {
+		return
+	}
+	if sec-st.bucketSec >= int64(len(st.buckets)) {
+		clear(st.buckets[:])
+	} else {
+		for s := st.bucketSec + 1; s <= sec; s++ {
+			st.buckets[s%int64(len(st.buckets))] = 0
+		}
+	}
+	st.bucketSec = sec
+}
+
+// startTransfer registers a file transfer with the bandwidth sharing.
+func (client *SFTPClient) startTransfer(params *transferParams) (func(), error) {
+	release, err := client.bandwidth.start(params.ctx, params.label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for a transfer slot: %w", err)
+	}
+	return release, nil
+}
+
+// source wraps the reader feeding a transfer with its cancellation and
+// bandwidth accounting.
+func (client *SFTPClient) source(r io.Reader, params *transferParams) io.Reader {
+	return params.source(&labelReader{client: client, r: r, label: params.label, ctx: params.ctx})
+}
+
+// labelReader accounts what it reads to a label and waits out the label's
+// share of the bandwidth limit.
+type labelReader struct {
+	client *SFTPClient
+	r      io.Reader
+	label  string
+	ctx    context.Context
+}
+
+func (l *labelReader) Read(p []byte) (int, error) {
+	b := l.client.bandwidth
+	if chunk := b.chunk(); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		wait := b.reserve(l.label, n, l.client.now())
+		if wait > 0 {
+			ctx := l.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if serr := l.client.sleepContext(ctx, wait); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}
+	return n, err
+}
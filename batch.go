@@ -0,0 +1,100 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ItemStatus is the outcome of one entry of a batch operation.
+type ItemStatus string
+
+const (
+	StatusTransferred ItemStatus = "transferred"
+	StatusSkipped     ItemStatus = "skipped"
+	StatusFailed      ItemStatus = "failed"
+	StatusRemoved     ItemStatus = "removed"
+	// StatusCancelled marks items aborted on request, see TransferEvent.
+	// They are not failures and must not be retried.
+	StatusCancelled ItemStatus = "cancelled"
+	// StatusDeferred marks files left alone because another client is
+	// still uploading them, see WithUploaderConventions.
+	StatusDeferred ItemStatus = "deferred"
+	// StatusPlanned marks files a dry run would transfer or remove, see
+	// WithDryRun and WithRemoveDryRun.
+	StatusPlanned ItemStatus = "planned"
+)
+
+// BatchItem records what happened to a single file of a batch operation.
+type BatchItem struct {
+	LocalPath  string
+	RemotePath string
+	Status     ItemStatus
+	Stats      *TransferStats
+	Err        error
+	// Symlink is set when the source of the item is a symbolic link.
+	Symlink bool
+}
+
+// BatchResult summarizes a batch operation such as UploadDir or DownloadDir.
+type BatchResult struct {
+	Items            []BatchItem
+	Started          time.Time
+	DirsCreated      int
+	EmptyDirsCreated int
+	EmptyDirsPruned  int
+	Duration         time.Duration
+
+	// DryRun is set under WithDryRun and WithRemoveDryRun: nothing was
+	// written and DirsCreated counts the directories that would have been
+	// created.
+	DryRun bool
+
+	// Pauses and Paused count the waits for a transfer window, see
+	// WithTransferWindow.
+	Pauses int
+	Paused time.Duration
+
+	// Sample is set when WithVerificationSampling was used.
+	Sample *VerificationSample
+}
+
+// Count returns the number of items with the given status.
+func (r *BatchResult) Count(status ItemStatus) int {
+	n := 0
+	for _, item := range r.Items {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Bytes returns the number of bytes moved by all items.
+func (r *BatchResult) Bytes() int64 {
+	var n int64
+	for _, item := range r.Items {
+		if item.Stats != nil {
+			n += item.Stats.BytesTransferred
+		}
+	}
+	return n
+}
+
+func (r *BatchResult) add(item BatchItem) {
+	r.Items = append(r.Items, item)
+}
+
+// err joins the errors of all failed items, or returns nil.
+func (r *BatchResult) err() error {
+	var errs []error
+	for _, item := range r.Items {
+		if item.Status == StatusFailed && item.Err != nil {
+			errs = append(errs, item.Err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d items failed: %w", len(errs), len(r.Items), errors.Join(errs...))
+}
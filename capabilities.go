@@ -0,0 +1,226 @@
+package sftpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// Capabilities probed by CheckCapabilities.
+const (
+	CapabilityPosixRename     = "posix-rename"
+	CapabilityRenameOverwrite = "rename-overwrite"
+	CapabilityStatVFS         = "statvfs"
+	CapabilityHardlink        = "hardlink"
+	CapabilityFsync           = "fsync"
+	CapabilityAppend          = "append"
+	CapabilityResume          = "resume"
+	CapabilityChmod           = "chmod"
+	CapabilityChtimes         = "chtimes"
+	CapabilitySymlink         = "symlink"
+	// CapabilityStat is stat on uploaded files, which drop boxes refuse.
+	CapabilityStat = "stat"
+)
+
+// CapabilityResult tells whether the server supports one capability. Err
+// is set when the probe itself failed, in which case Supported is false.
+type CapabilityResult struct {
+	Name      string
+	Supported bool
+	Detail    string
+	Err       error
+}
+
+// CapabilityReport lists what a server supports, as probed by
+// CheckCapabilities. It doubles as a conformance report for partner
+// servers.
+type CapabilityReport struct {
+	Server   string
+	Dir      string
+	Started  time.Time
+	Duration time.Duration
+	Results  []CapabilityResult
+	// TimePrecision is how coarsely the server keeps modification times, at
+	// least one second; zero when it could not be measured.
+	TimePrecision time.Duration
+}
+
+// Supported reports whether the capability name was probed successfully.
+func (r *CapabilityReport) Supported(name string) bool {
+	for _, result := range r.Results {
+		if result.Name == name {
+			return result.Supported
+		}
+	}
+	return false
+}
+
+// CheckCapabilities probes the server for the capabilities the client
+// relies on, using scratch files in dir that it removes afterwards. A probe
+// that fails is recorded in its result; the error is only set when the
+// probes could not run at all.
+func (client *SFTPClient) CheckCapabilities(dir string) (_ *CapabilityReport, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("CheckCapabilities", dir)(&err)
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	report := &CapabilityReport{
+		Server:  string(client.sshConn().ServerVersion()),
+		Dir:     dir,
+		Started: time.Now(),
+	}
+	base, err := client.tempName(path.Join(dir, "capabilities"))
+	if err != nil {
+		return nil, err
+	}
+	scratch := []string{base + ".a", base + ".b", base + ".link"}
+	defer func() {
+		for _, p := range scratch {
+			client.sftpConn().Remove(p)
+		}
+	}()
+
+	err = client.writeProbe(scratch[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to write in %q: %w", dir, err)
+	}
+
+	probes := []struct {
+		name  string
+		probe func() (bool, string, error)
+	}{
+		{CapabilityPosixRename, client.extensionProbe("posix-rename@openssh.com")},
+		{CapabilityStatVFS, client.extensionProbe("statvfs@openssh.com")},
+		{CapabilityHardlink, client.extensionProbe("hardlink@openssh.com")},
+		{CapabilityFsync, client.extensionProbe("fsync@openssh.com")},
+		{CapabilityStat, func() (bool, string, error) {
+			_, err := client.sftpConn().Stat(scratch[0])
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			return true, "", nil
+		}},
+		{CapabilityRenameOverwrite, func() (bool, string, error) {
+			err := client.writeProbe(scratch[1])
+			if err != nil {
+				return false, "", err
+			}
+			err = client.sftpConn().Rename(scratch[1], scratch[0])
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			return true, "plain rename replaces existing files", nil
+		}},
+		{CapabilityAppend, func() (bool, string, error) {
+			strategy, err := client.probeAppend(dir)
+			return err == nil, strategy.String(), err
+		}},
+		{CapabilityResume, func() (bool, string, error) {
+			return client.resumeProbe(scratch[1])
+		}},
+		{CapabilityChmod, func() (bool, string, error) {
+			err := client.sftpConn().Chmod(scratch[1], 0640)
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			info, err := client.sftpConn().Stat(scratch[1])
+			if err != nil {
+				return false, "", err
+			}
+			return info.Mode().Perm() == 0640, fmt.Sprintf("mode %04o after chmod 0640", info.Mode().Perm()), nil
+		}},
+		{CapabilityChtimes, func() (bool, string, error) {
+			// An odd second shows servers rounding to two seconds
+			mtime := time.Date(2001, 2, 3, 4, 5, 7, 0, time.UTC)
+			err := client.sftpConn().Chtimes(scratch[1], mtime, mtime)
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			info, err := client.sftpConn().Stat(scratch[1])
+			if err != nil {
+				return false, "", err
+			}
+			off := info.ModTime().Sub(mtime).Abs()
+			if off >= time.Minute {
+				return false, "mtime " + info.ModTime().UTC().Format(time.RFC3339), nil
+			}
+			report.TimePrecision = off.Truncate(time.Second) + time.Second
+			return true, "precision " + report.TimePrecision.String(), nil
+		}},
+		{CapabilitySymlink, func() (bool, string, error) {
+			err := client.sftpConn().Symlink(path.Base(scratch[1]), scratch[2])
+			if err != nil {
+				return false, err.Error(), nil
+			}
+			info, err := client.sftpConn().Lstat(scratch[2])
+			if err != nil {
+				return false, "", err
+			}
+			return info.Mode()&os.ModeSymlink != 0, "", nil
+		}},
+	}
+	for _, p := range probes {
+		result := CapabilityResult{Name: p.name}
+		result.Supported, result.Detail, result.Err = p.probe()
+		if result.Err != nil {
+			result.Supported = false
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+func (client *SFTPClient) extensionProbe(name string) func() (bool, string, error) {
+	return func() (bool, string, error) {
+		version, ok := client.sftpConn().HasExtension(name)
+		if !ok {
+			return false, "", nil
+		}
+		return true, name + " version " + version, nil
+	}
+}
+
+// resumeProbe writes the second half of a file through a handle opened
+// without truncation at an offset, the way resumed uploads do, and checks
+// that the first half survived.
+func (client *SFTPClient) resumeProbe(p string) (bool, string, error) {
+	err := client.writeProbe(p)
+	if err != nil {
+		return false, "", err
+	}
+	want, err := client.readAppendProbe(p)
+	if err != nil {
+		return false, "", err
+	}
+
+	f, err := client.openRemote(context.Background(), p, os.O_WRONLY)
+	if err != nil {
+		return false, "", err
+	}
+	half := int64(len(want) / 2)
+	_, err = f.WriteAt(want[half:], half)
+	f.Close()
+	if err != nil {
+		return false, err.Error(), nil
+	}
+
+	got, err := client.readAppendProbe(p)
+	if err != nil {
+		return false, "", err
+	}
+	if !bytes.Equal(got, want) {
+		return false, fmt.Sprintf("read back %d bytes, want %d", len(got), len(want)), nil
+	}
+	return true, "", nil
+}
@@ -0,0 +1,100 @@
+package sftpc
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// channelPool holds the SFTP channels multiplexed over the client's single
+// SSH connection. Channel 0 is the client's primary sftp.Client, used for
+// metadata operations; file handles are spread over all channels.
+type channelPool struct {
+	mu      sync.Mutex
+	clients []*sftp.Client
+	busy    []int
+}
+
+// openChannels opens n-1 more SFTP channels next to primary.
+func openChannels(sshClient *ssh.Client, primary *sftp.Client, n int) (*channelPool, error) {
+	pool := &channelPool{clients: []*sftp.Client{primary}, busy: []int{0}}
+	var u unwinder
+	defer u.unwind()
+	u.add(pool.closeExtra)
+	for i := 1; i < n; i++ {
+		channel, err := newSFTPChannel(sshClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SFTP channel %d: %w", i, err)
+		}
+		pool.clients = append(pool.clients, channel)
+		pool.busy = append(pool.busy, 0)
+	}
+	u.release()
+	return pool, nil
+}
+
+// acquire returns the least busy channel and its index.
+func (pool *channelPool) acquire() (int, *sftp.Client) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	best := 0
+	for i := range pool.busy {
+		if pool.busy[i] < pool.busy[best] {
+			best = i
+		}
+	}
+	pool.busy[best]++
+	return best, pool.clients[best]
+}
+
+func (pool *channelPool) release(i int) {
+	pool.mu.Lock()
+	pool.busy[i]--
+	pool.mu.Unlock()
+}
+
+// replace swaps channel i for a fresh one over sshClient. The primary
+// channel is never replaced here, a broken primary means reconnecting.
+func (pool *channelPool) replace(i int, sshClient *ssh.Client) error {
+	if i == 0 {
+		return fmt.Errorf("the primary SFTP channel cannot be replaced")
+	}
+	channel, err := newSFTPChannel(sshClient)
+	if err != nil {
+		return fmt.Errorf("failed to reopen SFTP channel %d: %w", i, err)
+	}
+	pool.mu.Lock()
+	old := pool.clients[i]
+	pool.clients[i] = channel
+	pool.mu.Unlock()
+	old.Close()
+	return nil
+}
+
+// closeExtra closes every channel but the primary.
+func (pool *channelPool) closeExtra() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, channel := range pool.clients[1:] {
+		channel.Close()
+	}
+}
+
+// size returns the number of channels.
+func (pool *channelPool) size() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return len(pool.clients)
+}
+
+// sshAlive reports whether the SSH connection still answers requests.
+func (client *SFTPClient) sshAlive() bool {
+	sshClient := client.sshConn()
+	if sshClient == nil {
+		return false
+	}
+	_, _, err := sshClient.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
@@ -0,0 +1,85 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// ClaimFile takes ownership of srcPath by renaming it into the directory of
+// the consumer below claimDir, claimDir/<client id>, created if needed, and
+// returns the claimed path. When several consumers race for the same file,
+// exactly one of them wins; the others get ErrAlreadyClaimed. The rename is
+// never retried. When it fails without a clear answer from the server, for
+// instance because the connection dropped, the claimed path is checked to
+// learn whether the rename happened before reporting; the client id keeps
+// the claim of another consumer from passing for ours, so consumers on the
+// same host need distinct WithClientID. An error that is neither nil nor
+// ErrAlreadyClaimed leaves the file unclaimed unless it says the outcome is
+// unknown.
+func (client *SFTPClient) ClaimFile(srcPath, claimDir string) (_ string, err error) {
+	if client == nil {
+		return "", fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ClaimFile", srcPath)(&err)
+
+	err = client.ensureConnected()
+	if err != nil {
+		return "", fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	ownDir := path.Join(claimDir, client.params.ClientID())
+	claimed := path.Join(ownDir, path.Base(srcPath))
+	err = client.mkdirAll(ownDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to create claim directory %q: %w", ownDir, err)
+	}
+	// A leftover at the claimed path would make a failed rename look like a
+	// won claim, and plain SFTP rename refuses existing targets anyway.
+	_, err = client.sftpConn().Lstat(claimed)
+	if err == nil {
+		return "", fmt.Errorf("failed to claim %q: %w: %q", srcPath, ErrDestinationExists, claimed)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to get remote file info: %w", err)
+	}
+
+	// Plain rename, not posix-rename: it must never replace anything.
+	err = client.sftpConn().Rename(srcPath, claimed)
+	if err == nil {
+		return claimed, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %q", ErrAlreadyClaimed, srcPath)
+	}
+	return client.resolveClaim(srcPath, claimed, err)
+}
+
+// resolveClaim finds out whether a rename that failed with renameErr took
+// place after all.
+func (client *SFTPClient) resolveClaim(srcPath, claimed string, renameErr error) (string, error) {
+	unknown := func(err error) (string, error) {
+		return "", fmt.Errorf("failed to claim %q, outcome unknown: %w (checking claim: %v)", srcPath, renameErr, err)
+	}
+
+	err := client.ensureConnected()
+	if err != nil {
+		return unknown(err)
+	}
+	_, err = client.sftpConn().Lstat(claimed)
+	if err == nil {
+		return claimed, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return unknown(err)
+	}
+	_, err = client.sftpConn().Lstat(srcPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %q", ErrAlreadyClaimed, srcPath)
+	}
+	if err != nil {
+		return unknown(err)
+	}
+	return "", fmt.Errorf("failed to claim %q: %w", srcPath, renameErr)
+}
@@ -0,0 +1,42 @@
+package sftpc
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Client is the set of operations services usually depend on. *SFTPClient
+// implements it; the sftpcmock package provides fakes for tests.
+type Client interface {
+	Get(remotePath, localPath string, opts ...TransferOption) (*TransferStats, error)
+	Put(localPath, remotePath string, opts ...TransferOption) (*TransferStats, error)
+	Upload(r io.Reader, remotePath string, opts ...TransferOption) (*TransferStats, error)
+	UploadAppend(r io.Reader, remotePath string, opts ...TransferOption) (*TransferStats, error)
+	UploadDir(localDir, remoteDir string, opts ...TransferOption) (*BatchResult, error)
+	DownloadDir(remoteDir, localDir string, opts ...TransferOption) (*BatchResult, error)
+	DiffLocalRemote(localDir, remoteDir string, opts ...TransferOption) (*DiffReport, error)
+	CleanupTempFiles(dir string, olderThan time.Duration, recursive bool) (*BatchResult, error)
+
+	Walk(root string, fn func(info RemoteFileInfo) error, opts ...WalkOption) error
+	ExportInventory(root string, format InventoryFormat, w io.Writer, opts ...WalkOption) error
+	Snapshot(root string, opts ...WalkOption) (*TreeSnapshot, error)
+	List(remotePath string) ([]os.FileInfo, error)
+	FileInfo(filePath string) (os.FileInfo, error)
+	Exists(remotePath string) (bool, error)
+	DirExists(remotePath string) (bool, error)
+
+	MakeDir(remotePath string) error
+	MakeDirAll(remotePath string) error
+	RemoveDir(remotePath string) error
+	RemoveDirIfEmpty(remotePath string) (bool, error)
+	RemoveAll(remotePath string, opts ...RemoveOption) (*BatchResult, error)
+	RemoveFile(remotePath string) error
+	MoveFile(oldPath, newPath string) error
+	ClaimFile(srcPath, claimDir string) (string, error)
+
+	Stats() ClientStats
+	Close()
+}
+
+var _ Client = (*SFTPClient)(nil)
@@ -0,0 +1,191 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrConflictingOptions is wrapped by every ConfigError.
+var ErrConflictingOptions = errors.New("conflicting options")
+
+// ConfigError reports options that cannot be combined.
+type ConfigError struct {
+	Options []string
+	Reason  string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("conflicting options %s: %s", strings.Join(e.Options, ", "), e.Reason)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrConflictingOptions
+}
+
+// Required parameters reported by MissingParamsError.
+var (
+	ErrMissingHost  = errors.New("no host, see WithHost")
+	ErrMissingUser  = errors.New("no user, see WithUser")
+	ErrNoAuthMethod = errors.New("no authentication method, see WithPassword, WithPrivateKeyPath, WithPrivateKeyBytes and WithSigner")
+)
+
+// MissingParamsError lists every required parameter a client was created
+// without, each one of ErrMissingHost, ErrMissingUser and ErrNoAuthMethod.
+type MissingParamsError struct {
+	Missing []error
+}
+
+func (e *MissingParamsError) Error() string {
+	msgs := make([]string, len(e.Missing))
+	for i, err := range e.Missing {
+		msgs[i] = err.Error()
+	}
+	return "missing required parameters: " + strings.Join(msgs, "; ")
+}
+
+func (e *MissingParamsError) Unwrap() []error {
+	return e.Missing
+}
+
+// checkRequired reports the parameters a client cannot connect without. A
+// host is not needed to dial a unix socket or through a dialer, nor
+// by a client created from a connection, which passes needHost false. A
+// credential provider stands in for the user and the auth method.
+func (p *SFTPClientParams) checkRequired(needHost bool) error {
+	var missing []error
+	if needHost && p.host == "" && p.unixSocket == "" && p.redial == nil && p.dialer == nil {
+		missing = append(missing, ErrMissingHost)
+	}
+	if p.user == "" && p.credentialProvider == nil {
+		missing = append(missing, ErrMissingUser)
+	}
+	if !p.hasCredentials() && p.credentialProvider == nil {
+		missing = append(missing, ErrNoAuthMethod)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &MissingParamsError{Missing: missing}
+}
+
+// record notes that the option name was applied with value, once it was
+// validated. Options applied again override the earlier value, as when user
+// options are layered over defaults; the repeat is only logged. Secrets are
+// recorded with secretValue.
+func (p *SFTPClientParams) record(name, value string) {
+	if p.applied == nil {
+		p.applied = make(map[string]string)
+	}
+	if prev, ok := p.applied[name]; ok {
+		p.repeated = append(p.repeated, optionRepeat{name: name, overridden: prev != value})
+	}
+	p.applied[name] = value
+}
+
+// secretValue is what record keeps of a secret: whether it is empty, never
+// the secret itself.
+func secretValue[T string | []byte](secret T) string {
+	if len(secret) == 0 {
+		return "empty"
+	}
+	return "set"
+}
+
+func (p *SFTPClientParams) isSet(name string) bool {
+	_, ok := p.applied[name]
+	return ok
+}
+
+// checkPort rejects ports that are not a number from 1 to 65535, which
+// would otherwise only fail when dialing.
+func (p *SFTPClientParams) checkPort() error {
+	if p.port == "" {
+		return nil
+	}
+	port, err := strconv.Atoi(p.port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port: %q", p.port)
+	}
+	return nil
+}
+
+// checkConflicts rejects combinations of options that cannot all be honored.
+func (p *SFTPClientParams) checkConflicts() error {
+	var primaryKeys []string
+	for _, name := range []string{"WithPrivateKeyPath", "WithPrivateKeyB64", "WithPrivateKeyBytes"} {
+		if p.isSet(name) {
+			primaryKeys = append(primaryKeys, name)
+		}
+	}
+	var hostKeys []string
+	for _, name := range append(hostKeyOptions, "WithInsecureHostKey") {
+		if p.isSet(name) {
+			hostKeys = append(hostKeys, name)
+		}
+	}
+
+	switch {
+	case len(primaryKeys) > 1:
+		return &ConfigError{
+			Options: primaryKeys,
+			Reason:  "only one primary key can be set, pass the other one with WithAdditionalKey",
+		}
+	case p.isSet("WithHost") && p.host != "" && p.unixSocket != "":
+		return &ConfigError{
+			Options: []string{"WithHost", "WithUnixSocket"},
+			Reason:  "a unix socket has no host",
+		}
+	case p.isSet("WithHosts") && p.isSet("WithHost"):
+		return &ConfigError{
+			Options: []string{"WithHosts", "WithHost"},
+			Reason:  "pass every host to WithHosts",
+		}
+	case p.isSet("WithHosts") && (p.unixSocket != "" || p.redial != nil):
+		return &ConfigError{
+			Options: []string{"WithHosts", "WithUnixSocket/WithRedialFunc"},
+			Reason:  "only dialed hosts can fall back to one another",
+		}
+	case p.isSet("WithSOCKS5Proxy") && (p.unixSocket != "" || p.redial != nil || p.dialer != nil):
+		return &ConfigError{
+			Options: []string{"WithSOCKS5Proxy", "WithUnixSocket/WithRedialFunc/WithDialer"},
+			Reason:  "the proxy only dials TCP connections",
+		}
+	case p.isSet("WithQuiet") && p.isSet("WithProgressWriter"):
+		return &ConfigError{
+			Options: []string{"WithQuiet", "WithProgressWriter"},
+			Reason:  "quiet clients write no progress",
+		}
+	case p.isSet("WithDialer") && p.isSet("WithRedialFunc"):
+		return &ConfigError{
+			Options: []string{"WithDialer", "WithRedialFunc"},
+			Reason:  "only one way to dial can be set",
+		}
+	case len(hostKeys) > 1:
+		return &ConfigError{
+			Options: hostKeys,
+			Reason:  "only one host key verification can be set",
+		}
+	case p.isSet("WithKeyboardInteractive") && p.isSet("WithKeyboardInteractivePassword"):
+		return &ConfigError{
+			Options: []string{"WithKeyboardInteractive", "WithKeyboardInteractivePassword"},
+			Reason:  "only one keyboard-interactive handler can be set",
+		}
+	case p.isSet("WithPassword") && p.password == "" && p.passphrase == "" && (p.privateKeyPath != "" || len(p.privateKeyB64) > 0):
+		return &ConfigError{
+			Options: []string{"WithPassword", "WithPrivateKeyPath/WithPrivateKeyB64/WithPrivateKeyBytes"},
+			Reason:  "the password doubles as the key passphrase and was explicitly set empty, omit WithPassword for unencrypted keys",
+		}
+	}
+	return nil
+}
+
+// ValidateOptions applies opts without connecting and reports the same
+// errors NewSFTPClient would, including conflicting combinations. Missing
+// required parameters are left out, so that partial sets of options can be
+// checked too.
+func ValidateOptions(opts ...Options) error {
+	_, err := newsSFTPClientParams(opts...)
+	return err
+}
@@ -0,0 +1,73 @@
+package sftpc
+
+import (
+	"fmt"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// unwinder collects the cleanups of the resources acquired while building
+// something and runs them in reverse order when a later step fails:
+//
+//	var u unwinder
+//	defer u.unwind()
+//	... acquire, then u.add(cleanup) for each resource ...
+//	u.release()
+type unwinder struct {
+	cleanups []func()
+	released bool
+}
+
+func (u *unwinder) add(cleanup func()) {
+	u.cleanups = append(u.cleanups, cleanup)
+}
+
+// release keeps the resources, construction succeeded.
+func (u *unwinder) release() {
+	u.released = true
+}
+
+// unwind releases the resources unless release was called.
+func (u *unwinder) unwind() {
+	if u.released {
+		return
+	}
+	for i := len(u.cleanups) - 1; i >= 0; i-- {
+		u.cleanups[i]()
+	}
+	u.cleanups = nil
+}
+
+// newSFTPChannel starts an SFTP subsystem over sshClient like
+// sftp.NewClient, which leaves the session open on the connection when the
+// server refuses the subsystem.
+func newSFTPChannel(sshClient *ssh.Client) (*sftp.Client, error) {
+	var u unwinder
+	defer u.unwind()
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	u.add(func() { session.Close() })
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP subsystem: %w", err)
+	}
+	client, err := sftp.NewClientPipe(r, w)
+	if err != nil {
+		return nil, err
+	}
+	u.release()
+	return client, nil
+}
@@ -0,0 +1,192 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// credentialOptions are the options UpdateCredentials accepts, next to
+// WithAdditionalKey and WithSigner.
+var credentialOptions = []string{
+	"WithUser",
+	"WithPassword",
+	"WithPrivateKeyPassphrase",
+	"WithPrivateKeyPath",
+	"WithPrivateKeyB64",
+	"WithPrivateKeyBytes",
+	"WithKeyboardInteractive",
+	"WithKeyboardInteractivePassword",
+}
+
+// CredentialSet is what a credential provider returns: a password, a PEM
+// private key or signers, and optionally the user. An empty user keeps the
+// current one.
+type CredentialSet struct {
+	User       string
+	Password   string
+	PrivateKey []byte
+	Passphrase string
+	Signers    []ssh.Signer
+}
+
+// options turns the set into the options UpdateCredentials applies.
+func (set CredentialSet) options() ([]Options, error) {
+	if set.Password == "" && len(set.PrivateKey) == 0 && len(set.Signers) == 0 {
+		return nil, fmt.Errorf("credential set has no password or key")
+	}
+	var opts []Options
+	if set.User != "" {
+		opts = append(opts, WithUser(set.User))
+	}
+	if set.Password != "" {
+		opts = append(opts, WithPassword(set.Password))
+	}
+	if len(set.PrivateKey) > 0 {
+		opts = append(opts, WithPrivateKeyBytes(set.PrivateKey))
+	}
+	if set.Passphrase != "" {
+		opts = append(opts, WithPrivateKeyPassphrase(set.Passphrase))
+	}
+	for _, signer := range set.Signers {
+		opts = append(opts, WithSigner(signer))
+	}
+	return opts, nil
+}
+
+// WithCredentialProvider fetches the credentials from provider on every
+// connect and reconnect, so rotated secrets are picked up without calling
+// UpdateCredentials. When provider fails, or returns credentials that do
+// not validate, the last good credentials are used and a warning is
+// logged, unless WithoutCredentialFallback.
+func WithCredentialProvider(provider func(ctx context.Context) (CredentialSet, error)) Options {
+	return func(params *SFTPClientParams) error {
+		if provider == nil {
+			return fmt.Errorf("credential provider must not be nil")
+		}
+		params.credentialProvider = provider
+		params.record("WithCredentialProvider", fmt.Sprintf("%p", provider))
+		return nil
+	}
+}
+
+// WithoutCredentialFallback fails connecting when the credential provider
+// fails, instead of falling back to the last good credentials.
+func WithoutCredentialFallback() Options {
+	return func(params *SFTPClientParams) error {
+		params.noCredentialFallback = true
+		params.record("WithoutCredentialFallback", "true")
+		return nil
+	}
+}
+
+// UpdateCredentials replaces the user, password and keys the client
+// authenticates with by those set in opts, which may only be credential
+// options. The credentials are validated as NewSFTPClient would and used
+// from the next reconnect on; the current connection is left alone.
+func (client *SFTPClient) UpdateCredentials(opts ...Options) error {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	client.credMu.Lock()
+	defer client.credMu.Unlock()
+	return client.params.updateCredentials(opts...)
+}
+
+func (p *SFTPClientParams) updateCredentials(opts ...Options) error {
+	fresh := &SFTPClientParams{}
+	for _, opt := range opts {
+		if err := opt(fresh); err != nil {
+			return err
+		}
+	}
+	for name := range fresh.applied {
+		if !slices.Contains(credentialOptions, name) {
+			return fmt.Errorf("cannot update %s on a client, only credentials", name)
+		}
+	}
+	if fresh.labelWeights != nil || fresh.labelMaxFiles != nil {
+		return fmt.Errorf("cannot update label limits on a client, only credentials")
+	}
+	if fresh.user == "" {
+		fresh.user = p.user
+	}
+
+	candidate := *p
+	candidate.applied = maps.Clone(p.applied)
+	for _, name := range credentialOptions {
+		if name != "WithUser" || fresh.user != p.user {
+			delete(candidate.applied, name)
+		}
+	}
+	maps.Copy(candidate.applied, fresh.applied)
+	candidate.copyCredentials(fresh)
+
+	if err := candidate.checkConflicts(); err != nil {
+		return err
+	}
+	if err := candidate.checkSecurity(); err != nil {
+		return err
+	}
+	if !candidate.hasCredentials() {
+		return &MissingParamsError{Missing: []error{ErrNoAuthMethod}}
+	}
+	// Parse the keys now rather than on the next reconnect
+	if _, err := candidate.sshClientConfig(0); err != nil {
+		return err
+	}
+
+	p.applied = candidate.applied
+	p.copyCredentials(&candidate)
+	return nil
+}
+
+// copyCredentials sets the credentials of p to those of src.
+func (p *SFTPClientParams) copyCredentials(src *SFTPClientParams) {
+	p.user = src.user
+	p.password = src.password
+	p.passphrase = src.passphrase
+	p.privateKeyPath = src.privateKeyPath
+	p.privateKeyB64 = src.privateKeyB64
+	p.additionalKeys = src.additionalKeys
+	p.signers = src.signers
+	p.keyboardInteractive = src.keyboardInteractive
+}
+
+// hasCredentials reports whether p holds any way to authenticate.
+func (p *SFTPClientParams) hasCredentials() bool {
+	return p.password != "" || p.hasKeys() || p.keyboardInteractive != nil
+}
+
+// fetchCredentials swaps in the credentials of the provider, if any, falling
+// back to the last good ones unless WithoutCredentialFallback.
+func (client *SFTPClient) fetchCredentials(ctx context.Context) error {
+	provider := client.params.CredentialProvider()
+	if provider == nil {
+		return nil
+	}
+
+	set, err := provider(ctx)
+	if err == nil {
+		var opts []Options
+		opts, err = set.options()
+		if err == nil {
+			err = client.UpdateCredentials(opts...)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	client.credMu.RLock()
+	fallback := client.params.hasCredentials()
+	client.credMu.RUnlock()
+	if client.params.NoCredentialFallback() || !fallback {
+		return fmt.Errorf("failed to fetch credentials: %w", err)
+	}
+	client.params.Logger().Warnf("Failed to fetch credentials, using the last good ones: %v", err)
+	return nil
+}
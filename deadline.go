@@ -0,0 +1,74 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned, wrapping the last failure, when an
+// operation runs out of the time WithMaxOperationTime gives it.
+var ErrDeadlineExceeded = errors.New("maximum operation time exceeded")
+
+// WithMaxOperationTime caps the wall-clock time of every public operation,
+// its reconnects and retries included: dials get no more than what is left,
+// and retries that would start past the deadline are not made. A single
+// request already sent to the server is not interrupted. Zero, the default,
+// means no cap.
+func WithMaxOperationTime(d time.Duration) Options {
+	return func(params *SFTPClientParams) error {
+		if d < 0 {
+			return fmt.Errorf("invalid maximum operation time: %s", d)
+		}
+		params.maxOperationTime = d
+		params.record("WithMaxOperationTime", d.String())
+		return nil
+	}
+}
+
+// operationContext bounds an operation running under parent, which may be
+// nil, by WithMaxOperationTime.
+func (client *SFTPClient) operationContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	d := client.params.MaxOperationTime()
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+	budget := time.Now().Add(d)
+	ctx, cancel := context.WithTimeoutCause(parent, d, ErrDeadlineExceeded)
+	return context.WithValue(ctx, operationDeadlineKey{}, budget), cancel
+}
+
+// operationDeadlineKey holds the deadline WithMaxOperationTime gave the
+// operation of a context.
+type operationDeadlineKey struct{}
+
+// deadlineError reports err as ErrDeadlineExceeded when the operation of
+// ctx ran out of time.
+func deadlineError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrDeadlineExceeded) || context.Cause(ctx) != ErrDeadlineExceeded {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrDeadlineExceeded, err)
+}
+
+// pause waits d before the next attempt of the operation of ctx, which
+// failed last with err. It gives up when ctx is done, or would be by then,
+// with ErrDeadlineExceeded when the deadline is the one of
+// WithMaxOperationTime and the error of ctx otherwise.
+func (client *SFTPClient) pause(ctx context.Context, d time.Duration, err error) error {
+	if deadline, ok := ctx.Deadline(); ok && client.now().Add(d).After(deadline) {
+		cause := context.DeadlineExceeded
+		if budget, ok := ctx.Value(operationDeadlineKey{}).(time.Time); ok && !budget.After(deadline) {
+			cause = ErrDeadlineExceeded
+		}
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	if client.sleepContext(ctx, d) != nil {
+		return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package sftpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Decoder describes a compression format that can be detected from the
+// leading magic bytes of a remote stream and decoded transparently.
+type Decoder struct {
+	Name      string
+	Magic     []byte
+	Suffix    string
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+func (d *Decoder) stripSuffix(name string) string {
+	if d.Suffix == "" || !strings.HasSuffix(name, d.Suffix) || name == d.Suffix {
+		return name
+	}
+	return strings.TrimSuffix(name, d.Suffix)
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = []*Decoder{
+		{
+			Name:   "gzip",
+			Magic:  []byte{0x1f, 0x8b},
+			Suffix: ".gz",
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			Name:   "bzip2",
+			Magic:  []byte("BZh"),
+			Suffix: ".bz2",
+			NewReader: func(r io.Reader) (io.Reader, error) {
+				return bzip2.NewReader(r), nil
+			},
+		},
+	}
+)
+
+// RegisterDecoder adds a decoder used by WithAutoDecompress, for example
+// for zstd which is not part of the standard library. A decoder with the
+// same name replaces the existing one.
+func RegisterDecoder(decoder Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	for i, d := range decoders {
+		if d.Name == decoder.Name {
+			decoders[i] = &decoder
+			return
+		}
+	}
+	decoders = append(decoders, &decoder)
+}
+
+// WithAutoDecompress sniffs the remote stream and transparently decompresses
+// it when it starts with the magic bytes of a registered decoder. Streams
+// that are not compressed are copied unchanged. It cannot be combined with
+// WithResume.
+func WithAutoDecompress() TransferOption {
+	return func(params *transferParams) error {
+		params.autoDecompress = true
+		return nil
+	}
+}
+
+// WithStripCompressionSuffix removes the decoder suffix (".gz", ".bz2")
+// from the local file name when the stream was decompressed.
+func WithStripCompressionSuffix() TransferOption {
+	return func(params *transferParams) error {
+		params.stripCompressionSuffix = true
+		return nil
+	}
+}
+
+// decompressStream peeks at the head of r without consuming it and returns a
+// decoding reader for the matching decoder, or the buffered stream untouched
+// with a nil decoder when nothing matches.
+func decompressStream(r io.Reader) (io.Reader, *Decoder, error) {
+	decodersMu.RLock()
+	candidates := make([]*Decoder, len(decoders))
+	copy(candidates, decoders)
+	decodersMu.RUnlock()
+
+	peekLen := 0
+	for _, d := range candidates {
+		if len(d.Magic) > peekLen {
+			peekLen = len(d.Magic)
+		}
+	}
+
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(peekLen)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	for _, d := range candidates {
+		if len(d.Magic) > 0 && bytes.HasPrefix(head, d.Magic) {
+			decoded, err := d.NewReader(buffered)
+			if err != nil {
+				return nil, nil, err
+			}
+			return decoded, d, nil
+		}
+	}
+
+	return buffered, nil, nil
+}
@@ -0,0 +1,111 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// dirCache remembers the remote directories known to exist on the current
+// connection, so that bulk uploads do not check the same parents over and
+// over. Paths are cleaned but not resolved: "a" and "/home/u/a" are
+// separate entries.
+type dirCache struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+func (c *dirCache) has(p string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirs[path.Clean(p)]
+}
+
+func (c *dirCache) add(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dirs == nil {
+		c.dirs = make(map[string]bool)
+	}
+	c.dirs[path.Clean(p)] = true
+}
+
+// forget drops p and everything below it.
+func (c *dirCache) forget(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p = path.Clean(p)
+	for dir := range c.dirs {
+		if p == "/" || p == "." && !path.IsAbs(dir) || dir == p || strings.HasPrefix(dir, p+"/") {
+			delete(c.dirs, dir)
+		}
+	}
+}
+
+func (c *dirCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs = nil
+}
+
+// mkdir creates the remote directory p unless it already exists and
+// reports whether it was created. Losing a race against another writer
+// creating p is not an error.
+func (client *SFTPClient) mkdir(p string) (bool, error) {
+	if client.dirs.has(p) {
+		return false, nil
+	}
+
+	info, err := client.sftpConn().Stat(p)
+	if err == nil {
+		if !info.IsDir() {
+			return false, fmt.Errorf("%w: %q exists", ErrNotADirectory, p)
+		}
+		client.dirs.add(p)
+		return false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+
+	err = client.sftpConn().Mkdir(p)
+	if err != nil {
+		info, statErr := client.sftpConn().Stat(p)
+		if statErr != nil || !info.IsDir() {
+			return false, fmt.Errorf("failed to create directory %q: %w", p, err)
+		}
+		client.dirs.add(p)
+		return false, nil
+	}
+	client.dirs.add(p)
+	return true, nil
+}
+
+// mkdirAll creates p and its missing parents, tolerating concurrent
+// creation like mkdir.
+func (client *SFTPClient) mkdirAll(p string) error {
+	p = path.Clean(p)
+	if client.dirs.has(p) {
+		return nil
+	}
+	info, err := client.sftpConn().Stat(p)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %q exists", ErrNotADirectory, p)
+		}
+		client.dirs.add(p)
+		return nil
+	}
+
+	if parent := path.Dir(p); parent != p && parent != "." && parent != "/" {
+		err = client.mkdirAll(parent)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = client.mkdir(p)
+	return err
+}
@@ -0,0 +1,360 @@
+package sftpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// SFTP version 3 packet types and status codes used by dirStream.
+const (
+	fxpInit      = 1
+	fxpVersion   = 2
+	fxpClose     = 4
+	fxpOpendir   = 11
+	fxpReaddir   = 12
+	fxpStatus    = 101
+	fxpHandle    = 102
+	fxpName      = 104
+	fxEOF        = 1
+	fxNoSuchFile = 2
+	fxPermission = 3
+
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// errDirEnd is the status ending a directory listing, told apart from the
+// io.EOF of a closed channel.
+var errDirEnd = errors.New("end of directory")
+
+// maxDirPacket bounds the size of a single readdir reply.
+const maxDirPacket = 4 << 20
+
+// dirStream reads a remote directory one readdir reply at a time over a
+// dedicated SFTP channel. The sftp package only returns whole listings,
+// which does not fit in memory for very large directories.
+type dirStream struct {
+	w      io.WriteCloser
+	r      *bufio.Reader
+	close  func() error
+	id     uint32
+	handle string
+	buf    []byte
+}
+
+// openDirStream opens remotePath on a new SFTP channel, which takes a slot
+// of the client's handle limiter until the stream is closed. Waiting for the
+// slot gives up once ctx is done.
+func (client *SFTPClient) openDirStream(ctx context.Context, remotePath string) (_ *dirStream, err error) {
+	err = client.handles.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			client.handles.release()
+		}
+	}()
+
+	session, err := client.sshConn().NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SFTP channel: %w", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open SFTP channel: %w", err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open SFTP channel: %w", err)
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start SFTP subsystem: %w", err)
+	}
+
+	closeSession := func() error {
+		defer client.handles.release()
+		return session.Close()
+	}
+	s := &dirStream{w: w, r: bufio.NewReader(r), close: closeSession}
+	err = s.init()
+	if err == nil {
+		err = s.opendir(remotePath)
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *dirStream) send(typ byte, payload ...[]byte) error {
+	n := 1
+	for _, p := range payload {
+		n += len(p)
+	}
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, 4+n), uint32(n))
+	packet = append(packet, typ)
+	for _, p := range payload {
+		packet = append(packet, p...)
+	}
+	_, err := s.w.Write(packet)
+	return err
+}
+
+func (s *dirStream) recv() (byte, []byte, error) {
+	var header [5]byte
+	_, err := io.ReadFull(s.r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:4])
+	if n < 1 || n > maxDirPacket {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %d", n)
+	}
+	if cap(s.buf) < int(n-1) {
+		s.buf = make([]byte, n-1)
+	}
+	data := s.buf[:n-1]
+	_, err = io.ReadFull(s.r, data)
+	return header[4], data, err
+}
+
+func (s *dirStream) request(typ byte, args ...[]byte) (byte, []byte, error) {
+	s.id++
+	payload := append([][]byte{binary.BigEndian.AppendUint32(nil, s.id)}, args...)
+	err := s.send(typ, payload...)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply, data, err := s.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	id, data, ok := sshUint32(data)
+	if !ok || id != s.id {
+		return 0, nil, fmt.Errorf("unexpected SFTP reply id")
+	}
+	return reply, data, nil
+}
+
+func (s *dirStream) init() error {
+	err := s.send(fxpInit, binary.BigEndian.AppendUint32(nil, 3))
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+	typ, _, err := s.recv()
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+	if typ != fxpVersion {
+		return fmt.Errorf("failed to start SFTP session: unexpected SFTP packet %d", typ)
+	}
+	return nil
+}
+
+func (s *dirStream) opendir(remotePath string) error {
+	typ, data, err := s.request(fxpOpendir, sshString(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	switch typ {
+	case fxpHandle:
+		handle, _, ok := sshStringValue(data)
+		if !ok {
+			return fmt.Errorf("failed to open directory: malformed handle")
+		}
+		s.handle = handle
+		return nil
+	case fxpStatus:
+		return &fs.PathError{Op: "opendir", Path: remotePath, Err: statusError(data)}
+	}
+	return fmt.Errorf("failed to open directory: unexpected SFTP packet %d", typ)
+}
+
+// next returns the entries of the next readdir reply, errDirEnd once the
+// directory is exhausted. "." and ".." are left out.
+func (s *dirStream) next(dir string) ([]RemoteEntry, error) {
+	typ, data, err := s.request(fxpReaddir, sshString(s.handle))
+	if err != nil {
+		return nil, err
+	}
+	if typ == fxpStatus {
+		return nil, statusError(data)
+	}
+	if typ != fxpName {
+		return nil, fmt.Errorf("unexpected SFTP packet %d", typ)
+	}
+
+	count, data, ok := sshUint32(data)
+	if !ok {
+		return nil, fmt.Errorf("malformed SFTP name packet")
+	}
+	entries := make([]RemoteEntry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var name string
+		name, data, ok = sshStringValue(data)
+		if ok {
+			_, data, ok = sshStringValue(data) // long name
+		}
+		var entry RemoteEntry
+		if ok {
+			entry, data, ok = parseAttrs(data)
+		}
+		if !ok {
+			return nil, fmt.Errorf("malformed SFTP name packet")
+		}
+		if isDotEntry(name) {
+			continue
+		}
+		entry.Name = name
+		entry.Path = path.Join(dir, name)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *dirStream) Close() error {
+	if s.handle != "" {
+		s.request(fxpClose, sshString(s.handle))
+	}
+	s.w.Close()
+	return s.close()
+}
+
+func statusError(data []byte) error {
+	code, data, _ := sshUint32(data)
+	msg, _, _ := sshStringValue(data)
+	switch code {
+	case fxEOF:
+		return errDirEnd
+	case fxNoSuchFile:
+		return fs.ErrNotExist
+	case fxPermission:
+		return fs.ErrPermission
+	}
+	return fmt.Errorf("sftp: %q (code %d)", msg, code)
+}
+
+func sshUint32(data []byte) (uint32, []byte, bool) {
+	if len(data) < 4 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint32(data), data[4:], true
+}
+
+func sshUint64(data []byte) (uint64, []byte, bool) {
+	if len(data) < 8 {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint64(data), data[8:], true
+}
+
+func sshString(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+func sshStringValue(data []byte) (string, []byte, bool) {
+	n, data, ok := sshUint32(data)
+	if !ok || uint32(len(data)) < n {
+		return "", nil, false
+	}
+	return string(data[:n]), data[n:], true
+}
+
+func parseAttrs(data []byte) (RemoteEntry, []byte, bool) {
+	var entry RemoteEntry
+	flags, data, ok := sshUint32(data)
+	if ok && flags&attrSize != 0 {
+		var size uint64
+		size, data, ok = sshUint64(data)
+		entry.Size = int64(size)
+	}
+	if ok && flags&attrUIDGID != 0 {
+		_, data, ok = sshUint64(data)
+	}
+	if ok && flags&attrPermissions != 0 {
+		var mode uint32
+		mode, data, ok = sshUint32(data)
+		entry.Mode = fileModeFromPosix(mode)
+	}
+	if ok && flags&attrACModTime != 0 {
+		var mtime uint32
+		_, data, ok = sshUint32(data)
+		if ok {
+			mtime, data, ok = sshUint32(data)
+			entry.ModTime = time.Unix(int64(mtime), 0)
+		}
+	}
+	if ok && flags&attrExtended != 0 {
+		var count uint32
+		count, data, ok = sshUint32(data)
+		for i := uint32(0); ok && i < 2*count; i++ {
+			_, data, ok = sshStringValue(data)
+		}
+	}
+	return entry, data, ok
+}
+
+// fileModeFromPosix converts the permissions attribute, a POSIX st_mode.
+func fileModeFromPosix(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	case 0010000:
+		m |= os.ModeNamedPipe
+	case 0140000:
+		m |= os.ModeSocket
+	case 0020000:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		m |= os.ModeDevice
+	}
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// each calls fn for the remaining entries of the directory dir.
+func (s *dirStream) each(dir string, fn func(RemoteEntry) error) error {
+	for {
+		entries, err := s.next(dir)
+		if err == errDirEnd {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list directory: %w", err)
+		}
+		for _, entry := range entries {
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,586 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WithInclude restricts directory transfers to files whose relative path or
+// base name matches one of the path.Match patterns.
+func WithInclude(patterns ...string) TransferOption {
+	return func(params *transferParams) error {
+		if err := validatePatterns(patterns); err != nil {
+			return err
+		}
+		params.includes = append(params.includes, patterns...)
+		return nil
+	}
+}
+
+// WithExclude skips files and directories whose relative path or base name
+// matches one of the path.Match patterns. Excluded directories are not
+// descended into.
+func WithExclude(patterns ...string) TransferOption {
+	return func(params *transferParams) error {
+		if err := validatePatterns(patterns); err != nil {
+			return err
+		}
+		params.excludes = append(params.excludes, patterns...)
+		return nil
+	}
+}
+
+// WithPruneEmptyDirs makes UploadDir skip creating remote directories that
+// would contain no files once the include/exclude filters are applied, like
+// rsync --prune-empty-dirs.
+func WithPruneEmptyDirs() TransferOption {
+	return func(params *transferParams) error {
+		params.pruneEmptyDirs = true
+		return nil
+	}
+}
+
+// WithCreateEmptyDirs makes DownloadDir recreate remote directories that
+// contain no selected files. By default only directories holding files are
+// created locally.
+func WithCreateEmptyDirs() TransferOption {
+	return func(params *transferParams) error {
+		params.createEmptyDirs = true
+		return nil
+	}
+}
+
+// WithFollowLocalSymlinks makes UploadDir upload the files and directories
+// local symbolic links point to, as if they were in their place. A link to
+// a directory already being uploaded is not followed again. By default
+// local links are recorded as skipped.
+func WithFollowLocalSymlinks() TransferOption {
+	return func(params *transferParams) error {
+		params.followLocal = true
+		return nil
+	}
+}
+
+// WithStopOnError makes UploadDir and DownloadDir stop at the first file
+// that fails instead of going on with the remaining ones.
+func WithStopOnError() TransferOption {
+	return func(params *transferParams) error {
+		params.stopOnError = true
+		return nil
+	}
+}
+
+// WithPreserveTimes makes UploadDir and DownloadDir give every copied file
+// the modification time of its source, so that a later DiffLocalRemote or
+// sync sees it as unchanged. By default copies get the time they were
+// written.
+func WithPreserveTimes() TransferOption {
+	return func(params *transferParams) error {
+		params.preserveTimes = true
+		return nil
+	}
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, rel string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsFile reports whether the file at rel passes the filters.
+func (params *transferParams) selectsFile(rel string) bool {
+	if matchAny(params.excludes, rel) {
+		return false
+	}
+	return len(params.includes) == 0 || matchAny(params.includes, rel)
+}
+
+// treeEntry is a file or directory of a tree, relative to its root and
+// always slash separated.
+type treeEntry struct {
+	rel string
+	// target is the path on the destination side, rel unless a PathMapper
+	// is used.
+	target  string
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+	// link is set for a remote symbolic link.
+	link bool
+}
+
+// treePlan is the filtered content of a tree, directories listed parents
+// first.
+type treePlan struct {
+	dirs  []treeEntry
+	files []treeEntry
+	// links are the remote symbolic links that are not followed to a
+	// regular file: linked directories, dangling links and, with
+	// SymlinkNoFollow, every link. For a local tree they are the links not
+	// followed, see WithFollowLocalSymlinks.
+	links []treeEntry
+
+	// inProgress maps the files with a partial upload sibling to it, and
+	// partials are the selected partial uploads, see
+	// WithUploaderConventions.
+	inProgress map[string]string
+	partials   []treeEntry
+	mapped     bool
+}
+
+// dirsToCreate returns the directories to create on the destination and the
+// number of directories that contain no selected file. A directory holding
+// only excluded files counts as empty.
+func (plan *treePlan) dirsToCreate(keepEmpty bool) ([]treeEntry, int) {
+	if plan.mapped {
+		return plan.targetDirs(), 0
+	}
+
+	needed := make(map[string]bool)
+	for _, file := range plan.files {
+		for dir := path.Dir(file.rel); dir != "."; dir = path.Dir(dir) {
+			needed[dir] = true
+		}
+	}
+
+	var create []treeEntry
+	empty := 0
+	for _, dir := range plan.dirs {
+		if !needed[dir.rel] {
+			empty++
+			if !keepEmpty {
+				continue
+			}
+		}
+		create = append(create, dir)
+	}
+	return create, empty
+}
+
+func localTreePlan(localDir string, params *transferParams) (*treePlan, error) {
+	plan := &treePlan{}
+	err := plan.addLocalDir(localDir, "", params, make(map[string]bool))
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk local directory %q: %w", localDir, quotePath(err))
+	}
+	return plan, nil
+}
+
+// addLocalDir adds the tree below the local directory dir to the plan, prefix
+// being the path of dir relative to the root. When following links, visited
+// holds the real paths of the directories added so far.
+func (plan *treePlan) addLocalDir(dir, prefix string, params *transferParams, visited map[string]bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if params.followLocal && d.IsDir() {
+			real, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return err
+			}
+			if visited[real] {
+				return filepath.SkipDir
+			}
+			visited[real] = true
+		}
+		if p == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = path.Join(prefix, filepath.ToSlash(rel))
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := treeEntry{rel: rel, target: rel, size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+
+		switch {
+		case d.IsDir():
+			if matchAny(params.excludes, rel) {
+				return filepath.SkipDir
+			}
+			plan.dirs = append(plan.dirs, entry)
+		case d.Type()&fs.ModeSymlink != 0:
+			return plan.addLocalLink(p, entry, params, visited)
+		case d.Type().IsRegular():
+			if params.selectsFile(rel) {
+				plan.files = append(plan.files, entry)
+			}
+		}
+		return nil
+	})
+}
+
+// addLocalLink adds the local symbolic link at p to the plan, or what it
+// points to with WithFollowLocalSymlinks.
+func (plan *treePlan) addLocalLink(p string, entry treeEntry, params *transferParams, visited map[string]bool) error {
+	entry.link = true
+	if !params.followLocal {
+		if params.selectsFile(entry.rel) {
+			plan.links = append(plan.links, entry)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		// Dangling
+		if params.selectsFile(entry.rel) {
+			plan.links = append(plan.links, entry)
+		}
+		return nil
+	}
+	entry.size, entry.modTime, entry.mode = info.Size(), info.ModTime(), info.Mode()
+	switch {
+	case info.IsDir():
+		if matchAny(params.excludes, entry.rel) {
+			return nil
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		plan.dirs = append(plan.dirs, entry)
+		return plan.addLocalDir(real, entry.rel, params, visited)
+	case info.Mode().IsRegular():
+		if params.selectsFile(entry.rel) {
+			plan.files = append(plan.files, entry)
+		}
+	}
+	return nil
+}
+
+// remoteTreePlan lists the tree below remoteDir. Directories below it that
+// cannot be listed for lack of permission are handed to denied, if set, and
+// skipped; otherwise they fail the listing.
+func (client *SFTPClient) remoteTreePlan(remoteDir string, params *transferParams, denied func(rel string, err error)) (*treePlan, error) {
+	plan := &treePlan{}
+	root := path.Clean(remoteDir)
+	walkParams := &walkParams{sorted: true}
+	var rootErr error
+	if denied != nil {
+		walkParams.denied = func(dir string, err error) {
+			if dir == root {
+				rootErr = fmt.Errorf("failed to list directory %q: %w", dir, err)
+				return
+			}
+			denied(remoteRel(root, dir), err)
+		}
+	}
+	err := client.walk(root, walkParams, func(info RemoteFileInfo) error {
+		rel := remoteRel(root, info.Path)
+		entry := treeEntry{rel: rel, target: rel, size: info.Size(), modTime: info.ModTime(), mode: info.Mode(), link: info.Symlink}
+
+		switch {
+		case info.Symlink && !info.Mode().IsRegular():
+			if params.selectsFile(rel) {
+				plan.links = append(plan.links, entry)
+			}
+		case info.IsDir():
+			if matchAny(params.excludes, rel) {
+				return fs.SkipDir
+			}
+			plan.dirs = append(plan.dirs, entry)
+		case info.Mode().IsRegular():
+			if name, ok := params.partialOf(rel); ok {
+				if plan.inProgress == nil {
+					plan.inProgress = make(map[string]string)
+				}
+				plan.inProgress[name] = rel
+				if params.selectsFile(rel) {
+					plan.partials = append(plan.partials, entry)
+				}
+				return nil
+			}
+			if params.selectsFile(rel) {
+				plan.files = append(plan.files, entry)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = rootErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// remoteRel returns p relative to the remote directory root.
+func remoteRel(root, p string) string {
+	switch root {
+	case ".":
+		return p
+	case "/":
+		return strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimPrefix(p, root+"/")
+}
+
+// UploadDir uploads the regular files below localDir into remoteDir,
+// creating remote directories as needed. Paths on the remote side always use
+// forward slashes. Failures of individual files are recorded in the result
+// and joined in the returned error; the remaining files are still uploaded
+// unless WithStopOnError. Local symbolic links are recorded as skipped
+// unless WithFollowLocalSymlinks.
+func (client *SFTPClient) UploadDir(localDir, remoteDir string, opts ...TransferOption) (*BatchResult, error) {
+	return client.UploadDirContext(context.Background(), localDir, remoteDir, opts...)
+}
+
+// UploadDirContext is UploadDir giving up once ctx is done.
+func (client *SFTPClient) UploadDirContext(ctx context.Context, localDir, remoteDir string, opts ...TransferOption) (_ *BatchResult, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "UploadDir", remoteDir)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	params.ctx = ctx
+
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	result := &BatchResult{Started: start, DryRun: params.dryRun}
+
+	plan, err := localTreePlan(localDir, params)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.mapTargets(params.mapper, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.dryRun {
+		err = client.mkdirAll(remoteDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dirs, empty := plan.dirsToCreate(!params.pruneEmptyDirs)
+	if params.pruneEmptyDirs {
+		result.EmptyDirsPruned = empty
+	} else {
+		result.EmptyDirsCreated = empty
+	}
+	for _, dir := range dirs {
+		if params.dryRun {
+			_, err := client.sftpConn().Stat(path.Join(remoteDir, dir.rel))
+			if errors.Is(err, fs.ErrNotExist) {
+				result.DirsCreated++
+			}
+			continue
+		}
+		created, err := client.mkdir(path.Join(remoteDir, dir.rel))
+		if err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+		if created {
+			result.DirsCreated++
+		}
+	}
+
+	for _, link := range plan.links {
+		item := BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(link.rel)),
+			RemotePath: path.Join(remoteDir, link.target),
+			Status:     StatusSkipped,
+			Symlink:    true,
+		}
+		if params.followLocal {
+			item.Err = &fs.PathError{Op: "stat", Path: item.LocalPath, Err: ErrBrokenSymlink}
+		}
+		result.add(item)
+	}
+
+	for _, file := range plan.files {
+		item := BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(file.rel)),
+			RemotePath: path.Join(remoteDir, file.target),
+			Symlink:    file.link,
+		}
+		if err := client.awaitWindow(params, result); err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+		client.runItem(result, item, params, true)
+		status := result.Items[len(result.Items)-1].Status
+		if status == StatusTransferred && params.preserveTimes {
+			err := client.sftpConn().Chtimes(item.RemotePath, file.modTime, file.modTime)
+			if err != nil {
+				client.params.Logger().Warnf("Failed to set the modification time of %q: %v", item.RemotePath, err)
+			}
+		}
+		if params.stopOnError && status == StatusFailed {
+			break
+		}
+	}
+	client.verifySample(result, params)
+
+	result.Duration = time.Since(start)
+	return result, result.err()
+}
+
+// DownloadDir downloads the regular files below remoteDir into localDir,
+// recreating the directory layout. Directories without selected files are
+// only created with WithCreateEmptyDirs. Failures of individual files are
+// recorded in the result and joined in the returned error.
+func (client *SFTPClient) DownloadDir(remoteDir, localDir string, opts ...TransferOption) (*BatchResult, error) {
+	return client.DownloadDirContext(context.Background(), remoteDir, localDir, opts...)
+}
+
+// DownloadDirContext is DownloadDir giving up once ctx is done.
+func (client *SFTPClient) DownloadDirContext(ctx context.Context, remoteDir, localDir string, opts ...TransferOption) (_ *BatchResult, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "DownloadDir", remoteDir)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	params.ctx = ctx
+
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	result := &BatchResult{Started: start, DryRun: params.dryRun}
+
+	plan, err := client.remoteTreePlan(remoteDir, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.mapTargets(params.mapper, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.dryRun {
+		err = os.MkdirAll(localDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local directory: %w", err)
+		}
+	}
+
+	dirs, empty := plan.dirsToCreate(params.createEmptyDirs)
+	if params.createEmptyDirs {
+		result.EmptyDirsCreated = empty
+	} else {
+		result.EmptyDirsPruned = empty
+	}
+	for _, dir := range dirs {
+		localPath := filepath.Join(localDir, filepath.FromSlash(dir.rel))
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+		if params.dryRun {
+			result.DirsCreated++
+			continue
+		}
+		err = os.MkdirAll(localPath, 0755)
+		if err != nil {
+			result.Duration = time.Since(start)
+			return result, fmt.Errorf("failed to create local directory: %w", err)
+		}
+		result.DirsCreated++
+	}
+
+	for _, link := range plan.links {
+		result.add(BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(link.target)),
+			RemotePath: path.Join(remoteDir, link.rel),
+			Status:     StatusSkipped,
+			Err:        &fs.PathError{Op: "open", Path: path.Join(remoteDir, link.rel), Err: ErrIsSymlink},
+			Symlink:    true,
+		})
+	}
+
+	for _, partial := range plan.partials {
+		result.add(BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(partial.target)),
+			RemotePath: path.Join(remoteDir, partial.rel),
+			Status:     StatusSkipped,
+			Err:        fmt.Errorf("%w: partial upload", ErrUploadInProgress),
+		})
+	}
+
+	for _, file := range plan.files {
+		item := BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(file.target)),
+			RemotePath: path.Join(remoteDir, file.rel),
+			Symlink:    file.link,
+		}
+		if partial, ok := plan.inProgress[file.rel]; ok {
+			item.Status = StatusDeferred
+			item.Err = fmt.Errorf("%w: partial upload %q", ErrUploadInProgress, path.Join(remoteDir, partial))
+			result.add(item)
+			continue
+		}
+		if err := client.awaitWindow(params, result); err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+		client.runItem(result, item, params, false)
+		status := result.Items[len(result.Items)-1].Status
+		if status == StatusTransferred && params.preserveTimes {
+			err := os.Chtimes(item.LocalPath, file.modTime, file.modTime)
+			if err != nil {
+				client.params.Logger().Warnf("Failed to set the modification time of %q: %v", item.LocalPath, err)
+			}
+		}
+		if params.stopOnError && status == StatusFailed {
+			break
+		}
+	}
+	client.verifySample(result, params)
+
+	result.Duration = time.Since(start)
+	return result, result.err()
+}
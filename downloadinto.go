@@ -0,0 +1,138 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DownloadInto streams remotePath into dst, which the caller opened and
+// keeps owning: it is written from its current offset, and never closed,
+// renamed or removed. Seeking dst before the call resumes a previous partial
+// download from that offset; WithResume has no further effect. Verification
+// reads dst back from the start and requires it to be open for reading.
+// WithAtomic and WithAutoTempCleanup are rejected.
+func (client *SFTPClient) DownloadInto(remotePath string, dst *os.File, opts ...TransferOption) (*TransferStats, error) {
+	return client.DownloadIntoContext(context.Background(), remotePath, dst, opts...)
+}
+
+// DownloadIntoContext is DownloadInto giving up once ctx is done.
+func (client *SFTPClient) DownloadIntoContext(ctx context.Context, remotePath string, dst *os.File, opts ...TransferOption) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "DownloadInto", remotePath)
+	defer done(&err)
+	if dst == nil {
+		return nil, fmt.Errorf("destination file is nil")
+	}
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.refuseDryRun("DownloadInto"); err != nil {
+		return nil, err
+	}
+	params.ctx = ctx
+	if params.atomic || params.autoTempCleanup {
+		return nil, fmt.Errorf("atomic and temporary file options do not apply to a caller-owned destination")
+	}
+
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	return client.downloadInto(remotePath, dst, params)
+}
+
+func (client *SFTPClient) downloadInto(remotePath string, dst *os.File, params *transferParams) (*TransferStats, error) {
+	release, err := client.startTransfer(params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	stats := &TransferStats{RemotePath: remotePath, LocalPath: dst.Name()}
+
+	offset, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination offset: %w", err)
+	}
+	if offset > 0 && params.autoDecompress {
+		return nil, fmt.Errorf("%w: auto-decompression rewrites the stream, start at offset 0", ErrResumeUnsupported)
+	}
+	stats.StartOffset = offset
+	stats.Resumed = offset > 0
+
+	remoteFileInfo, err := client.statSource(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+	stats.TotalSize = remoteFileInfo.Size()
+	if offset > stats.TotalSize {
+		return nil, fmt.Errorf("destination offset %d is beyond the remote size %d", offset, stats.TotalSize)
+	}
+
+	remoteFile, err := client.openRemote(params.context(), remotePath, os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	if offset > 0 {
+		_, err = remoteFile.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek in remote file: %w", err)
+		}
+	}
+
+	var src io.Reader = remoteFile
+	total := stats.TotalSize
+	var compressed *countingReader
+	if params.autoDecompress {
+		compressed = &countingReader{r: remoteFile}
+		src, _, err = decompressStream(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress remote file %q: %w", remotePath, err)
+		}
+		total = -1
+	}
+	src = newProgressReader(client.source(src, params), ProgressInfo{
+		Phase:       PhaseTransfer,
+		Path:        remotePath,
+		Transferred: offset,
+		Total:       total,
+	}, params.progress)
+
+	n, err := io.Copy(dst, src)
+	client.addBytes(params.context(), DirectionDownload, n)
+	stats.BytesTransferred = n
+	if compressed != nil {
+		stats.CompressedBytes = compressed.n
+		stats.UncompressedBytes = n
+	}
+	stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+	if err != nil {
+		stats.Duration = time.Since(start)
+		return stats, fmt.Errorf("failed to copy file to local: %w", err)
+	}
+
+	// Release the handle before verification opens its own
+	remoteFile.Close()
+
+	if params.verify {
+		err = client.verifyFile(remotePath, dst, params, stats)
+		if err != nil {
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+	}
+
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
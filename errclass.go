@@ -0,0 +1,218 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/sftp"
+)
+
+// ErrorClass groups errors by how a caller should react to them.
+type ErrorClass string
+
+const (
+	// ErrorClassNone is the class of a nil error.
+	ErrorClassNone ErrorClass = ""
+	// ErrorClassTransport covers dropped connections, closed channels, SSH
+	// re-key failures and network timeouts. Retrying after a reconnect is
+	// expected to succeed.
+	ErrorClassTransport ErrorClass = "transport"
+	// ErrorClassHandleExhausted covers servers refusing to open more file
+	// handles. Retrying once other handles are closed is expected to
+	// succeed; see WithMaxOpenHandles to avoid it altogether.
+	ErrorClassHandleExhausted ErrorClass = "handle_exhausted"
+	// ErrorClassPermanent covers errors that will not go away on retry,
+	// such as missing files or permission problems.
+	ErrorClassPermanent ErrorClass = "permanent"
+	// ErrorClassMissing covers files a manifest lists but the server does
+	// not have, see DownloadByManifest. The partner may still publish them.
+	ErrorClassMissing ErrorClass = "missing"
+	// ErrorClassQuota covers servers refusing writes over a quota or for lack
+	// of space, see IsQuotaExceeded. Retrying does not help until space is
+	// freed.
+	ErrorClassQuota ErrorClass = "quota"
+	// ErrorClassUnknown is anything the classifier does not recognize.
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+// transportMessages are fragments of error strings produced deep inside
+// x/crypto/ssh and the net package that carry no typed error.
+var transportMessages = []string{
+	"ssh: rekey",
+	"ssh: handshake failed: EOF",
+	"channel closed",
+	"ssh: disconnect",
+	"ssh: unexpected packet",
+	"use of closed network connection",
+	"connection reset by peer",
+	"broken pipe",
+	"connection lost",
+}
+
+// handleExhaustedMessages are fragments of server messages for failures
+// caused by too many open handles, which servers usually report as a plain
+// SSH_FX_FAILURE that looks like a file error.
+var handleExhaustedMessages = []string{
+	"too many open files",
+	"too many open handles",
+	"handle limit",
+	"no more handles",
+	"out of handles",
+}
+
+// DefaultQuotaPatterns are the fragments of server messages IsQuotaExceeded
+// looks for unless WithQuotaPatterns says otherwise. Matching is case
+// insensitive.
+var DefaultQuotaPatterns = []string{
+	"quota",
+	"disk full",
+	"no space left",
+	"insufficient storage",
+	"storage limit",
+}
+
+// SFTP version 6 status codes for space problems, which some servers send
+// to version 3 clients anyway.
+const (
+	fxNoSpaceOnFilesystem = 14
+	fxQuotaExceeded       = 15
+)
+
+// WithQuotaPatterns replaces DefaultQuotaPatterns with the fragments of
+// messages the server sends when a write exceeds its quota.
+func WithQuotaPatterns(patterns ...string) Options {
+	return func(params *SFTPClientParams) error {
+		for _, pattern := range patterns {
+			if pattern == "" {
+				return fmt.Errorf("quota pattern must not be empty")
+			}
+		}
+		params.quotaPatterns = append([]string{}, patterns...)
+		params.record("WithQuotaPatterns", strings.Join(patterns, "\x00"))
+		return nil
+	}
+}
+
+// IsQuotaExceeded reports whether err is the server refusing a write over a
+// quota or for lack of space, judging by the status code and by the server
+// message matching the quota patterns of the client. Errors not returned by
+// a client are matched against DefaultQuotaPatterns.
+func IsQuotaExceeded(err error) bool {
+	var opErr *OpError
+	if errors.As(err, &opErr) && opErr.Code != 0 {
+		return opErr.quota
+	}
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		return isQuotaStatus(statusErr.Code, statusMessage(statusErr), DefaultQuotaPatterns)
+	}
+	return false
+}
+
+func isQuotaStatus(code uint32, message string, patterns []string) bool {
+	if code == fxNoSpaceOnFilesystem || code == fxQuotaExceeded {
+		return true
+	}
+	lower := strings.ToLower(message)
+	for _, pattern := range patterns {
+		if strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyError reports the class of err.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+
+	var reported *ReportedError
+	if errors.As(err, &reported) {
+		return reported.Class
+	}
+	if errors.Is(err, ErrManifestFileMissing) {
+		return ErrorClassMissing
+	}
+	// Checked first: a failed reconnect wraps them in ErrNotConnected, and
+	// the SSH handshake may fail on them after reading from the network
+	if errors.Is(err, ErrAuthFailed) ||
+		errors.Is(err, ErrHostKeyMismatch) ||
+		errors.Is(err, ErrHostKeyUnknown) ||
+		strings.Contains(err.Error(), "ssh: unable to authenticate") {
+		return ErrorClassPermanent
+	}
+	if IsQuotaExceeded(err) {
+		return ErrorClassQuota
+	}
+
+	lower := strings.ToLower(err.Error())
+	for _, fragment := range handleExhaustedMessages {
+		if strings.Contains(lower, fragment) {
+			return ErrorClassHandleExhausted
+		}
+	}
+
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.FxCode() {
+		case sftp.ErrSSHFxConnectionLost, sftp.ErrSSHFxNoConnection:
+			return ErrorClassTransport
+		case sftp.ErrSSHFxNoSuchFile, sftp.ErrSSHFxPermissionDenied, sftp.ErrSSHFxOpUnsupported:
+			return ErrorClassPermanent
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrNotConnected),
+		errors.Is(err, sftp.ErrSSHFxConnectionLost),
+		errors.Is(err, sftp.ErrSSHFxNoConnection),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, net.ErrClosed),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE):
+		return ErrorClassTransport
+	case errors.Is(err, fs.ErrNotExist),
+		errors.Is(err, fs.ErrPermission),
+		errors.Is(err, fs.ErrExist),
+		errors.Is(err, ErrNotADirectory),
+		errors.Is(err, ErrDryRun):
+		return ErrorClassPermanent
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorClassTransport
+	}
+
+	for _, fragment := range transportMessages {
+		if strings.Contains(lower, fragment) {
+			return ErrorClassTransport
+		}
+	}
+
+	return ErrorClassUnknown
+}
+
+// IsRetryable reports whether retrying the operation, after a reconnect for
+// transport errors, is likely to succeed. It understands the errors of
+// pkg/sftp, x/crypto/ssh and the net package as well as those of the client:
+// dropped connections, resets and timeouts are retryable, missing files,
+// permission problems, full disks and rejected credentials are not. The
+// retry loops of the client stop on errors it does not accept.
+func IsRetryable(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorClassTransport, ErrorClassHandleExhausted:
+		return true
+	default:
+		return false
+	}
+}
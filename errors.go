@@ -0,0 +1,236 @@
+package sftpc
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// The classes of failures any public method of a client can return, to be
+// tested with errors.Is whatever wraps them. SFTP statuses and SSH errors
+// are mapped onto them on the way out:
+//
+//   - ErrNotExist: the remote path, or the local one of a transfer, does
+//     not exist. Returned by the transfers, listings, walks, stats, moves
+//     and removals; Exists, DirExists and RemoveAll report it as false or
+//     nil instead.
+//   - ErrPermission: the server, or the local file system, refused access.
+//     Returned by the same methods; ErrPermissionDenied is returned along
+//     with it by the legacy DownloadFile and WalkFile.
+//   - ErrNotConnected: no working connection could be had, the reconnect
+//     failed or the connection was lost mid-operation. Returned by every
+//     method talking to the server, ReConnect included.
+//   - ErrAuthFailed: the server rejected the credentials. Returned by
+//     NewSFTPClient and, along with ErrNotConnected, by every method that
+//     had to reconnect.
+var (
+	// ErrNotExist is fs.ErrNotExist, which os.IsNotExist also tests.
+	ErrNotExist = fs.ErrNotExist
+
+	// ErrPermission is fs.ErrPermission, which os.IsPermission also tests.
+	ErrPermission = fs.ErrPermission
+
+	// ErrNotConnected is returned when the client has no working
+	// connection and could not reconnect.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrAuthFailed is returned when the server rejected every
+	// authentication method offered.
+	ErrAuthFailed = errors.New("authentication failed")
+)
+
+var (
+	// ErrResumeUnsupported is returned when resume is requested for a
+	// transfer mode that cannot continue from a byte offset.
+	ErrResumeUnsupported = errors.New("resume is not supported")
+
+	// ErrResumeMismatch is returned with WithStrictResume when the
+	// destination is larger than the source, which was likely replaced.
+	ErrResumeMismatch = errors.New("destination is larger than the source")
+
+	// ErrPermissionDenied is returned, wrapping the server's error, when a
+	// remote path cannot be read for lack of permission, unless
+	// WithSkipPermissionErrors.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrChecksumMismatch is returned when a verified transfer produced a
+	// copy whose digest differs from the source.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrDestinationExists is returned when the destination is already
+	// present and the operation was not allowed to replace it.
+	ErrDestinationExists = errors.New("destination already exists")
+
+	// ErrDirectoryNotEmpty is returned when removing a directory that still
+	// has entries, see RemoveAll.
+	ErrDirectoryNotEmpty = errors.New("directory not empty")
+
+	// ErrNotADirectory is returned when a file is in the way of a
+	// directory to create or remove, see MakeDirAll.
+	ErrNotADirectory = errors.New("not a directory")
+
+	// ErrIsSymlink is returned when downloading a symbolic link with
+	// SymlinkNoFollow.
+	ErrIsSymlink = errors.New("remote path is a symbolic link")
+
+	// ErrBrokenSymlink is returned with SymlinkFollowSafe for a symbolic
+	// link whose target does not exist.
+	ErrBrokenSymlink = errors.New("broken symbolic link")
+
+	// ErrRootPath is returned when a recursive removal targets the root or
+	// the working directory without WithAllowRoot.
+	ErrRootPath = errors.New("refusing to operate on the root directory")
+
+	// ErrListingUnstable is returned by ListStable when the directory kept
+	// changing between listings.
+	ErrListingUnstable = errors.New("directory listing unstable")
+
+	// ErrAlreadyClaimed is returned by ClaimFile when the file vanished
+	// before it could be renamed, claimed by another consumer.
+	ErrAlreadyClaimed = errors.New("file already claimed")
+
+	// ErrUnknownProfile is returned for a profile name that was never
+	// registered, see RegisterProfile.
+	ErrUnknownProfile = errors.New("unknown profile")
+
+	// ErrManifestFileMissing is returned by DownloadByManifest for a listed
+	// file that is not on the server. It is classified as
+	// ErrorClassMissing.
+	ErrManifestFileMissing = errors.New("file listed in manifest is missing")
+
+	// ErrUploadInProgress explains the items DownloadDir leaves alone under
+	// WithUploaderConventions: files another client is still uploading,
+	// recorded with StatusDeferred, and their partial uploads, recorded
+	// with StatusSkipped.
+	ErrUploadInProgress = errors.New("still being uploaded")
+
+	// ErrDryRun is returned under WithDryRun by operations that cannot tell
+	// what they would do without writing, such as Upload.
+	ErrDryRun = errors.New("not supported in a dry run")
+)
+
+// quotedPathError prints the path of a *fs.PathError quoted, so that file
+// names containing newlines or control characters cannot split or forge
+// messages.
+type quotedPathError struct {
+	*fs.PathError
+}
+
+func (e quotedPathError) Error() string {
+	return e.Op + " " + strconv.Quote(e.Path) + ": " + e.Err.Error()
+}
+
+func (e quotedPathError) Unwrap() error {
+	return e.PathError
+}
+
+// quotePath quotes the path of err when it is a *fs.PathError.
+func quotePath(err error) error {
+	if pathErr, ok := err.(*fs.PathError); ok {
+		return quotedPathError{pathErr}
+	}
+	return err
+}
+
+// OpError is a failed operation on a remote path. The public methods of a
+// client return their errors as an *OpError naming the method, the host of
+// the server and the remote path they were called with, wrapping the step
+// that failed. When the server answered with an SFTP status, Code is its
+// status code and ServerMessage the text it sent along, verbatim; servers
+// often explain generic SSH_FX_FAILURE replies there, such as "quota
+// exceeded".
+type OpError struct {
+	Op            string
+	Host          string
+	Path          string
+	Code          uint32
+	ServerMessage string
+	Err           error
+
+	// quota is set when ServerMessage matched the quota patterns of the
+	// client, see IsQuotaExceeded.
+	quota bool
+}
+
+func (e *OpError) Error() string {
+	if e.Path == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+	return e.Op + " " + strconv.Quote(e.Path) + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Is maps the SFTP status of the error onto the sentinels of its class.
+func (e *OpError) Is(target error) bool {
+	switch target {
+	case ErrNotExist:
+		return e.Code == uint32(sftp.ErrSSHFxNoSuchFile)
+	case ErrPermission:
+		return e.Code == uint32(sftp.ErrSSHFxPermissionDenied)
+	case ErrNotConnected:
+		return e.Code == uint32(sftp.ErrSSHFxNoConnection) || e.Code == uint32(sftp.ErrSSHFxConnectionLost) ||
+			errors.Is(e.Err, sftp.ErrSSHFxNoConnection) || errors.Is(e.Err, sftp.ErrSSHFxConnectionLost)
+	}
+	return false
+}
+
+// opError wraps err, unless nil or already an *OpError, with the status the
+// server sent for it.
+func (client *SFTPClient) opError(op, remotePath string, err error) error {
+	var opErr *OpError
+	if err == nil || errors.As(err, &opErr) {
+		return err
+	}
+	opErr = &OpError{Op: op, Path: remotePath, Err: err}
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		opErr.Code = statusErr.Code
+		opErr.ServerMessage = statusMessage(statusErr)
+		opErr.quota = isQuotaStatus(statusErr.Code, opErr.ServerMessage, client.params.QuotaPatterns())
+	}
+	return opErr
+}
+
+// operationError wraps err, unless nil, in an *OpError for the public
+// operation op on remotePath, with the server status of the *OpError or
+// status it wraps.
+func (client *SFTPClient) operationError(op, remotePath string, err error) error {
+	if err == nil {
+		return nil
+	}
+	opErr := &OpError{Op: op, Host: client.params.Host(), Path: remotePath, Err: err}
+	var inner *OpError
+	var statusErr *sftp.StatusError
+	switch {
+	case errors.As(err, &inner):
+		opErr.Code, opErr.ServerMessage, opErr.quota = inner.Code, inner.ServerMessage, inner.quota
+		if remotePath == "" {
+			opErr.Path = inner.Path
+		}
+	case errors.As(err, &statusErr):
+		opErr.Code = statusErr.Code
+		opErr.ServerMessage = statusMessage(statusErr)
+		opErr.quota = isQuotaStatus(statusErr.Code, opErr.ServerMessage, client.params.QuotaPatterns())
+	}
+	return opErr
+}
+
+// statusMessage returns the text the server sent with a status, which
+// sftp.StatusError only exposes quoted in its message.
+func statusMessage(statusErr *sftp.StatusError) string {
+	msg := strings.TrimPrefix(statusErr.Error(), "sftp: ")
+	if i := strings.LastIndex(msg, " ("); i >= 0 {
+		msg = msg[:i]
+	}
+	unquoted, err := strconv.Unquote(msg)
+	if err != nil {
+		return msg
+	}
+	return unquoted
+}
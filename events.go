@@ -0,0 +1,138 @@
+package sftpc
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// TransferEventType tells what happened to an item of a batch.
+type TransferEventType string
+
+const (
+	EventStarted  TransferEventType = "started"
+	EventFinished TransferEventType = "finished"
+	// EventPaused and EventResumed bracket waits for a transfer window.
+	EventPaused  TransferEventType = "paused"
+	EventResumed TransferEventType = "resumed"
+)
+
+// TransferEvent reports the progress of a batch operation item by item.
+type TransferEvent struct {
+	Type       TransferEventType
+	LocalPath  string
+	RemotePath string
+
+	// Cancel is set on EventStarted and aborts just this item, which is
+	// then reported as StatusCancelled while the batch carries on. It may
+	// be called from any goroutine, also after the item finished.
+	Cancel context.CancelFunc
+
+	// Status and Err are set on EventFinished.
+	Status ItemStatus
+	Err    error
+
+	// ResumeAt is set on EventPaused.
+	ResumeAt time.Time
+}
+
+// WithEvents calls fn when each item of a batch operation starts and
+// finishes. fn runs on the goroutine doing the transfer and should return
+// quickly.
+func WithEvents(fn func(TransferEvent)) TransferOption {
+	return func(params *transferParams) error {
+		params.events = fn
+		return nil
+	}
+}
+
+func (params *transferParams) emit(event TransferEvent) {
+	if params.events != nil {
+		params.events(event)
+	}
+}
+
+// contextReader fails reads once its context is done, which aborts the copy
+// it feeds.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// context returns the context of the operation, never nil.
+func (params *transferParams) context() context.Context {
+	if params.ctx == nil {
+		return context.Background()
+	}
+	return params.ctx
+}
+
+// source wraps the reader feeding a transfer so that it stops when the
+// operation's context is done.
+func (params *transferParams) source(r io.Reader) io.Reader {
+	if params.ctx == nil {
+		return r
+	}
+	return &contextReader{ctx: params.ctx, r: r}
+}
+
+// runItem transfers one item of a batch under its own cancellable context
+// and records the outcome. Partial output of cancelled items is removed
+// unless the transfer is resumable, but only when the item opened it for
+// writing: a destination the item never got to is left alone. Under
+// WithDryRun the item is only recorded as planned.
+func (client *SFTPClient) runItem(result *BatchResult, item BatchItem, params *transferParams, upload bool) {
+	if params.dryRun {
+		item.Status = StatusPlanned
+		result.add(item)
+		return
+	}
+
+	parent := params.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var opened string
+	itemParams := *params
+	itemParams.ctx = ctx
+	itemParams.opened = &opened
+	params.emit(TransferEvent{Type: EventStarted, LocalPath: item.LocalPath, RemotePath: item.RemotePath, Cancel: cancel})
+
+	if upload {
+		item.Stats, item.Err = client.put(item.LocalPath, item.RemotePath, &itemParams)
+		item.Err = client.operationError("Put", item.RemotePath, item.Err)
+	} else {
+		item.Stats, item.Err = client.get(item.RemotePath, item.LocalPath, &itemParams)
+		item.Err = client.operationError("Get", item.RemotePath, item.Err)
+	}
+
+	switch {
+	case item.Err == nil:
+		item.Status = StatusTransferred
+	case ctx.Err() != nil && parent.Err() == nil:
+		item.Status = StatusCancelled
+		if !params.resume && opened != "" {
+			if upload {
+				client.sftpConn().Remove(opened)
+			} else {
+				os.Remove(opened)
+			}
+		}
+	default:
+		item.Status = StatusFailed
+	}
+
+	result.add(item)
+	params.emit(TransferEvent{Type: EventFinished, LocalPath: item.LocalPath, RemotePath: item.RemotePath, Status: item.Status, Err: item.Err})
+}
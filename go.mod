@@ -3,8 +3,9 @@ module github.com/thiagozs/go-sftpc
 go 1.22.6
 
 require (
-	github.com/pkg/sftp v1.13.6
+	github.com/pkg/sftp v1.13.7
 	golang.org/x/crypto v0.28.0
+	golang.org/x/net v0.30.0
 )
 
 require (
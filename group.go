@@ -0,0 +1,179 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrGroupClosed is returned when adding to a Group that was shut down.
+var ErrGroupClosed = errors.New("group is shut down")
+
+// Stopper is a long-lived helper, such as a PollCursor or a queue consumer
+// started with Group.Go, that Group.Shutdown stops before it closes
+// connections. Stop should return once the helper no longer starts new
+// work, or when ctx is done.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+type groupMember struct {
+	name     string
+	resource any
+}
+
+// Group tracks clients and the helpers running on them so that an
+// application can shut them all down in order. The zero value is ready to
+// use.
+type Group struct {
+	mu      sync.Mutex
+	members []groupMember
+	closed  bool
+}
+
+// NewClient connects a client like NewSFTPClient and registers it, named
+// after its user and address.
+func (g *Group) NewClient(opts ...Options) (*SFTPClient, error) {
+	client, err := NewSFTPClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	info := client.ConnectionInfo()
+	err = g.Register(info.User+"@"+info.RemoteAddr, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Register adds a *SFTPClient or a Stopper to the group. name only labels
+// the resource in Shutdown errors.
+func (g *Group) Register(name string, resource any) error {
+	switch resource.(type) {
+	case *SFTPClient, Stopper:
+	default:
+		return fmt.Errorf("cannot register %T, want *SFTPClient or Stopper", resource)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrGroupClosed
+	}
+	g.members = append(g.members, groupMember{name: name, resource: resource})
+	return nil
+}
+
+// Go runs fn in a goroutine as a helper of the group, such as a
+// ProcessQueueContext run or a loop over PollCursor.Next. Shutdown cancels
+// the context fn gets and waits for fn to return; an error fn returns other
+// than the cancellation is reported by Shutdown.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	helper := &goHelper{cancel: cancel, done: make(chan struct{})}
+	err := g.Register(name, helper)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		defer close(helper.done)
+		helper.err = fn(ctx)
+	}()
+	return nil
+}
+
+// goHelper is the Stopper of a function started by Group.Go.
+type goHelper struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func (h *goHelper) Stop(ctx context.Context) error {
+	h.cancel()
+	select {
+	case <-h.done:
+		if errors.Is(h.err, context.Canceled) {
+			return nil
+		}
+		return h.err
+	case <-ctx.Done():
+		return fmt.Errorf("helper still running: %w", ctx.Err())
+	}
+}
+
+// Unregister removes resource from the group without stopping or closing
+// it.
+func (g *Group) Unregister(resource any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, member := range g.members {
+		if member.resource == resource {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// Shutdown stops every helper, then waits for the transfers in flight on
+// every client to finish, then closes the clients. Once ctx is done,
+// waiting stops and the clients are closed anyway, failing the transfers
+// still running. The failures are joined per resource in the returned
+// error. The group accepts no new resources afterwards.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	members := g.members
+	g.members = nil
+	g.mu.Unlock()
+
+	var stoppers, clients []groupMember
+	for _, member := range members {
+		if _, ok := member.resource.(*SFTPClient); ok {
+			clients = append(clients, member)
+		} else {
+			stoppers = append(stoppers, member)
+		}
+	}
+
+	errs := eachMember(stoppers, func(member groupMember) error {
+		return member.resource.(Stopper).Stop(ctx)
+	})
+	errs = append(errs, eachMember(clients, func(member groupMember) error {
+		client := member.resource.(*SFTPClient)
+		err := client.drain(ctx)
+		client.Close()
+		return err
+	})...)
+	return errors.Join(errs...)
+}
+
+// eachMember runs fn for all members concurrently and labels the failures.
+func eachMember(members []groupMember, fn func(groupMember) error) []error {
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(member); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", member.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// drain waits until the client has no remote file open.
+func (client *SFTPClient) drain(ctx context.Context) error {
+	select {
+	case <-client.handles.drained():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("transfers still in flight on %d remote files: %w", client.Stats().OpenHandles, ctx.Err())
+	}
+}
@@ -0,0 +1,157 @@
+package sftpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/sftp"
+)
+
+// handleLimiter bounds the number of remote file handles a client keeps
+// open at once and tracks the high-water mark. Opens beyond the limit wait
+// for a slot instead of failing on the server.
+type handleLimiter struct {
+	slots chan struct{}
+	open  atomic.Int64
+	peak  atomic.Int64
+
+	mu sync.Mutex
+	// idle is closed while no handle is open.
+	idle chan struct{}
+}
+
+func newHandleLimiter(max int) *handleLimiter {
+	limiter := &handleLimiter{idle: make(chan struct{})}
+	close(limiter.idle)
+	if max > 0 {
+		limiter.slots = make(chan struct{}, max)
+	}
+	return limiter
+}
+
+// acquire takes a slot, waiting for one until ctx is done. Nothing is
+// opened once ctx is done, even with a slot free.
+func (l *handleLimiter) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	open := l.open.Add(1)
+	if open == 1 {
+		l.idle = make(chan struct{})
+	}
+	if open > l.peak.Load() {
+		l.peak.Store(open)
+	}
+	return nil
+}
+
+func (l *handleLimiter) release() {
+	l.mu.Lock()
+	if l.open.Add(-1) == 0 {
+		close(l.idle)
+	}
+	l.mu.Unlock()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// drained returns a channel closed once no handle is open.
+func (l *handleLimiter) drained() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.idle
+}
+
+// remoteFile is a remote handle holding one slot of the client's handle
+// limiter until it is closed.
+type remoteFile struct {
+	*sftp.File
+	once    sync.Once
+	release func()
+}
+
+func (f *remoteFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
+}
+
+// openRemote opens a remote file through the client's handle limiter, on
+// the least busy SFTP channel. A channel failing with a transport error
+// while the SSH connection is healthy is replaced and the open retried once.
+// Waiting for a handle gives up once ctx is done.
+func (client *SFTPClient) openRemote(ctx context.Context, remotePath string, flags int) (*remoteFile, error) {
+	if err := client.handles.acquire(ctx); err != nil {
+		return nil, err
+	}
+	sshClient, sftpClient, channels := client.connection()
+	if channels == nil {
+		file, err := sftpClient.OpenFile(remotePath, flags)
+		if err != nil {
+			client.handles.release()
+			return nil, err
+		}
+		return &remoteFile{File: file, release: client.handles.release}, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		i, channel := channels.acquire()
+		file, err := channel.OpenFile(remotePath, flags)
+		if err == nil {
+			return &remoteFile{File: file, release: func() {
+				channels.release(i)
+				client.handles.release()
+			}}, nil
+		}
+		channels.release(i)
+
+		if attempt > 1 || i == 0 || ClassifyError(err) != ErrorClassTransport || !client.sshAlive() {
+			client.handles.release()
+			return nil, err
+		}
+		if replaceErr := channels.replace(i, sshClient); replaceErr != nil {
+			client.handles.release()
+			return nil, err
+		}
+	}
+}
+
+// ClientStats is a snapshot of client-wide counters useful for tuning.
+type ClientStats struct {
+	OpenHandles     int64
+	PeakOpenHandles int64
+	Channels        int
+	// Labels is the traffic per transfer label, see WithTransferLabel.
+	Labels map[string]LabelStats
+}
+
+// Stats returns the current client-wide counters.
+func (client *SFTPClient) Stats() ClientStats {
+	if client == nil || client.handles == nil {
+		return ClientStats{}
+	}
+	stats := ClientStats{
+		OpenHandles:     client.handles.open.Load(),
+		PeakOpenHandles: client.handles.peak.Load(),
+		Channels:        1,
+	}
+	if _, _, channels := client.connection(); channels != nil {
+		stats.Channels = channels.size()
+	}
+	if client.bandwidth != nil {
+		stats.Labels = client.bandwidth.stats(client.now())
+	}
+	return stats
+}
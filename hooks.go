@@ -0,0 +1,35 @@
+package sftpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConnectionHooks are called as the client loses and regains its
+// connection, from the goroutine that reconnects and outside of the
+// client's locks. Nil hooks are skipped. OnDisconnect and
+// OnReconnectAttempt run while the reconnect is in progress: operations
+// they start that need the connection would wait for it, and so for
+// themselves.
+type ConnectionHooks struct {
+	// OnDisconnect is called with the error that showed the connection
+	// broken, once per loss. Explicit calls to ReConnect do not report it.
+	OnDisconnect func(err error)
+	// OnReconnectAttempt is called before every reconnect attempt,
+	// counting from 1 since the connection was lost.
+	OnReconnectAttempt func(attempt int)
+	// OnReconnected is called once connected again, with the time since
+	// the connection was lost.
+	OnReconnected func(d time.Duration)
+}
+
+// WithConnectionHooks registers callbacks for connection losses and
+// reconnects, for metrics and alerts.
+func WithConnectionHooks(hooks ConnectionHooks) Options {
+	return func(params *SFTPClientParams) error {
+		value := fmt.Sprintf("%p,%p,%p", hooks.OnDisconnect, hooks.OnReconnectAttempt, hooks.OnReconnected)
+		params.connectionHooks = hooks
+		params.record("WithConnectionHooks", value)
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package sftpc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// ErrHostKeyUnknown is returned when the known_hosts file has no key
+	// for the server, which callers may want to confirm and add.
+	ErrHostKeyUnknown = errors.New("host key unknown")
+
+	// ErrHostKeyMismatch is returned when the known_hosts file holds keys
+	// for the server but none of them matches the one it presented.
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+)
+
+// WithKnownHostsFile verifies the server host key against an OpenSSH
+// known_hosts file, including hashed host names and [host]:port entries for
+// servers not on port 22. The file is read once, when the option is
+// applied, and checked on every reconnect. Failures wrap ErrHostKeyUnknown
+// or ErrHostKeyMismatch together with the *knownhosts.KeyError.
+func WithKnownHostsFile(path string) Options {
+	return func(params *SFTPClientParams) error {
+		cb, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("failed to load known hosts file: %w", err)
+		}
+		params.hostKeyCallback = knownHostsCallback(cb)
+		params.record("WithKnownHostsFile", path)
+		return nil
+	}
+}
+
+// WithHostKeyFingerprint pins the server host key to a SHA256 fingerprint
+// as printed by ssh-keygen -lf, "SHA256:" followed by unpadded base64. A
+// different key fails the dial, and every reconnect, with
+// ErrHostKeyMismatch naming both fingerprints. MD5 fingerprints are
+// rejected.
+func WithHostKeyFingerprint(fingerprint string) Options {
+	return func(params *SFTPClientParams) error {
+		want, err := parseFingerprint(fingerprint)
+		if err != nil {
+			return err
+		}
+		params.hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			got := ssh.FingerprintSHA256(key)
+			if got != want {
+				return fmt.Errorf("%w for %s: expected %s, got %s", ErrHostKeyMismatch, hostname, want, got)
+			}
+			return nil
+		}
+		params.record("WithHostKeyFingerprint", fingerprint)
+		return nil
+	}
+}
+
+// parseFingerprint validates a SHA256 fingerprint and returns it in the
+// form of ssh.FingerprintSHA256.
+func parseFingerprint(fingerprint string) (string, error) {
+	digest, ok := strings.CutPrefix(strings.TrimSpace(fingerprint), "SHA256:")
+	if !ok {
+		return "", fmt.Errorf("unsupported fingerprint format %q, want SHA256:<base64> as printed by ssh-keygen -lf", fingerprint)
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(digest, "="))
+	if err != nil || len(sum) != 32 {
+		return "", fmt.Errorf("invalid SHA256 fingerprint %q", fingerprint)
+	}
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum), nil
+}
+
+// knownHostsCallback types the key errors of a knownhosts callback.
+func knownHostsCallback(cb ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("%w for %s: %w", ErrHostKeyUnknown, hostname, keyErr)
+			}
+			return fmt.Errorf("%w for %s: %w", ErrHostKeyMismatch, hostname, keyErr)
+		}
+		return err
+	}
+}
@@ -0,0 +1,265 @@
+//go:build integration
+
+package sftpc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The integration suite runs against a real OpenSSH server, see
+// docker-compose.yml and `make integration`. It is configured with:
+//
+//	SFTPC_IT_HOST, SFTPC_IT_PORT  server address, the suite skips without a host
+//	SFTPC_IT_USER                 login user
+//	SFTPC_IT_PASSWORD             password, or
+//	SFTPC_IT_KEY                  path of a private key
+//	SFTPC_IT_DIR                  writable scratch directory, "upload" by default
+//	SFTPC_IT_REPORT               where to write the capability report as JSON
+
+func integrationClient(t *testing.T) (*SFTPClient, string) {
+	t.Helper()
+	host := os.Getenv("SFTPC_IT_HOST")
+	if host == "" {
+		t.Skip("SFTPC_IT_HOST not set")
+	}
+
+	opts := []Options{WithHost(host), WithUser(os.Getenv("SFTPC_IT_USER")), WithInsecureHostKey()}
+	if port := os.Getenv("SFTPC_IT_PORT"); port != "" {
+		opts = append(opts, WithPort(port))
+	}
+	if password := os.Getenv("SFTPC_IT_PASSWORD"); password != "" {
+		opts = append(opts, WithPassword(password), WithAllowPasswordAuth())
+	}
+	if key := os.Getenv("SFTPC_IT_KEY"); key != "" {
+		opts = append(opts, WithPrivateKeyPath(key))
+	}
+	client, err := NewSFTPClient(opts...)
+	if err != nil {
+		t.Fatalf("NewSFTPClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+
+	base := os.Getenv("SFTPC_IT_DIR")
+	if base == "" {
+		base = "upload"
+	}
+	dir := path.Join(base, "sftpc-it-"+t.Name()+"-"+time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := client.CreateRemoteDirRecursive(dir); err != nil {
+		t.Fatalf("CreateRemoteDirRecursive: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := client.RemoveAll(dir); err != nil {
+			t.Logf("failed to clean up %s: %v", dir, err)
+		}
+	})
+	return client, dir
+}
+
+func writeLocal(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "local.bin")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readRemote(t *testing.T, client *SFTPClient, p string) []byte {
+	t.Helper()
+	f, err := client.sftpClient.Open(p)
+	if err != nil {
+		t.Fatalf("Open %s: %v", p, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", p, err)
+	}
+	return data
+}
+
+func TestIntegrationCapabilities(t *testing.T) {
+	client, dir := integrationClient(t)
+
+	report, err := client.CheckCapabilities(dir)
+	if err != nil {
+		t.Fatalf("CheckCapabilities: %v", err)
+	}
+	for _, result := range report.Results {
+		t.Logf("%-16s supported=%-5v %s %v", result.Name, result.Supported, result.Detail, result.Err)
+	}
+	if out := os.Getenv("SFTPC_IT_REPORT"); out != "" {
+		data, err := report.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(out, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{CapabilityPosixRename, CapabilityStatVFS, CapabilityResume, CapabilityChmod, CapabilityChtimes} {
+		if !report.Supported(name) {
+			t.Errorf("OpenSSH should support %s", name)
+		}
+	}
+}
+
+func TestIntegrationResume(t *testing.T) {
+	client, dir := integrationClient(t)
+	data := randomBytes(t, 4<<20)
+	remote := path.Join(dir, "resume.bin")
+
+	f, err := client.sftpClient.Create(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data[:1<<20]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	stats, err := client.Put(writeLocal(t, data), remote, WithResume())
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !stats.Resumed || stats.StartOffset != 1<<20 {
+		t.Fatalf("expected resume from 1 MiB, got %+v", stats)
+	}
+	if !bytes.Equal(readRemote(t, client, remote), data) {
+		t.Fatalf("resumed file differs")
+	}
+}
+
+func TestIntegrationAtomicOverwrite(t *testing.T) {
+	client, dir := integrationClient(t)
+	remote := path.Join(dir, "atomic.txt")
+
+	if _, err := client.Put(writeLocal(t, []byte("old")), remote); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := client.Put(writeLocal(t, []byte("new contents")), remote, WithAtomic(), WithOverwrite()); err != nil {
+		t.Fatalf("atomic Put: %v", err)
+	}
+	if got := readRemote(t, client, remote); string(got) != "new contents" {
+		t.Fatalf("remote = %q", got)
+	}
+	entries, err := client.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary file left behind: %d entries", len(entries))
+	}
+}
+
+func TestIntegrationPermissionsAndTimes(t *testing.T) {
+	client, dir := integrationClient(t)
+	remote := path.Join(dir, "attrs.txt")
+	if _, err := client.Put(writeLocal(t, []byte("attrs")), remote); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := client.sftpClient.Chmod(remote, 0600); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if err := client.sftpClient.Chtimes(remote, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	info, err := client.FileInfo(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("attributes not kept: mode %v, mtime %v", info.Mode(), info.ModTime())
+	}
+}
+
+func TestIntegrationStatVFSAndPosixRename(t *testing.T) {
+	client, dir := integrationClient(t)
+
+	vfs, err := client.sftpClient.StatVFS(dir)
+	if err != nil {
+		t.Fatalf("StatVFS: %v", err)
+	}
+	if vfs.TotalSpace() == 0 {
+		t.Fatalf("statvfs reports no space: %+v", vfs)
+	}
+
+	a, b := path.Join(dir, "a.txt"), path.Join(dir, "b.txt")
+	for _, p := range []string{a, b} {
+		if _, err := client.Put(writeLocal(t, []byte(p)), p); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := client.sftpClient.PosixRename(a, b); err != nil {
+		t.Fatalf("PosixRename: %v", err)
+	}
+	if got := readRemote(t, client, b); string(got) != a {
+		t.Fatalf("rename did not replace the target: %q", got)
+	}
+}
+
+func TestIntegrationDeepWalk(t *testing.T) {
+	client, dir := integrationClient(t)
+
+	deep := dir
+	for i := 0; i < 12; i++ {
+		deep = path.Join(deep, "level")
+	}
+	if err := client.CreateRemoteDirRecursive(deep); err != nil {
+		t.Fatalf("CreateRemoteDirRecursive: %v", err)
+	}
+	if _, err := client.Put(writeLocal(t, []byte("bottom")), path.Join(deep, "bottom.txt")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var files, dirs int
+	err := client.Walk(dir, func(info RemoteFileInfo) error {
+		if info.IsDir() {
+			dirs++
+		} else {
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if files != 1 || dirs < 12 {
+		t.Fatalf("walk saw %d files and %d dirs", files, dirs)
+	}
+}
+
+func TestIntegrationReconnect(t *testing.T) {
+	client, dir := integrationClient(t)
+	client.sleep = func(time.Duration) {}
+	data := randomBytes(t, 8<<20)
+	remote := path.Join(dir, "reconnect.bin")
+
+	// Kill the connection once a quarter of the file is across
+	conn := client.sshClient
+	var once sync.Once
+	progress := func(info ProgressInfo) {
+		if info.Transferred > int64(len(data))/4 {
+			once.Do(func() { conn.Close() })
+		}
+	}
+	stats, err := client.Put(writeLocal(t, data), remote, WithProgress(progress))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if stats.Attempts < 2 {
+		t.Fatalf("expected a retry, got %d attempts", stats.Attempts)
+	}
+	if !bytes.Equal(readRemote(t, client, remote), data) {
+		t.Fatalf("remote file differs after reconnect")
+	}
+}
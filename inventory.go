@@ -0,0 +1,161 @@
+package sftpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// InventoryFormat selects the output format of ExportInventory.
+type InventoryFormat int
+
+const (
+	// InventoryJSONLines writes one JSON object per line.
+	InventoryJSONLines InventoryFormat = iota
+	// InventoryCSV writes a header row followed by one row per entry.
+	InventoryCSV
+)
+
+// InventoryRecord is one row of an inventory export. Size is zero for
+// directories, whose reported size differs between servers and file systems.
+// SHA256 is only set when requested with WithInventoryChecksum and the file
+// is not larger than the configured limit. Symlink marks symbolic links in
+// JSON lines; CSV rows only show them when their type is "symlink".
+type InventoryRecord struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime"`
+	Mode    string `json:"mode"`
+	UID     uint32 `json:"uid"`
+	GID     uint32 `json:"gid"`
+	SHA256  string `json:"sha256,omitempty"`
+	Symlink bool   `json:"symlink,omitempty"`
+}
+
+var inventoryCSVHeader = []string{"path", "type", "size", "mtime", "mode", "uid", "gid", "sha256"}
+
+// WithInventoryChecksum adds the SHA-256 of every regular file up to maxSize
+// bytes to the inventory. Larger files are listed without a checksum.
+func WithInventoryChecksum(maxSize int64) WalkOption {
+	return func(params *walkParams) error {
+		if maxSize < 0 {
+			return fmt.Errorf("invalid checksum size limit: %d", maxSize)
+		}
+		params.checksum = true
+		params.checksumMaxSize = maxSize
+		return nil
+	}
+}
+
+// ExportInventory walks root in sorted order and streams one record per
+// entry to w in the requested format, without holding the tree in memory.
+func (client *SFTPClient) ExportInventory(root string, format InventoryFormat, w io.Writer, opts ...WalkOption) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ExportInventory", root)(&err)
+
+	params, err := newWalkParams(opts...)
+	if err != nil {
+		return err
+	}
+	params.sorted = true
+
+	var write func(InventoryRecord) error
+	var flush func() error
+
+	switch format {
+	case InventoryJSONLines:
+		enc := json.NewEncoder(w)
+		write = func(r InventoryRecord) error { return enc.Encode(r) }
+		flush = func() error { return nil }
+	case InventoryCSV:
+		cw := csv.NewWriter(w)
+		write = func(r InventoryRecord) error {
+			return cw.Write([]string{
+				r.Path, r.Type, strconv.FormatInt(r.Size, 10), r.ModTime, r.Mode,
+				strconv.FormatUint(uint64(r.UID), 10), strconv.FormatUint(uint64(r.GID), 10), r.SHA256,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		err = cw.Write(inventoryCSVHeader)
+		if err != nil {
+			return fmt.Errorf("failed to write inventory: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown inventory format: %d", format)
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	err = client.walk(root, params, func(info RemoteFileInfo) error {
+		record, err := client.inventoryRecord(info, params)
+		if err != nil {
+			return err
+		}
+		err = write(record)
+		if err != nil {
+			return fmt.Errorf("failed to write inventory: %w", err)
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+func (client *SFTPClient) inventoryRecord(info RemoteFileInfo, params *walkParams) (InventoryRecord, error) {
+	record := InventoryRecord{
+		Path:    info.Path,
+		Type:    entryType(info.Mode()),
+		ModTime: info.ModTime().UTC().Format(time.RFC3339),
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		UID:     info.UID(),
+		GID:     info.GID(),
+		Symlink: info.Symlink,
+	}
+	if info.Mode().IsRegular() {
+		record.Size = info.Size()
+	}
+
+	if params.checksum && info.Mode().IsRegular() && info.Size() <= params.checksumMaxSize {
+		sum, err := client.remoteChecksum(info.Path)
+		if err != nil {
+			return record, err
+		}
+		record.SHA256 = sum
+	}
+
+	return record, nil
+}
+
+func (client *SFTPClient) remoteChecksum(remotePath string) (string, error) {
+	remoteFile, err := client.openRemote(context.Background(), remotePath, os.O_RDONLY)
+	if err != nil {
+		return "", fmt.Errorf("failed to open remote file %q: %w", remotePath, err)
+	}
+	defer remoteFile.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, remoteFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read remote file %q: %w", remotePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,449 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The methods below predate the options-based API and are kept as adapters
+// over it, logging and printing as they always did. They resume transfers
+// from the size of the existing destination file.
+
+// WithSkipPermissionErrors restores the old behavior of DownloadFile and
+// WalkFile, logging and skipping remote paths that cannot be read for lack
+// of permission, or that WalkFile finds missing, instead of failing.
+func WithSkipPermissionErrors() Options {
+	return func(params *SFTPClientParams) error {
+		params.skipPermissionErrors = true
+		params.record("WithSkipPermissionErrors", "true")
+		return nil
+	}
+}
+
+// WithProgressWriter sends the progress lines and success messages of
+// UploadFileWithProgress and DownloadFileWithProgress to w instead of
+// standard output.
+func WithProgressWriter(w io.Writer) Options {
+	return func(params *SFTPClientParams) error {
+		if w == nil {
+			return fmt.Errorf("progress writer must not be nil")
+		}
+		params.progressWriter = w
+		params.record("WithProgressWriter", fmt.Sprintf("%p", w))
+		return nil
+	}
+}
+
+// WithQuiet silences the progress lines and success messages of
+// UploadFileWithProgress and DownloadFileWithProgress.
+func WithQuiet() Options {
+	return func(params *SFTPClientParams) error {
+		params.progressWriter = io.Discard
+		params.record("WithQuiet", "true")
+		return nil
+	}
+}
+
+// UploadFile uploads localPath to remotePath, continuing from the size of
+// an existing remote file. A remote file larger than the local one is
+// uploaded again from scratch.
+//
+// Deprecated: Use Put with WithResume.
+func (client *SFTPClient) UploadFile(localPath, remotePath string) error {
+	return client.UploadFileContext(context.Background(), localPath, remotePath)
+}
+
+// UploadFileContext is UploadFile giving up once ctx is done.
+func (client *SFTPClient) UploadFileContext(ctx context.Context, localPath, remotePath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "UploadFile", remotePath)
+	defer done(&err)
+
+	_, err = client.uploadLegacy(ctx, localPath, remotePath, nil)
+	return err
+}
+
+// UploadFileN is UploadFile returning the number of bytes written by this
+// call, zero when the remote file was already complete.
+//
+// Deprecated: Use Put with WithResume.
+func (client *SFTPClient) UploadFileN(localPath, remotePath string) (_ int64, err error) {
+	if client == nil {
+		return 0, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadFileN", remotePath)
+	defer done(&err)
+
+	stats, err := client.uploadLegacy(ctx, localPath, remotePath, nil)
+	return stats.bytes(), err
+}
+
+// UploadFileStats is UploadFile reporting what was transferred, over all
+// attempts.
+//
+// Deprecated: Use Put with WithResume.
+func (client *SFTPClient) UploadFileStats(localPath, remotePath string) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadFileStats", remotePath)
+	defer done(&err)
+
+	return client.uploadLegacy(ctx, localPath, remotePath, nil)
+}
+
+// UploadFileWithProgress is UploadFile printing the progress on stdout.
+//
+// Deprecated: Use Put with WithResume and WithProgress.
+func (client *SFTPClient) UploadFileWithProgress(localPath, remotePath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadFileWithProgress", remotePath)
+	defer done(&err)
+
+	_, err = client.uploadWithProgress(ctx, localPath, remotePath)
+	return err
+}
+
+// UploadFileWithProgressN is UploadFileN printing the progress on stdout.
+//
+// Deprecated: Use Put with WithResume and WithProgress.
+func (client *SFTPClient) UploadFileWithProgressN(localPath, remotePath string) (_ int64, err error) {
+	if client == nil {
+		return 0, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadFileWithProgressN", remotePath)
+	defer done(&err)
+
+	return client.uploadWithProgress(ctx, localPath, remotePath)
+}
+
+// uploadWithProgress is the shared body of UploadFileWithProgress and
+// UploadFileWithProgressN.
+func (client *SFTPClient) uploadWithProgress(ctx context.Context, localPath, remotePath string) (int64, error) {
+	stats, err := client.uploadLegacy(ctx, localPath, remotePath, client.throttleProgress(client.legacyProgress("Uploading")))
+	if err != nil {
+		return stats.bytes(), err
+	}
+
+	fmt.Fprintln(client.params.ProgressWriter(), "\nFile uploaded successfully")
+	return stats.bytes(), nil
+}
+
+// DownloadFile downloads remotePath into localPath, continuing from the
+// size of an existing local file and retrying failed copies as the retry
+// policy allows. Remote files that cannot be read for lack of permission
+// fail with ErrPermissionDenied, or are logged and skipped with
+// WithSkipPermissionErrors.
+//
+// Deprecated: Use Get with WithResume.
+func (client *SFTPClient) DownloadFile(remotePath, localPath string) error {
+	return client.DownloadFileContext(context.Background(), remotePath, localPath)
+}
+
+// DownloadFileContext is DownloadFile giving up once ctx is done.
+func (client *SFTPClient) DownloadFileContext(ctx context.Context, remotePath, localPath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "DownloadFile", remotePath)
+	defer done(&err)
+
+	_, err = client.downloadFile(ctx, remotePath, localPath)
+	return err
+}
+
+// DownloadFileN is DownloadFile returning the number of bytes written by
+// this call over all attempts, zero when the local file was already
+// complete.
+//
+// Deprecated: Use Get with WithResume.
+func (client *SFTPClient) DownloadFileN(remotePath, localPath string) (_ int64, err error) {
+	if client == nil {
+		return 0, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadFileN", remotePath)
+	defer done(&err)
+
+	stats, err := client.downloadFile(ctx, remotePath, localPath)
+	return stats.bytes(), err
+}
+
+// DownloadFileStats is DownloadFile reporting what was transferred, over
+// all attempts. The stats are nil when nothing was attempted, as for files
+// skipped with WithSkipPermissionErrors.
+//
+// Deprecated: Use Get with WithResume.
+func (client *SFTPClient) DownloadFileStats(remotePath, localPath string) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadFileStats", remotePath)
+	defer done(&err)
+
+	return client.downloadFile(ctx, remotePath, localPath)
+}
+
+// downloadFile is the shared body of DownloadFile, DownloadFileN and
+// DownloadFileStats.
+func (client *SFTPClient) downloadFile(ctx context.Context, remotePath, localPath string) (*TransferStats, error) {
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	stats, _, err := client.downloadLegacy(ctx, remotePath, localPath, client.params.RetryPolicy(), nil)
+	return stats, deadlineError(ctx, err)
+}
+
+// DownloadFileWithProgress is DownloadFile printing the progress on stdout,
+// without retries.
+//
+// Deprecated: Use Get with WithResume and WithProgress.
+func (client *SFTPClient) DownloadFileWithProgress(remotePath, localPath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadFileWithProgress", remotePath)
+	defer done(&err)
+
+	_, err = client.downloadWithProgress(ctx, remotePath, localPath)
+	return err
+}
+
+// DownloadFileWithProgressN is DownloadFileN printing the progress on
+// stdout, without retries.
+//
+// Deprecated: Use Get with WithResume and WithProgress.
+func (client *SFTPClient) DownloadFileWithProgressN(remotePath, localPath string) (_ int64, err error) {
+	if client == nil {
+		return 0, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadFileWithProgressN", remotePath)
+	defer done(&err)
+
+	return client.downloadWithProgress(ctx, remotePath, localPath)
+}
+
+// downloadWithProgress is the shared body of DownloadFileWithProgress and
+// DownloadFileWithProgressN.
+func (client *SFTPClient) downloadWithProgress(ctx context.Context, remotePath, localPath string) (int64, error) {
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	stats, done, err := client.downloadLegacy(ctx, remotePath, localPath, NoRetries, client.throttleProgress(client.legacyProgress("Downloading")))
+	if err != nil || !done {
+		return stats.bytes(), deadlineError(ctx, err)
+	}
+
+	fmt.Fprintln(client.params.ProgressWriter(), "\nFile downloaded successfully")
+	return stats.bytes(), nil
+}
+
+// UploadFileProgress is UploadFile calling fn with the bytes of the file
+// transferred so far, resumed bytes included, and its total size, at most
+// every ProgressBytes bytes or ProgressInterval, and once complete. It
+// prints nothing.
+//
+// Deprecated: Use Put with WithResume and WithProgress.
+func (client *SFTPClient) UploadFileProgress(localPath, remotePath string, fn func(transferred, total int64)) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "UploadFileProgress", remotePath)
+	defer done(&err)
+
+	_, err = client.uploadLegacy(ctx, localPath, remotePath, client.throttleProgress(fn))
+	return err
+}
+
+// DownloadFileProgress is DownloadFile calling fn as UploadFileProgress
+// does. It prints nothing.
+//
+// Deprecated: Use Get with WithResume and WithProgress.
+func (client *SFTPClient) DownloadFileProgress(remotePath, localPath string, fn func(transferred, total int64)) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadFileProgress", remotePath)
+	defer done(&err)
+
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	_, _, err = client.downloadLegacy(ctx, remotePath, localPath, client.params.RetryPolicy(), client.throttleProgress(fn))
+	return deadlineError(ctx, err)
+}
+
+// uploadLegacy is the shared body of the legacy uploads.
+func (client *SFTPClient) uploadLegacy(ctx context.Context, localPath, remotePath string, progress func(ProgressInfo)) (*TransferStats, error) {
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	err := client.ensureConnectedContext(ctx)
+	if err != nil {
+		return nil, deadlineError(ctx, fmt.Errorf("failed to reconnect: %w", err))
+	}
+
+	_, err = os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local file info: %w", err)
+	}
+
+	// Write-only servers cannot tell how much landed, upload from scratch
+	params := &transferParams{resume: !client.params.WriteOnly(), progress: progress, ctx: ctx}
+	stats, err := client.put(localPath, remotePath, params)
+	return stats, deadlineError(ctx, err)
+}
+
+// downloadLegacy is the shared body of the legacy downloads, bounded by
+// ctx. It sums up the attempts in its stats and reports whether anything
+// was downloaded.
+func (client *SFTPClient) downloadLegacy(ctx context.Context, remotePath, localPath string, policy RetryPolicy, progress func(ProgressInfo)) (*TransferStats, bool, error) {
+	err := client.ensureConnectedWithRetries(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	_, err = client.statSource(remotePath)
+	if err != nil {
+		if os.IsPermission(err) {
+			if client.params.SkipPermissionErrors() {
+				client.params.Logger().Warnf("Permission denied for file: %q", remotePath)
+				return nil, false, nil
+			}
+			return nil, false, fmt.Errorf("%w: %q: %w", ErrPermissionDenied, remotePath, err)
+		}
+		return nil, false, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+
+	start := time.Now()
+	params := &transferParams{resume: true, progress: progress, ctx: ctx}
+	var total *TransferStats
+	for attempt := 1; ; attempt++ {
+		stats, err := client.get(remotePath, localPath, params)
+		if stats != nil {
+			if total == nil {
+				total = stats
+			} else {
+				total.BytesTransferred += stats.BytesTransferred
+			}
+			total.Attempts = attempt
+			total.Duration = time.Since(start)
+		}
+		switch {
+		case err == nil && stats.Resumed && stats.StartOffset == stats.TotalSize:
+			client.params.Logger().Debugf("File already fully downloaded: %q", localPath)
+			return total, false, nil
+		case err == nil:
+			client.params.Logger().Debugf("Resumed and downloaded file: %q", localPath)
+			return total, true, nil
+		case !IsRetryable(err):
+			// The failure is there to stay, retrying would not help
+			return total, false, err
+		case attempt >= policy.MaxAttempts:
+			if policy.MaxAttempts == 1 {
+				return total, false, err
+			}
+			return total, false, fmt.Errorf("failed to copy file to local after %d attempts: %w", policy.MaxAttempts, err)
+		}
+
+		client.params.Logger().Warnf("Download failed, retrying... attempt %d", attempt)
+		err = client.pause(ctx, policy.Backoff().Delay(attempt), err)
+		if err != nil {
+			return total, false, err
+		}
+		err = client.ensureConnectedWithRetries(ctx)
+		if err != nil {
+			return total, false, fmt.Errorf("failed to reconnect: %w", err)
+		}
+	}
+}
+
+// ProgressBytes and ProgressInterval are how often UploadFileProgress and
+// DownloadFileProgress report progress at most: after that many bytes or
+// that much time since the last report, whichever comes first.
+const (
+	ProgressBytes    = 1 << 20
+	ProgressInterval = 500 * time.Millisecond
+)
+
+// throttleProgress adapts fn to a progress callback reporting as often as
+// ProgressBytes and ProgressInterval allow, and always on completion.
+func (client *SFTPClient) throttleProgress(fn func(transferred, total int64)) func(ProgressInfo) {
+	if fn == nil {
+		return nil
+	}
+	var last time.Time
+	lastBytes := int64(-1)
+	return func(info ProgressInfo) {
+		now := client.now()
+		complete := info.Total >= 0 && info.Transferred >= info.Total
+		if !complete && lastBytes >= 0 && info.Transferred-lastBytes < ProgressBytes && now.Sub(last) < ProgressInterval {
+			return
+		}
+		last, lastBytes = now, info.Transferred
+		fn(info.Transferred, info.Total)
+	}
+}
+
+// legacyProgress prints the progress line of the legacy transfers.
+func (client *SFTPClient) legacyProgress(verb string) func(transferred, total int64) {
+	w := client.params.ProgressWriter()
+	return func(transferred, total int64) {
+		info := ProgressInfo{Transferred: transferred, Total: total}
+		fmt.Fprintf(w, "\r%s... %.2f%% complete", verb, info.Percent())
+	}
+}
+
+// ListFilesAndFolders lists the entries of remotePath.
+//
+// Deprecated: Use List.
+func (client *SFTPClient) ListFilesAndFolders(remotePath string) (_ []os.FileInfo, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ListFilesAndFolders", remotePath)(&err)
+
+	return client.list(context.Background(), remotePath)
+}
+
+// CreateRemoteDirRecursive creates the missing directories of
+// remoteBasePath one by one, relative to the working directory unless
+// remoteBasePath is absolute. Both "/" and "\" separate directories. A file
+// in the way fails with ErrNotADirectory.
+//
+// Deprecated: Use MakeDirAll.
+func (client *SFTPClient) CreateRemoteDirRecursive(remoteBasePath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("CreateRemoteDirRecursive", remoteBasePath)(&err)
+
+	// Remote paths are slash separated whatever the local OS, backslashes
+	// are taken for separators written on Windows
+	remoteBasePath = strings.ReplaceAll(remoteBasePath, `\`, "/")
+	var currentPath string
+	if strings.HasPrefix(remoteBasePath, "/") {
+		currentPath = "/"
+	}
+	for _, dir := range strings.Split(remoteBasePath, "/") {
+		if dir == "" || dir == "." {
+			continue
+		}
+		currentPath = path.Join(currentPath, dir)
+
+		// Create the directory unless it exists, also when another writer
+		// creates it concurrently
+		err := client.withConn(func() error {
+			_, err := client.mkdir(currentPath)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", currentPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package sftpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// listJSONFlushEvery is the number of entries ListJSON writes between two
+// flushes of its writer.
+const listJSONFlushEvery = 1000
+
+type entryJSON struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`
+	ModTime string `json:"modTime"`
+	IsDir   bool   `json:"isDir"`
+}
+
+// flushWriter flushes w if it buffers, as http.ResponseWriter and
+// bufio.Writer do.
+func flushWriter(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+// ListJSON writes the entries of remotePath to w as a JSON array of
+// objects, streaming them as the server sends its listing so that memory
+// stays bounded whatever the directory size. Entries come in server order
+// unless WithInMemorySort is given. w is flushed every 1000 entries when it
+// has a Flush method. When the listing fails half way, the array is still
+// closed so the output parses, and the error is returned.
+func (client *SFTPClient) ListJSON(remotePath string, w io.Writer, opts ...ListOption) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ListJSON", remotePath)(&err)
+
+	params, err := newListParams(opts...)
+	if err != nil {
+		return err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	// Only the opening is retried: once entries are written, a listing cut
+	// short cannot be resumed.
+	var s *dirStream
+	err = client.withConn(func() (err error) {
+		s, err = client.openDirStream(context.Background(), remotePath)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list directory: %w", err)
+	}
+	defer s.Close()
+
+	_, err = io.WriteString(w, "[")
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	var writeErr error
+	write := func(entry RemoteEntry) error {
+		data, err := json.Marshal(entryJSON{
+			Name:    entry.Name,
+			Path:    entry.Path,
+			Size:    entry.Size,
+			Mode:    entry.Mode.String(),
+			ModTime: entry.ModTime.UTC().Format(time.RFC3339),
+			IsDir:   entry.IsDir(),
+		})
+		if err != nil {
+			return err
+		}
+		if written > 0 {
+			data = append([]byte{','}, data...)
+		}
+		_, writeErr = w.Write(data)
+		if writeErr != nil {
+			return writeErr
+		}
+		written++
+		if written%listJSONFlushEvery == 0 {
+			writeErr = flushWriter(w)
+		}
+		return writeErr
+	}
+
+	var sorted []RemoteEntry
+	err = s.each(remotePath, func(entry RemoteEntry) error {
+		if !params.keeps(entry) {
+			return nil
+		}
+		if params.sorted {
+			sorted = append(sorted, entry)
+			return nil
+		}
+		return write(entry)
+	})
+	if err == nil && params.sorted {
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Name < sorted[j].Name
+		})
+		for _, entry := range sorted {
+			err = write(entry)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write listing: %w", writeErr)
+	}
+
+	_, closeErr := io.WriteString(w, "]\n")
+	if closeErr == nil {
+		closeErr = flushWriter(w)
+	}
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write listing: %w", closeErr)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package sftpc
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// DefaultListStableDelay is the pause between the listings of ListStable
+// unless WithListDelay says otherwise.
+const DefaultListStableDelay = time.Second
+
+// RemoteEntry is one entry of a directory listing.
+type RemoteEntry struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Mode    os.FileMode
+}
+
+// IsDir reports whether the entry is a directory.
+func (e RemoteEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ListOption configures ListStable and ListJSON.
+type ListOption func(*listParams) error
+
+type listParams struct {
+	delay  time.Duration
+	filter func(RemoteEntry) bool
+	sorted bool
+}
+
+func newListParams(opts ...ListOption) (*listParams, error) {
+	params := &listParams{delay: DefaultListStableDelay}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// WithListDelay sets the pause between two listings of ListStable.
+func WithListDelay(d time.Duration) ListOption {
+	return func(params *listParams) error {
+		if d < 0 {
+			return fmt.Errorf("invalid list delay: %s", d)
+		}
+		params.delay = d
+		return nil
+	}
+}
+
+// WithListFilter keeps only the entries keep returns true for.
+func WithListFilter(keep func(RemoteEntry) bool) ListOption {
+	return func(params *listParams) error {
+		params.filter = keep
+		return nil
+	}
+}
+
+// WithInMemorySort sorts the entries of ListJSON by name. The whole listing
+// is held in memory for that; without it entries come in server order.
+func WithInMemorySort() ListOption {
+	return func(params *listParams) error {
+		params.sorted = true
+		return nil
+	}
+}
+
+func (params *listParams) keeps(entry RemoteEntry) bool {
+	return params.filter == nil || params.filter(entry)
+}
+
+// ListStable lists remotePath repeatedly until two consecutive listings
+// agree on the name, size and modification time of every entry, so that a
+// directory being modified is not seen half way through a change. After
+// attempts listings without agreement it returns the last listing together
+// with ErrListingUnstable. Entries are sorted by name.
+func (client *SFTPClient) ListStable(remotePath string, attempts int, opts ...ListOption) (_ []RemoteEntry, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ListStable", remotePath)(&err)
+	if attempts < 2 {
+		return nil, fmt.Errorf("invalid number of attempts: %d, two listings are needed to compare", attempts)
+	}
+
+	params, err := newListParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	var previous []RemoteEntry
+	for attempt := 1; ; attempt++ {
+		entries, err := client.listEntries(remotePath, params)
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 1 && sameListing(previous, entries) {
+			return entries, nil
+		}
+		if attempt >= attempts {
+			return entries, fmt.Errorf("%w: %q changed in each of %d listings", ErrListingUnstable, remotePath, attempts)
+		}
+		previous = entries
+		client.sleep(params.delay)
+	}
+}
+
+func (client *SFTPClient) listEntries(remotePath string, params *listParams) ([]RemoteEntry, error) {
+	infos, err := client.readDir(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+	entries := make([]RemoteEntry, 0, len(infos))
+	for _, info := range infos {
+		entry := RemoteEntry{
+			Name:    info.Name(),
+			Path:    path.Join(remotePath, info.Name()),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+		}
+		if params.keeps(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func sameListing(a, b []RemoteEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Size != b[i].Size || !a[i].ModTime.Equal(b[i].ModTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+)
+
+// Logger receives the messages of the client. Debug is for routine events
+// such as resumed transfers and existing directories, Warn for failures the
+// client works around, such as retries.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// WithLogger sends the messages of the client to l. Without it they are
+// discarded.
+func WithLogger(l Logger) Options {
+	return func(params *SFTPClientParams) error {
+		if l == nil {
+			return fmt.Errorf("logger must not be nil")
+		}
+		params.logger = l
+		params.record("WithLogger", fmt.Sprintf("%p", l))
+		return nil
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+func (nopLogger) Warnf(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
+
+// NewSlogLogger adapts l to Logger.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) logf(level slog.Level, format string, args []any) {
+	if s.l.Enabled(context.Background(), level) {
+		s.l.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (s slogLogger) Debugf(format string, args ...any) { s.logf(slog.LevelDebug, format, args) }
+func (s slogLogger) Infof(format string, args ...any)  { s.logf(slog.LevelInfo, format, args) }
+func (s slogLogger) Warnf(format string, args ...any)  { s.logf(slog.LevelWarn, format, args) }
+func (s slogLogger) Errorf(format string, args ...any) { s.logf(slog.LevelError, format, args) }
+
+// NewStdLogger adapts l to Logger, printing every level as the client did
+// before WithLogger.
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Debugf(format string, args ...any) { s.l.Printf(format, args...) }
+func (s stdLogger) Infof(format string, args ...any)  { s.l.Printf(format, args...) }
+func (s stdLogger) Warnf(format string, args ...any)  { s.l.Printf(format, args...) }
+func (s stdLogger) Errorf(format string, args ...any) { s.l.Printf(format, args...) }
@@ -0,0 +1,235 @@
+package sftpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WithManifestChecksums makes DownloadByManifest read the manifest entries
+// as sha256sum lines, "<hex digest>  <path>", and fail the files whose
+// download does not match their digest. Those files are downloaded under a
+// temporary name and only moved into place once their digest matches, so a
+// mismatch leaves the previous local file, if any, untouched.
+func WithManifestChecksums() TransferOption {
+	return func(params *transferParams) error {
+		params.manifestChecksums = true
+		return nil
+	}
+}
+
+// ParseManifest reads one relative path per line, skipping blank lines and
+// lines starting with "#". It is the default parser of DownloadByManifest.
+func ParseManifest(r io.Reader) ([]string, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// manifestEntry is a file listed in a manifest, relative to its directory.
+type manifestEntry struct {
+	rel    string
+	sha256 string
+}
+
+// parseManifestEntry checks that line names a file below the manifest
+// directory, splitting off the digest with WithManifestChecksums.
+func parseManifestEntry(line string, checksums bool) (manifestEntry, error) {
+	var entry manifestEntry
+	rel := line
+	if checksums {
+		digest, name, ok := strings.Cut(line, " ")
+		if !ok || len(digest) != 2*sha256.Size {
+			return entry, fmt.Errorf("invalid manifest entry %q: want \"<sha256>  <path>\"", line)
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return entry, fmt.Errorf("invalid manifest entry %q: %w", line, err)
+		}
+		entry.sha256 = strings.ToLower(digest)
+		// sha256sum marks binary mode with a star instead of a space
+		rel = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+	}
+
+	clean := path.Clean(rel)
+	if rel == "" || path.IsAbs(rel) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return entry, fmt.Errorf("invalid manifest entry %q: not a path below the manifest directory", line)
+	}
+	entry.rel = clean
+	return entry, nil
+}
+
+// DownloadByManifest downloads the files listed in the manifest at
+// manifestRemotePath, relative to the manifest's directory, into the same
+// layout below localDir, without listing the directory. parse turns the
+// manifest into entries, ParseManifest when nil. Entries escaping the
+// manifest directory fail the whole call before anything is downloaded.
+// Listed files missing on the server fail with ErrManifestFileMissing,
+// classified as ErrorClassMissing. Include and exclude filters apply to the
+// entries.
+func (client *SFTPClient) DownloadByManifest(manifestRemotePath string, parse func(io.Reader) ([]string, error), localDir string, opts ...TransferOption) (_ *BatchResult, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(context.Background(), "DownloadByManifest", manifestRemotePath)
+	defer done(&err)
+	if parse == nil {
+		parse = ParseManifest
+	}
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	params.ctx = ctx
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	result := &BatchResult{Started: start, DryRun: params.dryRun}
+
+	entries, err := client.readManifest(ctx, manifestRemotePath, parse, params.manifestChecksums)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.dryRun {
+		err = os.MkdirAll(localDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local directory: %w", err)
+		}
+	}
+
+	dir := path.Dir(manifestRemotePath)
+	for _, entry := range entries {
+		if !params.selectsFile(entry.rel) {
+			continue
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(entry.rel))
+		item := BatchItem{LocalPath: localPath, RemotePath: path.Join(dir, entry.rel)}
+		verify := entry.sha256 != "" && !params.dryRun
+		if verify {
+			item.LocalPath = client.manifestTempPath(localPath)
+		}
+		if !params.dryRun {
+			err = os.MkdirAll(filepath.Dir(item.LocalPath), 0755)
+			if err != nil {
+				result.Duration = time.Since(start)
+				return result, fmt.Errorf("failed to create local directory: %w", err)
+			}
+		}
+		if err := client.awaitWindow(params, result); err != nil {
+			result.Duration = time.Since(start)
+			return result, err
+		}
+		client.runItem(result, item, params, false)
+
+		done := &result.Items[len(result.Items)-1]
+		switch {
+		case done.Status == StatusFailed && errors.Is(done.Err, fs.ErrNotExist):
+			done.Err = fmt.Errorf("%w: %w", ErrManifestFileMissing, done.Err)
+		case done.Status == StatusTransferred && verify:
+			err := checkLocalSHA256(done.LocalPath, entry.sha256)
+			if err == nil {
+				err = os.Rename(done.LocalPath, localPath)
+				if err != nil {
+					err = fmt.Errorf("failed to rename downloaded file: %w", err)
+				}
+			}
+			if err != nil {
+				os.Remove(done.LocalPath)
+				done.Status = StatusFailed
+				done.Err = err
+			}
+		case verify && !params.resume:
+			os.Remove(done.LocalPath)
+		}
+		if verify {
+			done.LocalPath = localPath
+			if done.Stats != nil {
+				done.Stats.LocalPath = localPath
+			}
+		}
+	}
+	client.verifySample(result, params)
+
+	result.Duration = time.Since(start)
+	return result, result.err()
+}
+
+// manifestTempPath is where DownloadByManifest writes a file the manifest
+// gives a checksum for, until the digest matches and the file is renamed to
+// localPath. A corrupt copy never replaces localPath.
+func (client *SFTPClient) manifestTempPath(localPath string) string {
+	dir, name := filepath.Split(localPath)
+	return filepath.Join(dir, "."+name+".sftpc-"+client.params.ClientID()+".tmp")
+}
+
+// readManifest downloads and parses the manifest, dropping repeated entries.
+func (client *SFTPClient) readManifest(ctx context.Context, manifestRemotePath string, parse func(io.Reader) ([]string, error), checksums bool) ([]manifestEntry, error) {
+	f, err := client.openRemote(ctx, manifestRemotePath, os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	lines, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %q: %w", manifestRemotePath, err)
+	}
+
+	var entries []manifestEntry
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		entry, err := parseManifestEntry(line, checksums)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[entry.rel] {
+			seen[entry.rel] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// checkLocalSHA256 compares the SHA-256 digest of the local file with want.
+func checkLocalSHA256(localPath, want string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file for verification: %w", quotePath(err))
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("failed to read local file for verification: %w", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("%w: %q has sha256 %s, manifest says %s", ErrChecksumMismatch, localPath, got, want)
+	}
+	return nil
+}
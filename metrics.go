@@ -0,0 +1,58 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Directions of the bytes reported to MetricsCollector.AddBytes.
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// MetricsCollector receives the metrics of a client, for adapters to
+// Prometheus and the like. It is called from the goroutines running the
+// operations and must be safe for concurrent use.
+type MetricsCollector interface {
+	// ObserveOperation is called when the public method op returns, with
+	// how long it took and the error it returned, see ClassifyError.
+	ObserveOperation(op string, d time.Duration, err error)
+	// AddBytes is called as file data is transferred in direction.
+	AddBytes(direction string, n int64)
+	// IncReconnect is called for every reconnect attempt.
+	IncReconnect()
+}
+
+// WithMetrics reports operations, transferred bytes and reconnects to m.
+func WithMetrics(m MetricsCollector) Options {
+	return func(params *SFTPClientParams) error {
+		if m == nil {
+			return fmt.Errorf("metrics collector must not be nil")
+		}
+		params.metrics = m
+		params.record("WithMetrics", fmt.Sprintf("%p", m))
+		return nil
+	}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveOperation(string, time.Duration, error) {}
+func (nopMetrics) AddBytes(string, int64)                        {}
+func (nopMetrics) IncReconnect()                                 {}
+
+// addBytes reports n bytes of file data transferred in direction by the
+// operation of ctx.
+func (client *SFTPClient) addBytes(ctx context.Context, direction string, n int64) {
+	if n <= 0 {
+		return
+	}
+	client.params.Metrics().AddBytes(direction, n)
+	if op, ok := ctx.Value(operationKey{}).(*operation); ok {
+		op.mu.Lock()
+		op.attrs.Bytes += n
+		op.mu.Unlock()
+	}
+}
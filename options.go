@@ -1,40 +1,212 @@
 package sftpc
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
 )
 
+// DefaultPort is the SSH port used unless WithPort says otherwise.
+const DefaultPort = "22"
+
+// DefaultTCPKeepAlive is the TCP keepalive period used unless
+// WithTCPKeepAlive says otherwise.
+const DefaultTCPKeepAlive = 30 * time.Second
+
+// DefaultDialTimeout bounds connecting, including the SSH handshake, unless
+// WithDialTimeout says otherwise.
+const DefaultDialTimeout = 30 * time.Second
+
 type Options func(*SFTPClientParams) error
 
 type SFTPClientParams struct {
 	host           string
+	hosts          []string
 	port           string
 	user           string
 	password       string
+	passphrase     string
 	privateKeyPath string
 	privateKeyB64  []byte
+	rekeyThreshold uint64
+	maxOpenHandles int
+	clientID       string
+	redial         func(ctx context.Context) (net.Conn, error)
+	dialer         func(network, addr string) (net.Conn, error)
+	sshReconnect   func(ctx context.Context) (*ssh.Client, error)
+	ownConnection  bool
+	unixSocket     string
+	additionalKeys [][]byte
+	signers        []ssh.Signer
+	channels       int
+	tcpKeepAlive   time.Duration
+	dialTimeout    time.Duration
+	dialTimeoutSet bool
+	tcpDelay       bool
+	socks5Addr     string
+	socks5Auth     *proxy.Auth
+
+	keyboardInteractive ssh.KeyboardInteractiveChallenge
+
+	credentialProvider   func(ctx context.Context) (CredentialSet, error)
+	noCredentialFallback bool
+
+	bandwidthLimit int64
+	labelWeights   map[string]int
+	labelMaxFiles  map[string]int
+
+	hostKeyCallback   ssh.HostKeyCallback
+	strict            bool
+	allowPasswordAuth bool
+
+	quotaPatterns []string
+
+	retryPolicy      *RetryPolicy
+	connectionHooks  ConnectionHooks
+	maxOperationTime time.Duration
+
+	resumeVerification   int64
+	skipPermissionErrors bool
+	progressWriter       io.Writer
+	logger               Logger
+	metrics              MetricsCollector
+	tracer               Tracer
+
+	appendStrategy AppendStrategy
+	symlinkPolicy  SymlinkPolicy
+	noPosixRename  bool
+	timePrecision  time.Duration
+	writeOnly      bool
+
+	// applied maps the options applied so far to their values, for the
+	// conflict checks. repeated are the options applied more than once,
+	// logged once all options are applied and so the logger is known.
+	applied  map[string]string
+	repeated []optionRepeat
+}
+
+// optionRepeat is an option applied again, overriding its earlier value or
+// repeating it.
+type optionRepeat struct {
+	name       string
+	overridden bool
 }
 
 func newsSFTPClientParams(opts ...Options) (*SFTPClientParams, error) {
-	params := &SFTPClientParams{}
+	return applyOptions(&SFTPClientParams{}, opts...)
+}
+
+// applyOptions applies opts over params and checks the result.
+func applyOptions(params *SFTPClientParams, opts ...Options) (*SFTPClientParams, error) {
 	for _, opt := range opts {
 		if err := opt(params); err != nil {
 			return nil, err
 		}
 	}
+	for _, repeat := range params.repeated {
+		if repeat.overridden {
+			params.Logger().Debugf("Option %s applied more than once, the last value wins", repeat.name)
+		} else {
+			params.Logger().Warnf("Option %s applied more than once with the same value", repeat.name)
+		}
+	}
+	params.repeated = nil
+	if err := params.checkPort(); err != nil {
+		return nil, err
+	}
+	if err := params.checkConflicts(); err != nil {
+		return nil, err
+	}
+	if err := params.checkSecurity(); err != nil {
+		return nil, err
+	}
 	return params, nil
 }
 
+// WithHost sets the server host name or address. IPv6 literals may be given
+// with or without brackets, and surrounding whitespace is ignored. A
+// "unix:///path/to.sock" URL connects to a unix socket instead, like
+// WithUnixSocket.
 func WithHost(host string) Options {
 	return func(params *SFTPClientParams) error {
-		params.host = host
+		host = strings.TrimSpace(host)
+		if strings.HasPrefix(host, "unix://") {
+			u, err := url.Parse(host)
+			if err != nil || u.Path == "" {
+				return fmt.Errorf("invalid unix socket URL %q", host)
+			}
+			params.unixSocket = u.Path
+			params.host = ""
+			params.record("WithHost", host)
+			return nil
+		}
+		params.host = unbracketHost(host)
+		params.record("WithHost", host)
 		return nil
 	}
 }
 
+// WithHosts sets several hosts serving the same endpoint, such as a primary
+// and a disaster recovery server, tried in order until one connects and
+// authenticates. Reconnects start from the host that last worked and
+// rotate through the others.
+func WithHosts(hosts ...string) Options {
+	return func(params *SFTPClientParams) error {
+		clean := make([]string, len(hosts))
+		for i, host := range hosts {
+			clean[i] = unbracketHost(strings.TrimSpace(host))
+			if clean[i] == "" || strings.HasPrefix(clean[i], "unix://") {
+				return fmt.Errorf("invalid host %q", host)
+			}
+		}
+		if len(clean) == 0 {
+			return fmt.Errorf("no hosts given")
+		}
+		params.host = clean[0]
+		params.hosts = clean
+		params.record("WithHosts", strings.Join(clean, ","))
+		return nil
+	}
+}
+
+// unbracketHost strips the brackets of an IPv6 literal, which
+// net.JoinHostPort adds back.
+func unbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// WithPort sets the server port, DefaultPort unless set.
 func WithPort(port string) Options {
 	return func(params *SFTPClientParams) error {
 		params.port = port
+		params.record("WithPort", port)
+		return nil
+	}
+}
+
+// WithPortInt is WithPort for a numeric port.
+func WithPortInt(port int) Options {
+	return func(params *SFTPClientParams) error {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+		params.port = strconv.Itoa(port)
+		params.record("WithPort", strconv.Itoa(port))
 		return nil
 	}
 }
@@ -42,6 +214,7 @@ func WithPort(port string) Options {
 func WithUser(user string) Options {
 	return func(params *SFTPClientParams) error {
 		params.user = user
+		params.record("WithUser", user)
 		return nil
 	}
 }
@@ -49,6 +222,48 @@ func WithUser(user string) Options {
 func WithPassword(password string) Options {
 	return func(params *SFTPClientParams) error {
 		params.password = password
+		params.record("WithPassword", secretValue(password))
+		return nil
+	}
+}
+
+// WithPrivateKeyPassphrase decrypts the private keys with passphrase. When
+// it is not set the password doubles as the passphrase, as before; when it
+// is, the password is only used for password authentication.
+func WithPrivateKeyPassphrase(passphrase string) Options {
+	return func(params *SFTPClientParams) error {
+		params.passphrase = passphrase
+		params.record("WithPrivateKeyPassphrase", secretValue(passphrase))
+		return nil
+	}
+}
+
+// WithKeyboardInteractive answers keyboard-interactive challenges, such as
+// one time codes, with cb. It is tried after password and public key
+// authentication, on the initial dial and on every reconnect.
+func WithKeyboardInteractive(cb ssh.KeyboardInteractiveChallenge) Options {
+	return func(params *SFTPClientParams) error {
+		if cb == nil {
+			return fmt.Errorf("keyboard-interactive challenge must not be nil")
+		}
+		params.keyboardInteractive = cb
+		params.record("WithKeyboardInteractive", fmt.Sprintf("%p", cb))
+		return nil
+	}
+}
+
+// WithKeyboardInteractivePassword answers every keyboard-interactive prompt
+// with password, for servers that only ask for a password that way.
+func WithKeyboardInteractivePassword(password string) Options {
+	return func(params *SFTPClientParams) error {
+		params.keyboardInteractive = func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = password
+			}
+			return answers, nil
+		}
+		params.record("WithKeyboardInteractivePassword", secretValue(password))
 		return nil
 	}
 }
@@ -56,6 +271,7 @@ func WithPassword(password string) Options {
 func WithPrivateKeyPath(privateKeyPath string) Options {
 	return func(params *SFTPClientParams) error {
 		params.privateKeyPath = privateKeyPath
+		params.record("WithPrivateKeyPath", privateKeyPath)
 		return nil
 	}
 }
@@ -67,6 +283,228 @@ func WithPrivateKeyB64(privateKeyB64 string) Options {
 			return err
 		}
 		params.privateKeyB64 = bytesPrivateKey
+		params.record("WithPrivateKeyB64", secretValue(privateKeyB64))
+		return nil
+	}
+}
+
+// WithPrivateKeyBytes sets the private key from raw PEM bytes, as held by a
+// secrets manager, without base64 encoding them or writing them to a file.
+func WithPrivateKeyBytes(key []byte) Options {
+	return func(params *SFTPClientParams) error {
+		if block, _ := pem.Decode(key); block == nil {
+			return fmt.Errorf("private key does not contain a PEM block")
+		}
+		params.privateKeyB64 = bytes.Clone(key)
+		params.record("WithPrivateKeyBytes", secretValue(key))
+		return nil
+	}
+}
+
+// WithRekeyThreshold sets the number of bytes after which the SSH transport
+// renegotiates its keys, for servers that misbehave with the default.
+func WithRekeyThreshold(bytes uint64) Options {
+	return func(params *SFTPClientParams) error {
+		params.rekeyThreshold = bytes
+		params.record("WithRekeyThreshold", fmt.Sprint(bytes))
+		return nil
+	}
+}
+
+// WithMaxOpenHandles bounds the number of remote file handles the client
+// keeps open at once across all operations. Further opens wait for a handle
+// to be closed. Zero means no limit.
+func WithMaxOpenHandles(n int) Options {
+	return func(params *SFTPClientParams) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max open handles: %d", n)
+		}
+		params.maxOpenHandles = n
+		params.record("WithMaxOpenHandles", fmt.Sprint(n))
+		return nil
+	}
+}
+
+// WithClientID sets the identifier embedded in the names of temporary files
+// created by atomic uploads, so that CleanupTempFiles never touches temporary
+// files of other clients or tools, and naming the directory ClaimFile claims
+// into. It defaults to the local host name.
+func WithClientID(id string) Options {
+	return func(params *SFTPClientParams) error {
+		if !clientIDPattern.MatchString(id) {
+			return fmt.Errorf("invalid client id %q: only letters, digits, '.', '_' and '-' are allowed", id)
+		}
+		params.clientID = id
+		params.record("WithClientID", id)
+		return nil
+	}
+}
+
+// WithUnixSocket connects to the server through the unix socket at path.
+// No host or port is needed in this mode.
+func WithUnixSocket(path string) Options {
+	return func(params *SFTPClientParams) error {
+		if path == "" {
+			return fmt.Errorf("unix socket path must not be empty")
+		}
+		params.unixSocket = path
+		params.record("WithUnixSocket", path)
+		return nil
+	}
+}
+
+// WithDialer replaces the TCP dial of the initial connect and of every
+// reconnect with d, which is passed the network and the host:port address,
+// for tunnels, TLS-wrapped transports and in-process tests. The SSH
+// handshake then runs on the connection d returns. WithTCPKeepAlive and
+// WithTCPNoDelay do not apply to it, and the dial timeout only covers the
+// handshake.
+func WithDialer(d func(network, addr string) (net.Conn, error)) Options {
+	return func(params *SFTPClientParams) error {
+		if d == nil {
+			return fmt.Errorf("dialer must not be nil")
+		}
+		params.dialer = d
+		params.record("WithDialer", fmt.Sprintf("%p", d))
+		return nil
+	}
+}
+
+// WithRedialFunc sets how a fresh transport connection is obtained when the
+// client reconnects, for connections that are not plain TCP dials such as
+// tunnels handed to NewSFTPClientFromConn. WithTCPKeepAlive and
+// WithTCPNoDelay do not apply to connections it returns.
+func WithRedialFunc(redial func(ctx context.Context) (net.Conn, error)) Options {
+	return func(params *SFTPClientParams) error {
+		params.redial = redial
+		params.record("WithRedialFunc", fmt.Sprintf("%p", redial))
+		return nil
+	}
+}
+
+// WithOwnConnection makes a client created with NewSFTPClientFromSSH close
+// the ssh.Client it was given, and those returned by WithSSHReconnectFunc,
+// instead of leaving them to the caller.
+func WithOwnConnection() Options {
+	return func(params *SFTPClientParams) error {
+		params.ownConnection = true
+		params.record("WithOwnConnection", "true")
+		return nil
+	}
+}
+
+// WithSSHReconnectFunc sets how a client created with NewSFTPClientFromSSH
+// obtains a fresh ssh.Client when it reconnects. Without it such a client
+// cannot reconnect.
+func WithSSHReconnectFunc(reconnect func(ctx context.Context) (*ssh.Client, error)) Options {
+	return func(params *SFTPClientParams) error {
+		if reconnect == nil {
+			return fmt.Errorf("SSH reconnect function must not be nil")
+		}
+		params.sshReconnect = reconnect
+		params.record("WithSSHReconnectFunc", fmt.Sprintf("%p", reconnect))
+		return nil
+	}
+}
+
+// WithAdditionalKey offers one more private key (PEM encoded) during
+// authentication, after the one set with WithPrivateKeyPath or
+// WithPrivateKeyB64. It may be repeated and is the way to use both of them
+// at once.
+func WithAdditionalKey(privateKey []byte) Options {
+	return func(params *SFTPClientParams) error {
+		if len(privateKey) == 0 {
+			return fmt.Errorf("additional key must not be empty")
+		}
+		params.additionalKeys = append(params.additionalKeys, privateKey)
+		return nil
+	}
+}
+
+// WithSigner offers signer during public key authentication, after the
+// keys given as PEM. It can be repeated, for keys held in an agent, HSM or
+// KMS, and the same signers are used again on every reconnect.
+func WithSigner(signer ssh.Signer) Options {
+	return func(params *SFTPClientParams) error {
+		if signer == nil {
+			return fmt.Errorf("signer must not be nil")
+		}
+		params.signers = append(params.signers, signer)
+		return nil
+	}
+}
+
+// WithChannelsPerConnection opens n SFTP channels over the single SSH
+// connection and spreads file handles over them, least busy first, for
+// servers that allow only one TCP connection per client.
+func WithChannelsPerConnection(n int) Options {
+	return func(params *SFTPClientParams) error {
+		if n < 1 {
+			return fmt.Errorf("invalid channels per connection: %d", n)
+		}
+		params.channels = n
+		params.record("WithChannelsPerConnection", fmt.Sprint(n))
+		return nil
+	}
+}
+
+// WithTCPKeepAlive sets the period of the TCP keepalive probes sent on an
+// idle connection, which keep NAT gateways from dropping long transfers. A
+// negative period disables them. Like WithTCPNoDelay, it only applies to the
+// default dialer, not to WithRedialFunc, unix sockets or
+// NewSFTPClientFromConn.
+func WithTCPKeepAlive(period time.Duration) Options {
+	return func(params *SFTPClientParams) error {
+		params.tcpKeepAlive = period
+		params.record("WithTCPKeepAlive", period.String())
+		return nil
+	}
+}
+
+// WithDialTimeout bounds connecting, including the SSH handshake, on the
+// initial dial and on every reconnect. Zero means no timeout.
+func WithDialTimeout(d time.Duration) Options {
+	return func(params *SFTPClientParams) error {
+		if d < 0 {
+			return fmt.Errorf("invalid dial timeout: %s", d)
+		}
+		params.dialTimeout = d
+		params.dialTimeoutSet = true
+		params.record("WithDialTimeout", d.String())
+		return nil
+	}
+}
+
+// WithTCPNoDelay turns Nagle's algorithm off (true, the default) or on for
+// the TCP connection.
+func WithTCPNoDelay(noDelay bool) Options {
+	return func(params *SFTPClientParams) error {
+		params.tcpDelay = !noDelay
+		params.record("WithTCPNoDelay", fmt.Sprint(noDelay))
+		return nil
+	}
+}
+
+// WithHostKeyCallback verifies the server host key with cb, on the first
+// connection and on every reconnect.
+func WithHostKeyCallback(cb ssh.HostKeyCallback) Options {
+	return func(params *SFTPClientParams) error {
+		if cb == nil {
+			return fmt.Errorf("host key callback must not be nil")
+		}
+		params.hostKeyCallback = cb
+		params.record("WithHostKeyCallback", fmt.Sprintf("%p", cb))
+		return nil
+	}
+}
+
+// WithInsecureHostKey accepts any server host key, which is also what
+// happens when no host key option is set. Use it to make that choice
+// visible in code; strict mode refuses it.
+func WithInsecureHostKey() Options {
+	return func(params *SFTPClientParams) error {
+		params.hostKeyCallback = ssh.InsecureIgnoreHostKey()
+		params.record("WithInsecureHostKey", "true")
 		return nil
 	}
 }
@@ -77,7 +515,19 @@ func (p *SFTPClientParams) Host() string {
 	return p.host
 }
 
+// Hosts returns the hosts tried in order, the one of WithHost unless
+// WithHosts.
+func (p *SFTPClientParams) Hosts() []string {
+	if len(p.hosts) == 0 && p.host != "" {
+		return []string{p.host}
+	}
+	return p.hosts
+}
+
 func (p *SFTPClientParams) Port() string {
+	if p.port == "" {
+		return DefaultPort
+	}
 	return p.port
 }
 
@@ -97,10 +547,214 @@ func (p *SFTPClientParams) PrivateKeyB64() []byte {
 	return p.privateKeyB64
 }
 
+func (p *SFTPClientParams) RekeyThreshold() uint64 {
+	return p.rekeyThreshold
+}
+
+func (p *SFTPClientParams) MaxOpenHandles() int {
+	return p.maxOpenHandles
+}
+
+func (p *SFTPClientParams) ClientID() string {
+	if p.clientID == "" {
+		return defaultClientID()
+	}
+	return p.clientID
+}
+
+func (p *SFTPClientParams) RedialFunc() func(ctx context.Context) (net.Conn, error) {
+	return p.redial
+}
+
+func (p *SFTPClientParams) Dialer() func(network, addr string) (net.Conn, error) {
+	return p.dialer
+}
+
+func (p *SFTPClientParams) SSHReconnectFunc() func(ctx context.Context) (*ssh.Client, error) {
+	return p.sshReconnect
+}
+
+func (p *SFTPClientParams) OwnConnection() bool {
+	return p.ownConnection
+}
+
+func (p *SFTPClientParams) UnixSocket() string {
+	return p.unixSocket
+}
+
+func (p *SFTPClientParams) AdditionalKeys() [][]byte {
+	return p.additionalKeys
+}
+
+func (p *SFTPClientParams) ChannelsPerConnection() int {
+	if p.channels == 0 {
+		return 1
+	}
+	return p.channels
+}
+
+func (p *SFTPClientParams) TCPKeepAlive() time.Duration {
+	if p.tcpKeepAlive == 0 {
+		return DefaultTCPKeepAlive
+	}
+	return p.tcpKeepAlive
+}
+
+func (p *SFTPClientParams) DialTimeout() time.Duration {
+	if !p.dialTimeoutSet {
+		return DefaultDialTimeout
+	}
+	return p.dialTimeout
+}
+
+func (p *SFTPClientParams) TCPNoDelay() bool {
+	return !p.tcpDelay
+}
+
+// HostKeyCallback returns the callback verifying the server host key on
+// every dial and reconnect. It accepts any host key unless a host key
+// option was set.
+func (p *SFTPClientParams) HostKeyCallback() ssh.HostKeyCallback {
+	if p.hostKeyCallback == nil {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return p.hostKeyCallback
+}
+
+func (p *SFTPClientParams) StrictSecurity() bool {
+	return p.strict || defaultStrictSecurity
+}
+
+func (p *SFTPClientParams) AllowPasswordAuth() bool {
+	return p.allowPasswordAuth
+}
+
+func (p *SFTPClientParams) QuotaPatterns() []string {
+	if p.quotaPatterns == nil {
+		return DefaultQuotaPatterns
+	}
+	return p.quotaPatterns
+}
+
+func (p *SFTPClientParams) RetryPolicy() RetryPolicy {
+	if p.retryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *p.retryPolicy
+}
+
+func (p *SFTPClientParams) ConnectionHooks() ConnectionHooks {
+	return p.connectionHooks
+}
+
+func (p *SFTPClientParams) MaxOperationTime() time.Duration {
+	return p.maxOperationTime
+}
+
+func (p *SFTPClientParams) ResumeVerification() int64 {
+	return p.resumeVerification
+}
+
+func (p *SFTPClientParams) SkipPermissionErrors() bool {
+	return p.skipPermissionErrors
+}
+
+func (p *SFTPClientParams) Metrics() MetricsCollector {
+	if p.metrics == nil {
+		return nopMetrics{}
+	}
+	return p.metrics
+}
+
+func (p *SFTPClientParams) Tracer() Tracer {
+	if p.tracer == nil {
+		return nopTracer{}
+	}
+	return p.tracer
+}
+
+func (p *SFTPClientParams) Logger() Logger {
+	if p.logger == nil {
+		return nopLogger{}
+	}
+	return p.logger
+}
+
+func (p *SFTPClientParams) ProgressWriter() io.Writer {
+	if p.progressWriter == nil {
+		return os.Stdout
+	}
+	return p.progressWriter
+}
+
+func (p *SFTPClientParams) AppendStrategy() AppendStrategy {
+	return p.appendStrategy
+}
+
+func (p *SFTPClientParams) SymlinkPolicy() SymlinkPolicy {
+	return p.symlinkPolicy
+}
+
+func (p *SFTPClientParams) NoPosixRename() bool {
+	return p.noPosixRename
+}
+
+func (p *SFTPClientParams) TimePrecision() time.Duration {
+	if p.timePrecision == 0 {
+		return time.Second
+	}
+	return p.timePrecision
+}
+
+func (p *SFTPClientParams) WriteOnly() bool {
+	return p.writeOnly
+}
+
+func (p *SFTPClientParams) SOCKS5Proxy() string {
+	return p.socks5Addr
+}
+
+func (p *SFTPClientParams) SOCKS5Auth() *proxy.Auth {
+	return p.socks5Auth
+}
+
+func (p *SFTPClientParams) KeyboardInteractive() ssh.KeyboardInteractiveChallenge {
+	return p.keyboardInteractive
+}
+
+func (p *SFTPClientParams) CredentialProvider() func(ctx context.Context) (CredentialSet, error) {
+	return p.credentialProvider
+}
+
+func (p *SFTPClientParams) NoCredentialFallback() bool {
+	return p.noCredentialFallback
+}
+
+func (p *SFTPClientParams) BandwidthLimit() int64 {
+	return p.bandwidthLimit
+}
+
+func (p *SFTPClientParams) LabelWeights() map[string]int {
+	return p.labelWeights
+}
+
+func (p *SFTPClientParams) LabelMaxConcurrentFiles() map[string]int {
+	return p.labelMaxFiles
+}
+
+func (p *SFTPClientParams) PrivateKeyPassphrase() string {
+	return p.passphrase
+}
+
+func (p *SFTPClientParams) Signers() []ssh.Signer {
+	return p.signers
+}
+
 // setters ----
 
 func (p *SFTPClientParams) SetHost(host string) {
-	p.host = host
+	p.host = unbracketHost(strings.TrimSpace(host))
+	p.hosts = nil
 }
 
 func (p *SFTPClientParams) SetPort(port string) {
@@ -122,3 +776,163 @@ func (p *SFTPClientParams) SetPrivateKeyPath(privateKeyPath string) {
 func (p *SFTPClientParams) SetPrivateKeyB64(privateKeyB64 []byte) {
 	p.privateKeyB64 = privateKeyB64
 }
+
+func (p *SFTPClientParams) SetRekeyThreshold(rekeyThreshold uint64) {
+	p.rekeyThreshold = rekeyThreshold
+}
+
+func (p *SFTPClientParams) SetMaxOpenHandles(maxOpenHandles int) {
+	p.maxOpenHandles = maxOpenHandles
+}
+
+func (p *SFTPClientParams) SetClientID(clientID string) {
+	p.clientID = clientID
+}
+
+func (p *SFTPClientParams) SetRedialFunc(redial func(ctx context.Context) (net.Conn, error)) {
+	p.redial = redial
+}
+
+func (p *SFTPClientParams) SetUnixSocket(unixSocket string) {
+	p.unixSocket = unixSocket
+}
+
+func (p *SFTPClientParams) SetAdditionalKeys(additionalKeys [][]byte) {
+	p.additionalKeys = additionalKeys
+}
+
+func (p *SFTPClientParams) SetChannelsPerConnection(channels int) {
+	p.channels = channels
+}
+
+func (p *SFTPClientParams) SetTCPKeepAlive(tcpKeepAlive time.Duration) {
+	p.tcpKeepAlive = tcpKeepAlive
+}
+
+func (p *SFTPClientParams) SetTCPNoDelay(tcpNoDelay bool) {
+	p.tcpDelay = !tcpNoDelay
+}
+
+func (p *SFTPClientParams) SetHostKeyCallback(hostKeyCallback ssh.HostKeyCallback) {
+	p.hostKeyCallback = hostKeyCallback
+}
+
+func (p *SFTPClientParams) SetStrictSecurity(strict bool) {
+	p.strict = strict
+}
+
+func (p *SFTPClientParams) SetAllowPasswordAuth(allowPasswordAuth bool) {
+	p.allowPasswordAuth = allowPasswordAuth
+}
+
+func (p *SFTPClientParams) SetAppendStrategy(appendStrategy AppendStrategy) {
+	p.appendStrategy = appendStrategy
+}
+
+func (p *SFTPClientParams) SetSymlinkPolicy(symlinkPolicy SymlinkPolicy) {
+	p.symlinkPolicy = symlinkPolicy
+}
+
+func (p *SFTPClientParams) SetKeyboardInteractive(keyboardInteractive ssh.KeyboardInteractiveChallenge) {
+	p.keyboardInteractive = keyboardInteractive
+}
+
+func (p *SFTPClientParams) SetBandwidthLimit(bandwidthLimit int64) {
+	p.bandwidthLimit = bandwidthLimit
+}
+
+func (p *SFTPClientParams) SetLabelWeights(labelWeights map[string]int) {
+	p.labelWeights = labelWeights
+}
+
+func (p *SFTPClientParams) SetLabelMaxConcurrentFiles(labelMaxFiles map[string]int) {
+	p.labelMaxFiles = labelMaxFiles
+}
+
+func (p *SFTPClientParams) SetPrivateKeyPassphrase(passphrase string) {
+	p.passphrase = passphrase
+}
+
+func (p *SFTPClientParams) SetSigners(signers []ssh.Signer) {
+	p.signers = signers
+}
+
+func (p *SFTPClientParams) SetDialTimeout(dialTimeout time.Duration) {
+	p.dialTimeout = dialTimeout
+	p.dialTimeoutSet = true
+}
+
+func (p *SFTPClientParams) SetNoPosixRename(noPosixRename bool) {
+	p.noPosixRename = noPosixRename
+}
+
+func (p *SFTPClientParams) SetTimePrecision(timePrecision time.Duration) {
+	p.timePrecision = timePrecision
+}
+
+func (p *SFTPClientParams) SetWriteOnly(writeOnly bool) {
+	p.writeOnly = writeOnly
+}
+
+func (p *SFTPClientParams) SetCredentialProvider(provider func(ctx context.Context) (CredentialSet, error)) {
+	p.credentialProvider = provider
+}
+
+func (p *SFTPClientParams) SetQuotaPatterns(patterns []string) {
+	p.quotaPatterns = patterns
+}
+
+func (p *SFTPClientParams) SetDialer(d func(network, addr string) (net.Conn, error)) {
+	p.dialer = d
+}
+
+func (p *SFTPClientParams) SetSSHReconnectFunc(reconnect func(ctx context.Context) (*ssh.Client, error)) {
+	p.sshReconnect = reconnect
+}
+
+func (p *SFTPClientParams) SetOwnConnection(ownConnection bool) {
+	p.ownConnection = ownConnection
+}
+
+func (p *SFTPClientParams) SetHosts(hosts []string) {
+	p.hosts = hosts
+	if len(hosts) > 0 {
+		p.host = hosts[0]
+	}
+}
+
+func (p *SFTPClientParams) SetRetryPolicy(policy RetryPolicy) {
+	p.retryPolicy = &policy
+}
+
+func (p *SFTPClientParams) SetConnectionHooks(hooks ConnectionHooks) {
+	p.connectionHooks = hooks
+}
+
+func (p *SFTPClientParams) SetMaxOperationTime(d time.Duration) {
+	p.maxOperationTime = d
+}
+
+func (p *SFTPClientParams) SetResumeVerification(window int64) {
+	p.resumeVerification = window
+}
+
+func (p *SFTPClientParams) SetSkipPermissionErrors(skip bool) {
+	p.skipPermissionErrors = skip
+}
+
+func (p *SFTPClientParams) SetProgressWriter(w io.Writer) {
+	p.progressWriter = w
+}
+
+func (p *SFTPClientParams) SetLogger(l Logger) {
+	p.logger = l
+}
+
+func (p *SFTPClientParams) SetMetrics(m MetricsCollector) {
+	p.metrics = m
+}
+
+func (p *SFTPClientParams) SetTracer(t Tracer) {
+	p.tracer = t
+}
@@ -0,0 +1,170 @@
+package sftpc
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// PathMapper translates relative file paths between the local and the
+// remote layout of a directory transfer. Paths are slash separated and
+// relative to the transferred directories.
+type PathMapper interface {
+	ToRemote(localRel string) (string, error)
+	ToLocal(remoteRel string) (string, error)
+}
+
+// WithPathMapper places every selected file at the path m gives it on the
+// destination side of UploadDir, DownloadDir and DiffLocalRemote. Mapping
+// happens after filtering; two files mapped to the same path fail the
+// operation before anything is transferred. Only the parents of mapped
+// files are created, empty source directories have no counterpart.
+func WithPathMapper(m PathMapper) TransferOption {
+	return func(params *transferParams) error {
+		if m == nil {
+			return fmt.Errorf("path mapper must not be nil")
+		}
+		params.mapper = m
+		return nil
+	}
+}
+
+// PathFields are the fields available to the templates of a
+// TemplateMapper, for the path being mapped.
+type PathFields struct {
+	// Path is the whole relative path, Dir its directory ("." at the top).
+	Path string
+	Dir  string
+	// Base is the file name, Name the same without Ext, its extension.
+	Base string
+	Name string
+	Ext  string
+	// Parts are the slash separated elements of Path.
+	Parts []string
+}
+
+func newPathFields(rel string) PathFields {
+	base := path.Base(rel)
+	ext := path.Ext(base)
+	return PathFields{
+		Path:  rel,
+		Dir:   path.Dir(rel),
+		Base:  base,
+		Name:  strings.TrimSuffix(base, ext),
+		Ext:   ext,
+		Parts: strings.Split(rel, "/"),
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"split":      strings.Split,
+	"splitN":     strings.SplitN,
+	"join":       strings.Join,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+}
+
+// TemplateMapper maps paths with text/template templates executed on
+// PathFields, with split, splitN, join, trimPrefix, trimSuffix, lower and
+// upper from the strings package as functions.
+type TemplateMapper struct {
+	toRemote *template.Template
+	toLocal  *template.Template
+}
+
+// NewTemplateMapper parses the templates of both directions. An empty
+// toLocal leaves the mapper usable for uploads only.
+//
+// For example "{{index .Parts 2}}-{{index .Parts 1}}/{{index .Parts 0}}_{{.Base}}"
+// maps "acme/2024/05/file.csv" to "05-2024/acme_file.csv".
+func NewTemplateMapper(toRemote, toLocal string) (*TemplateMapper, error) {
+	m := &TemplateMapper{}
+	var err error
+	m.toRemote, err = template.New("toRemote").Funcs(templateFuncs).Option("missingkey=error").Parse(toRemote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote path template: %w", err)
+	}
+	if toLocal != "" {
+		m.toLocal, err = template.New("toLocal").Funcs(templateFuncs).Option("missingkey=error").Parse(toLocal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse local path template: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *TemplateMapper) ToRemote(localRel string) (string, error) {
+	return executePathTemplate(m.toRemote, localRel)
+}
+
+func (m *TemplateMapper) ToLocal(remoteRel string) (string, error) {
+	if m.toLocal == nil {
+		return "", fmt.Errorf("no local path template to map %q", remoteRel)
+	}
+	return executePathTemplate(m.toLocal, remoteRel)
+}
+
+func executePathTemplate(t *template.Template, rel string) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, newPathFields(rel))
+	if err != nil {
+		return "", fmt.Errorf("failed to map %q: %w", rel, err)
+	}
+	return b.String(), nil
+}
+
+// mapTargets sets the destination path of every file of the plan.
+func (plan *treePlan) mapTargets(m PathMapper, upload bool) error {
+	plan.mapped = m != nil
+	sources := make(map[string]string, len(plan.files))
+	for i := range plan.files {
+		file := &plan.files[i]
+		file.target = file.rel
+		if m == nil {
+			continue
+		}
+
+		var err error
+		if upload {
+			file.target, err = m.ToRemote(file.rel)
+		} else {
+			file.target, err = m.ToLocal(file.rel)
+		}
+		if err != nil {
+			return err
+		}
+		clean := path.Clean(file.target)
+		if file.target == "" || clean != file.target || clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("path mapper maps %q to %q, which is not a clean relative path", file.rel, file.target)
+		}
+		if other, ok := sources[file.target]; ok {
+			return fmt.Errorf("path mapper maps both %q and %q to %q", other, file.rel, file.target)
+		}
+		sources[file.target] = file.rel
+	}
+	return nil
+}
+
+// targetDirs returns the destination directories of the mapped files,
+// parents first.
+func (plan *treePlan) targetDirs() []treeEntry {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range plan.files {
+		for dir := path.Dir(file.target); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	entries := make([]treeEntry, 0, len(dirs))
+	for _, dir := range dirs {
+		entries = append(entries, treeEntry{rel: dir, target: dir})
+	}
+	return entries
+}
@@ -0,0 +1,160 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// PipeLeg names the stage of a PipeBetween pipeline that failed.
+type PipeLeg string
+
+const (
+	PipeLegRead      PipeLeg = "read"
+	PipeLegTransform PipeLeg = "transform"
+	PipeLegWrite     PipeLeg = "write"
+)
+
+// PipeError is returned by PipeBetween and identifies the failing leg.
+type PipeError struct {
+	Leg  PipeLeg
+	Path string
+	Err  error
+}
+
+func (e *PipeError) Error() string {
+	return fmt.Sprintf("pipe %s failed for %q: %v", e.Leg, e.Path, e.Err)
+}
+
+func (e *PipeError) Unwrap() error {
+	return e.Err
+}
+
+// PipeBetween streams srcPath on src through transform into dstPath on dst
+// without staging the data on local disk. A nil transform copies the bytes
+// unchanged. Progress is counted on the destination side. Resume is not
+// supported: an existing destination is only replaced with WithOverwrite.
+func PipeBetween(src *SFTPClient, srcPath string, transform func(io.Reader) io.Reader, dst *SFTPClient, dstPath string, opts ...TransferOption) (*TransferStats, error) {
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.refuseDryRun("PipeBetween"); err != nil {
+		return nil, err
+	}
+	if params.resume {
+		return nil, fmt.Errorf("%w: PipeBetween always streams the whole source", ErrResumeUnsupported)
+	}
+
+	err = src.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect source: %w", err)
+	}
+	err = dst.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect destination: %w", err)
+	}
+
+	start := time.Now()
+	stats := &TransferStats{RemotePath: dstPath, TotalSize: -1}
+
+	_, err = dst.sftpClient.Stat(dstPath)
+	if err == nil && !params.overwrite {
+		return nil, &PipeError{Leg: PipeLegWrite, Path: dstPath, Err: fmt.Errorf("%w: resume is not supported, use WithOverwrite", ErrDestinationExists)}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, &PipeError{Leg: PipeLegWrite, Path: dstPath, Err: fmt.Errorf("failed to get remote file info: %w", err)}
+	}
+
+	_, err = src.statSource(srcPath)
+	if err != nil {
+		return nil, &PipeError{Leg: PipeLegRead, Path: srcPath, Err: fmt.Errorf("failed to get remote file info: %w", err)}
+	}
+
+	srcFile, err := src.openRemote(params.context(), srcPath, os.O_RDONLY)
+	if err != nil {
+		return nil, &PipeError{Leg: PipeLegRead, Path: srcPath, Err: fmt.Errorf("failed to open remote file: %w", err)}
+	}
+	defer srcFile.Close()
+
+	dstFile, err := dst.openRemote(params.context(), dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, &PipeError{Leg: PipeLegWrite, Path: dstPath, Err: fmt.Errorf("failed to open or create remote file: %w", err)}
+	}
+	defer dstFile.Close()
+
+	source := &legReader{r: srcFile}
+	var transformed io.Reader = source
+	if transform != nil {
+		transformed = transform(source)
+	}
+
+	sink := &legWriter{w: dstFile, info: ProgressInfo{Phase: PhaseTransfer, Path: dstPath, Total: -1}, report: params.progress, rate: newRateMeter(0, time.Now())}
+	_, err = io.Copy(sink, transformed)
+	src.addBytes(context.Background(), DirectionDownload, source.n)
+	dst.addBytes(context.Background(), DirectionUpload, sink.info.Transferred)
+	stats.BytesTransferred = sink.info.Transferred
+	stats.TotalSize = sink.info.Transferred
+	stats.Duration = time.Since(start)
+	stats.addPhaseDuration(PhaseTransfer, stats.Duration)
+
+	switch {
+	case source.err != nil:
+		return stats, &PipeError{Leg: PipeLegRead, Path: srcPath, Err: source.err}
+	case sink.err != nil:
+		return stats, &PipeError{Leg: PipeLegWrite, Path: dstPath, Err: sink.err}
+	case err != nil:
+		return stats, &PipeError{Leg: PipeLegTransform, Path: srcPath, Err: err}
+	}
+
+	err = dstFile.Close()
+	if err != nil {
+		return stats, &PipeError{Leg: PipeLegWrite, Path: dstPath, Err: fmt.Errorf("failed to close remote file: %w", err)}
+	}
+
+	return stats, nil
+}
+
+// legReader remembers the first non-EOF error of the source stream so it can
+// be told apart from errors raised by the transform.
+type legReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *legReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if err != nil && err != io.EOF && l.err == nil {
+		l.err = err
+	}
+	return n, err
+}
+
+// legWriter remembers write errors and reports destination side progress.
+type legWriter struct {
+	w      io.Writer
+	err    error
+	info   ProgressInfo
+	report func(ProgressInfo)
+	rate   *rateMeter
+}
+
+func (l *legWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	l.info.Transferred += int64(n)
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+	if n > 0 && l.report != nil {
+		l.rate.update(&l.info, time.Now())
+		l.report(l.info)
+	}
+	return n, err
+}
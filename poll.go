@@ -0,0 +1,306 @@
+package sftpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default bounds of the interval PollCursor.Next waits between listings
+// that find nothing new.
+const (
+	DefaultPollMinInterval = time.Second
+	DefaultPollMaxInterval = time.Minute
+)
+
+// Watermark is the position of a PollCursor: the newest modification time
+// it delivered and the names delivered with exactly that time. Keeping every
+// name at the newest time, instead of only the last one, means a file that
+// shows up later with the same time is still picked up whatever its name.
+type Watermark struct {
+	ModTime time.Time
+	Names   []string
+}
+
+// after reports whether entry is past the watermark.
+func (w Watermark) after(entry RemoteEntry) bool {
+	if !entry.ModTime.Equal(w.ModTime) {
+		return entry.ModTime.After(w.ModTime)
+	}
+	return !slices.Contains(w.Names, entry.Name)
+}
+
+// advance returns the watermark moved past entries, which are sorted by
+// modification time.
+func (w Watermark) advance(entries []RemoteEntry) Watermark {
+	if len(entries) == 0 {
+		return w
+	}
+	newest := entries[len(entries)-1].ModTime
+	next := Watermark{ModTime: newest}
+	if newest.Equal(w.ModTime) {
+		next.Names = append(next.Names, w.Names...)
+	}
+	for _, entry := range entries {
+		if entry.ModTime.Equal(newest) {
+			next.Names = append(next.Names, entry.Name)
+		}
+	}
+	sort.Strings(next.Names)
+	return next
+}
+
+// ErrCursorStopped is returned by PollCursor.Next once the cursor was
+// stopped.
+var ErrCursorStopped = errors.New("poll cursor stopped")
+
+// CursorStore persists the watermark of a PollCursor. Load returns the zero
+// Watermark when nothing was saved yet.
+type CursorStore interface {
+	Load() (Watermark, error)
+	Save(Watermark) error
+}
+
+// MemoryCursorStore keeps the watermark in memory, for tests and consumers
+// that track their position elsewhere.
+type MemoryCursorStore struct {
+	mu   sync.Mutex
+	mark Watermark
+}
+
+// Load returns the saved watermark.
+func (s *MemoryCursorStore) Load() (Watermark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Watermark{ModTime: s.mark.ModTime, Names: slices.Clone(s.mark.Names)}, nil
+}
+
+// Save replaces the saved watermark.
+func (s *MemoryCursorStore) Save(mark Watermark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mark = Watermark{ModTime: mark.ModTime, Names: slices.Clone(mark.Names)}
+	return nil
+}
+
+// FileCursorStore keeps the watermark in a local JSON file, replaced
+// atomically on every save.
+type FileCursorStore struct {
+	path string
+}
+
+// NewFileCursorStore returns a store keeping the watermark in the file at
+// path.
+func NewFileCursorStore(path string) *FileCursorStore {
+	return &FileCursorStore{path: path}
+}
+
+type watermarkJSON struct {
+	ModTime string   `json:"modTime,omitempty"`
+	Names   []string `json:"names"`
+}
+
+// Load reads the watermark, the zero one when the file does not exist.
+func (s *FileCursorStore) Load() (Watermark, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Watermark{}, nil
+	}
+	if err != nil {
+		return Watermark{}, fmt.Errorf("failed to read cursor file: %w", err)
+	}
+	var doc watermarkJSON
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return Watermark{}, fmt.Errorf("failed to decode cursor file: %w", err)
+	}
+	modTime, err := parseTime(doc.ModTime)
+	if err != nil {
+		return Watermark{}, fmt.Errorf("failed to decode cursor file: %w", err)
+	}
+	return Watermark{ModTime: modTime, Names: doc.Names}, nil
+}
+
+// Save writes the watermark to a temporary file next to the cursor file and
+// renames it into place.
+func (s *FileCursorStore) Save(mark Watermark) error {
+	data, err := json.Marshal(watermarkJSON{ModTime: formatTime(mark.ModTime), Names: emptyIfNil(mark.Names)})
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cursor file: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write cursor file: %w", err)
+	}
+	return nil
+}
+
+// PollOption configures a PollCursor.
+type PollOption func(*PollCursor) error
+
+// WithPollInterval sets the bounds of the wait between listings that find
+// nothing new: it starts at min and doubles up to max.
+func WithPollInterval(min, max time.Duration) PollOption {
+	return func(cursor *PollCursor) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid poll interval: %s to %s", min, max)
+		}
+		cursor.minInterval = min
+		cursor.maxInterval = max
+		return nil
+	}
+}
+
+// PollCursor picks up the files of a remote directory exactly once, in
+// modification time order, by keeping a watermark in a CursorStore. Files
+// modified before the watermark, for instance uploaded with preserved
+// times, are not seen. A cursor is meant for one consumer calling Next and
+// Commit in turn. It is a Stopper, so a Group can stop it.
+type PollCursor struct {
+	client      *SFTPClient
+	dir         string
+	store       CursorStore
+	minInterval time.Duration
+	maxInterval time.Duration
+	interval    time.Duration
+
+	// busy is held while Next runs.
+	busy     sync.Mutex
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewPollCursor returns a cursor over the files of dir, positioned at the
+// watermark in store.
+func NewPollCursor(client *SFTPClient, dir string, store CursorStore, opts ...PollOption) (*PollCursor, error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	if store == nil {
+		return nil, fmt.Errorf("cursor store is nil")
+	}
+	cursor := &PollCursor{
+		client:      client,
+		dir:         dir,
+		store:       store,
+		minInterval: DefaultPollMinInterval,
+		maxInterval: DefaultPollMaxInterval,
+		stopped:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(cursor); err != nil {
+			return nil, err
+		}
+	}
+	cursor.interval = cursor.minInterval
+	return cursor, nil
+}
+
+// Next waits until dir holds files past the watermark and returns them
+// sorted by modification time and name, polling with a backoff while there
+// are none. Commit moves the watermark past the returned files once they
+// are processed; until then, further calls to Next return them again. Once
+// the cursor is stopped, Next returns ErrCursorStopped.
+func (cursor *PollCursor) Next(ctx context.Context) ([]RemoteEntry, func() error, error) {
+	cursor.busy.Lock()
+	defer cursor.busy.Unlock()
+	select {
+	case <-cursor.stopped:
+		return nil, nil, ErrCursorStopped
+	default:
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-cursor.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	mark, err := cursor.store.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load watermark: %w", err)
+	}
+	params := &listParams{filter: func(entry RemoteEntry) bool {
+		return entry.Mode.IsRegular() && mark.after(entry)
+	}}
+
+	for {
+		err = cursor.client.ensureConnected()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to reconnect: %w", err)
+		}
+		entries, err := cursor.client.listEntries(cursor.dir, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(entries) > 0 {
+			cursor.interval = cursor.minInterval
+			sort.SliceStable(entries, func(i, j int) bool {
+				return entries[i].ModTime.Before(entries[j].ModTime)
+			})
+			next := mark.advance(entries)
+			commit := func() error {
+				err := cursor.store.Save(next)
+				if err != nil {
+					return fmt.Errorf("failed to save watermark: %w", err)
+				}
+				return nil
+			}
+			return entries, commit, nil
+		}
+
+		err = cursor.client.sleepContext(ctx, cursor.interval)
+		select {
+		case <-cursor.stopped:
+			return nil, nil, ErrCursorStopped
+		default:
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		cursor.interval = min(2*cursor.interval, cursor.maxInterval)
+	}
+}
+
+// Stop makes the Next call waiting for files, and every later one, return
+// ErrCursorStopped. It returns once a listing in progress is over, or when
+// ctx is done.
+func (cursor *PollCursor) Stop(ctx context.Context) error {
+	cursor.stopOnce.Do(func() { close(cursor.stopped) })
+	idle := make(chan struct{})
+	go func() {
+		cursor.busy.Lock()
+		cursor.busy.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("poll cursor still listing: %w", ctx.Err())
+	}
+}
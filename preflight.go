@@ -0,0 +1,310 @@
+package sftpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrPreflightSkipped is returned by a check that could not run, for example
+// because the server lacks the extension it relies on.
+var ErrPreflightSkipped = errors.New("preflight check skipped")
+
+// PreflightStatus is the outcome of a single preflight check.
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "pass"
+	PreflightFail PreflightStatus = "fail"
+	PreflightSkip PreflightStatus = "skip"
+)
+
+// PreflightCheck validates one aspect of the endpoint configuration. Run
+// returns a human readable detail on success, and an error wrapping
+// ErrPreflightSkipped when the check does not apply.
+type PreflightCheck struct {
+	Name string
+	Run  func(ctx context.Context, client *SFTPClient) (string, error)
+
+	failFast bool
+}
+
+// FailFast returns a copy of the check whose failure skips all the checks
+// after it.
+func (c PreflightCheck) FailFast() PreflightCheck {
+	c.failFast = true
+	return c
+}
+
+// PreflightResult is the outcome of one check.
+type PreflightResult struct {
+	Name     string
+	Status   PreflightStatus
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// PreflightReport lists the outcome of every requested check in order.
+type PreflightReport struct {
+	Results  []PreflightResult
+	Started  time.Time
+	Duration time.Duration
+}
+
+// Passed reports whether no check failed.
+func (r *PreflightReport) Passed() bool {
+	for _, result := range r.Results {
+		if result.Status == PreflightFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Preflight runs the checks in order and reports their outcome, so a
+// configuration can be validated before it goes live. Without checks it
+// runs Connectivity and Auth. The returned error joins the failures; the
+// report is returned either way.
+func (client *SFTPClient) Preflight(ctx context.Context, checks ...PreflightCheck) (_ *PreflightReport, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "Preflight", "")
+	defer done(&err)
+	if len(checks) == 0 {
+		checks = []PreflightCheck{Connectivity(), Auth()}
+	}
+
+	start := time.Now()
+	report := &PreflightReport{Started: start}
+	var errs []error
+	stop := ""
+
+	for _, check := range checks {
+		result := PreflightResult{Name: check.Name}
+		switch {
+		case stop != "":
+			result.Status = PreflightSkip
+			result.Detail = fmt.Sprintf("not run after %s failed", stop)
+		case ctx.Err() != nil:
+			result.Status = PreflightSkip
+			result.Err = ctx.Err()
+			result.Detail = "not run, context done"
+		default:
+			checkStart := time.Now()
+			result.Detail, result.Err = check.Run(ctx, client)
+			result.Duration = time.Since(checkStart)
+			switch {
+			case result.Err == nil:
+				result.Status = PreflightPass
+			case errors.Is(result.Err, ErrPreflightSkipped):
+				result.Status = PreflightSkip
+			default:
+				result.Status = PreflightFail
+				errs = append(errs, fmt.Errorf("%s: %w", check.Name, result.Err))
+				if check.failFast {
+					stop = check.Name
+				}
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	report.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("%d of %d preflight checks failed: %w", len(errs), len(checks), errors.Join(errs...))
+	}
+	return report, nil
+}
+
+// handshake opens a fresh SSH connection with the configured credentials
+// and returns the host key the server presented.
+func (client *SFTPClient) handshake(ctx context.Context) (ssh.PublicKey, error) {
+	conn, err := client.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConfig, err := client.params.sshClientConfig(0)
+	if err != nil {
+		return nil, err
+	}
+	var hostKey ssh.PublicKey
+	verify := sshConfig.HostKeyCallback
+	sshConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKey = key
+		return verify(hostname, remote, key)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, client.remoteAddr(), sshConfig)
+	if err != nil {
+		return hostKey, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	ssh.NewClient(sshConn, chans, reqs).Close()
+	return hostKey, nil
+}
+
+// Connectivity checks that the server accepts TCP connections.
+func Connectivity() PreflightCheck {
+	return PreflightCheck{
+		Name: "connectivity",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			conn, err := client.dialContext(ctx)
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+			return "connected to " + client.remoteAddr(), nil
+		},
+	}
+}
+
+// Auth checks that the configured credentials are accepted.
+func Auth() PreflightCheck {
+	return PreflightCheck{
+		Name: "auth",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			_, err := client.handshake(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "authenticated as " + client.params.User(), nil
+		},
+	}
+}
+
+// HostKeyPinned checks that the server presents the host key with the given
+// SHA256 fingerprint, as printed by ssh-keygen -l ("SHA256:...").
+func HostKeyPinned(fingerprint string) PreflightCheck {
+	return PreflightCheck{
+		Name: "host_key_pinned",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			hostKey, err := client.handshake(ctx)
+			if hostKey == nil {
+				if err == nil {
+					err = fmt.Errorf("server presented no host key")
+				}
+				return "", err
+			}
+			got := ssh.FingerprintSHA256(hostKey)
+			if got != fingerprint {
+				return got, fmt.Errorf("host key %s does not match pinned %s", got, fingerprint)
+			}
+			return got, nil
+		},
+	}
+}
+
+// BaseDirExists checks that dir exists and is a directory.
+func BaseDirExists(dir string) PreflightCheck {
+	return PreflightCheck{
+		Name: "base_dir_exists",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			err := client.ensureConnected()
+			if err != nil {
+				return "", fmt.Errorf("failed to reconnect: %w", err)
+			}
+			info, err := client.sftpConn().Stat(dir)
+			if err != nil {
+				return "", fmt.Errorf("failed to get remote file info: %w", err)
+			}
+			if !info.IsDir() {
+				return "", fmt.Errorf("remote path %q is not a directory", dir)
+			}
+			return dir + " exists", nil
+		},
+	}
+}
+
+// BaseDirWritable checks that files can be created in dir by writing and
+// removing a small probe file. The probe is removed even when writing it
+// fails.
+func BaseDirWritable(dir string) PreflightCheck {
+	return PreflightCheck{
+		Name: "base_dir_writable",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			err := client.ensureConnected()
+			if err != nil {
+				return "", fmt.Errorf("failed to reconnect: %w", err)
+			}
+
+			suffix := make([]byte, 4)
+			_, err = rand.Read(suffix)
+			if err != nil {
+				return "", fmt.Errorf("failed to generate probe name: %w", err)
+			}
+			probe := path.Join(dir, ".sftpc-preflight-"+client.params.ClientID()+"-"+hex.EncodeToString(suffix))
+
+			err = client.writeProbe(probe)
+			removeErr := client.sftpConn().Remove(probe)
+			if err != nil {
+				return "", err
+			}
+			if removeErr != nil {
+				return "", fmt.Errorf("failed to remove probe file %q: %w", probe, removeErr)
+			}
+			return "created and removed " + probe, nil
+		},
+	}
+}
+
+func (client *SFTPClient) writeProbe(probe string) error {
+	f, err := client.openRemote(context.Background(), probe, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte("sftpc preflight\n"))
+	if err != nil {
+		return fmt.Errorf("failed to write probe file: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close probe file: %w", err)
+	}
+	return nil
+}
+
+// FreeSpaceAtLeast checks that the file system holding dir has at least n
+// bytes available. It is skipped when the server does not support the
+// statvfs@openssh.com extension.
+func FreeSpaceAtLeast(dir string, n uint64) PreflightCheck {
+	return PreflightCheck{
+		Name: "free_space",
+		Run: func(ctx context.Context, client *SFTPClient) (string, error) {
+			err := client.ensureConnected()
+			if err != nil {
+				return "", fmt.Errorf("failed to reconnect: %w", err)
+			}
+			if _, ok := client.sftpConn().HasExtension("statvfs@openssh.com"); !ok {
+				return "", fmt.Errorf("%w: server does not support statvfs@openssh.com", ErrPreflightSkipped)
+			}
+			vfs, err := client.sftpConn().StatVFS(dir)
+			if err != nil {
+				return "", fmt.Errorf("failed to get file system info: %w", err)
+			}
+			free := vfs.Bavail * vfs.Frsize
+			if free < n {
+				return "", fmt.Errorf("%d bytes available in %q, need %d", free, dir, n)
+			}
+			return fmt.Sprintf("%d bytes available", free), nil
+		},
+	}
+}
@@ -0,0 +1,274 @@
+package sftpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Profile is a named set of client settings for one partner server, meant
+// to be kept as JSON next to the integration code. Zero fields leave the
+// client defaults alone. Credentials and host key verification are not
+// part of a profile, pass them to NewSFTPClientWithProfile.
+type Profile struct {
+	Name string
+	Host string
+	Port string
+	User string
+
+	AppendStrategy AppendStrategy
+	SymlinkPolicy  SymlinkPolicy
+	// NoPosixRename, TimePrecision and WriteOnly set WithoutPosixRename,
+	// WithTimePrecision and WithWriteOnly.
+	NoPosixRename bool
+	TimePrecision time.Duration
+	WriteOnly     bool
+
+	ChannelsPerConnection int
+	MaxOpenHandles        int
+	DialTimeout           time.Duration
+}
+
+// ApplyTo sets the fields of the profile on params. Options applied to
+// params afterwards take precedence.
+func (p *Profile) ApplyTo(params *SFTPClientParams) error {
+	if p.AppendStrategy < AppendAuto || p.AppendStrategy > AppendOffset {
+		return fmt.Errorf("profile %q: invalid append strategy: %d", p.Name, int(p.AppendStrategy))
+	}
+	if p.SymlinkPolicy < SymlinkFollow || p.SymlinkPolicy > SymlinkFollowSafe {
+		return fmt.Errorf("profile %q: invalid symlink policy: %d", p.Name, int(p.SymlinkPolicy))
+	}
+	if p.TimePrecision != 0 && p.TimePrecision < time.Second {
+		return fmt.Errorf("profile %q: time precision must be at least one second, got %s", p.Name, p.TimePrecision)
+	}
+	if p.ChannelsPerConnection < 0 || p.MaxOpenHandles < 0 || p.DialTimeout < 0 {
+		return fmt.Errorf("profile %q: negative limits", p.Name)
+	}
+
+	if p.Host != "" {
+		params.SetHost(p.Host)
+	}
+	if p.Port != "" {
+		params.SetPort(p.Port)
+	}
+	if p.User != "" {
+		params.SetUser(p.User)
+	}
+	params.SetAppendStrategy(p.AppendStrategy)
+	params.SetSymlinkPolicy(p.SymlinkPolicy)
+	params.SetNoPosixRename(p.NoPosixRename)
+	params.SetTimePrecision(p.TimePrecision)
+	params.SetWriteOnly(p.WriteOnly)
+	if p.ChannelsPerConnection > 0 {
+		params.SetChannelsPerConnection(p.ChannelsPerConnection)
+	}
+	if p.MaxOpenHandles > 0 {
+		params.SetMaxOpenHandles(p.MaxOpenHandles)
+	}
+	if p.DialTimeout > 0 {
+		params.SetDialTimeout(p.DialTimeout)
+	}
+	return nil
+}
+
+type profileJSON struct {
+	reportHeader
+	Name                  string `json:"name"`
+	Host                  string `json:"host,omitempty"`
+	Port                  string `json:"port,omitempty"`
+	User                  string `json:"user,omitempty"`
+	AppendStrategy        string `json:"appendStrategy,omitempty"`
+	SymlinkPolicy         string `json:"symlinkPolicy,omitempty"`
+	NoPosixRename         bool   `json:"noPosixRename,omitempty"`
+	TimePrecisionMs       int64  `json:"timePrecisionMs,omitempty"`
+	WriteOnly             bool   `json:"writeOnly,omitempty"`
+	ChannelsPerConnection int    `json:"channelsPerConnection,omitempty"`
+	MaxOpenHandles        int    `json:"maxOpenHandles,omitempty"`
+	DialTimeoutMs         int64  `json:"dialTimeoutMs,omitempty"`
+}
+
+// MarshalJSON encodes the profile in the versioned report schema, leaving
+// out the fields at their defaults.
+func (p *Profile) MarshalJSON() ([]byte, error) {
+	doc := profileJSON{
+		reportHeader:          reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "profile"},
+		Name:                  p.Name,
+		Host:                  p.Host,
+		Port:                  p.Port,
+		User:                  p.User,
+		NoPosixRename:         p.NoPosixRename,
+		TimePrecisionMs:       millis(p.TimePrecision),
+		WriteOnly:             p.WriteOnly,
+		ChannelsPerConnection: p.ChannelsPerConnection,
+		MaxOpenHandles:        p.MaxOpenHandles,
+		DialTimeoutMs:         millis(p.DialTimeout),
+	}
+	if p.AppendStrategy != AppendAuto {
+		doc.AppendStrategy = p.AppendStrategy.String()
+	}
+	if p.SymlinkPolicy != SymlinkFollow {
+		doc.SymlinkPolicy = p.SymlinkPolicy.String()
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a profile written by MarshalJSON.
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	var doc profileJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("profile")
+	if err != nil {
+		return err
+	}
+
+	*p = Profile{
+		Name:                  doc.Name,
+		Host:                  doc.Host,
+		Port:                  doc.Port,
+		User:                  doc.User,
+		NoPosixRename:         doc.NoPosixRename,
+		TimePrecision:         fromMillis(doc.TimePrecisionMs),
+		WriteOnly:             doc.WriteOnly,
+		ChannelsPerConnection: doc.ChannelsPerConnection,
+		MaxOpenHandles:        doc.MaxOpenHandles,
+		DialTimeout:           fromMillis(doc.DialTimeoutMs),
+	}
+	if doc.AppendStrategy != "" {
+		p.AppendStrategy, err = parseEnum(doc.AppendStrategy, AppendAuto, AppendOffset)
+		if err != nil {
+			return fmt.Errorf("invalid append strategy: %w", err)
+		}
+	}
+	if doc.SymlinkPolicy != "" {
+		p.SymlinkPolicy, err = parseEnum(doc.SymlinkPolicy, SymlinkFollow, SymlinkFollowSafe)
+		if err != nil {
+			return fmt.Errorf("invalid symlink policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseEnum returns the value from first to last whose String is s.
+func parseEnum[E ~int](s string, first, last E) (E, error) {
+	for v := first; v <= last; v++ {
+		if fmt.Sprint(v) == s {
+			return v, nil
+		}
+	}
+	return first, fmt.Errorf("unknown value %q", s)
+}
+
+var (
+	profilesMu sync.Mutex
+	profiles   = map[string]Profile{}
+)
+
+// RegisterProfile registers profile under its name, replacing any profile
+// registered under the same name.
+func RegisterProfile(profile *Profile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("profile name must not be empty")
+	}
+	err := profile.ApplyTo(&SFTPClientParams{})
+	if err != nil {
+		return err
+	}
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profiles[profile.Name] = *profile
+	return nil
+}
+
+// GetProfile returns a copy of the profile registered under name.
+func GetProfile(name string) (*Profile, bool) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	return &profile, true
+}
+
+// ProfileNames returns the names of the registered profiles, sorted.
+func ProfileNames() []string {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSFTPClientWithProfile connects with the profile registered under name,
+// overrides taking precedence over its settings.
+func NewSFTPClientWithProfile(name string, overrides ...Options) (*SFTPClient, error) {
+	profile, ok := GetProfile(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	params := &SFTPClientParams{}
+	err := profile.ApplyTo(params)
+	if err != nil {
+		return nil, err
+	}
+	params, err = applyOptions(params, overrides...)
+	if err != nil {
+		return nil, err
+	}
+	err = params.checkRequired(true)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newClient(params)
+	err = client.connect(context.Background(), params.DialTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// DetectProfile probes the connected server, in its working directory, and
+// suggests a profile reproducing what it found: the append semantics,
+// whether posix-rename and stat on uploaded files work and how precisely
+// modification times are kept. The suggestion is named after the host and
+// meant to be reviewed before it is registered.
+func DetectProfile(client *SFTPClient) (*Profile, error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+
+	report, err := client.CheckCapabilities(".")
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Profile{
+		Name:          client.params.Host(),
+		Host:          client.params.Host(),
+		Port:          client.params.Port(),
+		User:          client.params.User(),
+		NoPosixRename: !client.hasPosixRename(),
+		WriteOnly:     !report.Supported(CapabilityStat),
+	}
+	if report.TimePrecision > time.Second {
+		profile.TimePrecision = report.TimePrecision
+	}
+	// Without any way to append, leave the strategy to probe at run time
+	if report.Supported(CapabilityAppend) {
+		profile.AppendStrategy, err = client.appendStrategyIn(".")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profile, nil
+}
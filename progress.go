@@ -0,0 +1,159 @@
+package sftpc
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Phase identifies the stage of an operation a progress event belongs to.
+type Phase int
+
+const (
+	PhaseTransfer Phase = iota
+	PhaseVerify
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseTransfer:
+		return "transfer"
+	case PhaseVerify:
+		return "verify"
+	default:
+		return fmt.Sprintf("phase(%d)", int(p))
+	}
+}
+
+// ProgressInfo is delivered to the WithProgress callback. Transferred and
+// Total are bytes moved during PhaseTransfer and bytes hashed during
+// PhaseVerify.
+type ProgressInfo struct {
+	Phase       Phase
+	Path        string
+	Transferred int64
+	Total       int64
+
+	// BytesPerSecond is the rate over the last ThroughputWindow, zero until
+	// it can be told. EstimatedRemaining is the time left at that rate,
+	// zero when the rate or the total is unknown.
+	BytesPerSecond     float64
+	EstimatedRemaining time.Duration
+}
+
+// Percent returns the completion percentage, 100 for empty totals and 0
+// when the total is unknown (negative).
+func (p ProgressInfo) Percent() float64 {
+	if p.Total < 0 {
+		return 0
+	}
+	if p.Total == 0 {
+		return 100
+	}
+	return float64(p.Transferred) / float64(p.Total) * 100
+}
+
+// WithProgress registers a callback invoked as the transfer and the
+// verification phases advance.
+func WithProgress(fn func(ProgressInfo)) TransferOption {
+	return func(params *transferParams) error {
+		params.progress = fn
+		return nil
+	}
+}
+
+// NewConsoleProgress returns a progress callback rendering a single
+// carriage-return updated line on w, with a "verifying…" suffix once the
+// transfer is done and the checksum phase starts.
+func NewConsoleProgress(w io.Writer) func(ProgressInfo) {
+	return func(p ProgressInfo) {
+		switch p.Phase {
+		case PhaseVerify:
+			fmt.Fprintf(w, "\r%s 100.00%% complete, verifying… %.2f%%", p.Path, p.Percent())
+		default:
+			fmt.Fprintf(w, "\r%s %.2f%% complete", p.Path, p.Percent())
+		}
+	}
+}
+
+// ThroughputWindow is the span ProgressInfo.BytesPerSecond is measured
+// over, so that it follows changes of the link speed.
+const ThroughputWindow = 5 * time.Second
+
+// minRateSpan is how much time the rate must be measured over before it is
+// reported, one read right after the start would make it spike.
+const minRateSpan = 200 * time.Millisecond
+
+type rateSample struct {
+	at          time.Time
+	transferred int64
+}
+
+// rateMeter measures the throughput of a transfer over ThroughputWindow.
+// It starts from the bytes already there, so that resumed bytes do not
+// count as transferred instantly.
+type rateMeter struct {
+	samples []rateSample
+}
+
+func newRateMeter(transferred int64, now time.Time) *rateMeter {
+	return &rateMeter{samples: []rateSample{{now, transferred}}}
+}
+
+// update records info.Transferred at now and sets the rate and the
+// estimated remaining time of info.
+func (m *rateMeter) update(info *ProgressInfo, now time.Time) {
+	m.samples = append(m.samples, rateSample{now, info.Transferred})
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= ThroughputWindow {
+		m.samples = m.samples[1:]
+	}
+
+	info.BytesPerSecond, info.EstimatedRemaining = 0, 0
+	first := m.samples[0]
+	span := now.Sub(first.at)
+	if span < minRateSpan {
+		return
+	}
+	info.BytesPerSecond = float64(info.Transferred-first.transferred) / span.Seconds()
+	if info.Total >= 0 && info.BytesPerSecond > 0 {
+		left := float64(max(info.Total-info.Transferred, 0))
+		info.EstimatedRemaining = time.Duration(left / info.BytesPerSecond * float64(time.Second))
+	}
+}
+
+// progressReader reports the running total of bytes read through it, and
+// reports once at the end when nothing was left to read, so that empty
+// files show as complete.
+type progressReader struct {
+	r        io.Reader
+	info     ProgressInfo
+	report   func(ProgressInfo)
+	rate     *rateMeter
+	reported bool
+}
+
+func newProgressReader(r io.Reader, info ProgressInfo, report func(ProgressInfo)) io.Reader {
+	if report == nil {
+		return r
+	}
+	return &progressReader{r: r, info: info, report: report, rate: newRateMeter(info.Transferred, time.Now())}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 || (err == io.EOF && !p.reported) {
+		p.info.Transferred += int64(n)
+		p.rate.update(&p.info, time.Now())
+		p.report(p.info)
+		p.reported = true
+	}
+	return n, err
+}
+
+// reportComplete tells the progress callback, if any, that path needed no
+// transfer because the destination was already complete.
+func (params *transferParams) reportComplete(path string, total int64) {
+	if params.progress != nil {
+		params.progress(ProgressInfo{Phase: PhaseTransfer, Path: path, Transferred: total, Total: total})
+	}
+}
@@ -0,0 +1,92 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+var (
+	// ErrProxyUnreachable is returned when the SOCKS5 proxy itself could
+	// not be connected to.
+	ErrProxyUnreachable = errors.New("SOCKS5 proxy unreachable")
+
+	// ErrProxyTargetUnreachable is returned when the SOCKS5 proxy was
+	// reached but could not connect to the SFTP server.
+	ErrProxyTargetUnreachable = errors.New("SFTP server unreachable through the SOCKS5 proxy")
+)
+
+// WithSOCKS5Proxy connects to the server through the SOCKS5 proxy at addr,
+// host:port, on the initial dial and on every reconnect. user and password
+// authenticate to the proxy, no authentication is offered when user is
+// empty. The dial timeout covers the proxy handshake.
+func WithSOCKS5Proxy(addr, user, password string) Options {
+	return func(params *SFTPClientParams) error {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid SOCKS5 proxy address %q: %w", addr, err)
+		}
+		params.socks5Addr = addr
+		params.socks5Auth = nil
+		if user != "" {
+			params.socks5Auth = &proxy.Auth{User: user, Password: password}
+		}
+		params.record("WithSOCKS5Proxy", strings.Join([]string{addr, user, secretValue(password)}, "\x00"))
+		return nil
+	}
+}
+
+// dialSOCKS5 dials the server through the SOCKS5 proxy, telling apart in
+// the error whether the proxy or the server behind it was unreachable.
+func (client *SFTPClient) dialSOCKS5(ctx context.Context) (net.Conn, error) {
+	proxyAddr := client.params.SOCKS5Proxy()
+	reached := false
+	forward := proxyDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer := net.Dialer{KeepAlive: -1}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		reached = true
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			err = client.params.applyTCPOptions(tcpConn)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	})
+
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, client.params.SOCKS5Auth(), forward)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, client.network, client.remoteAddr())
+	switch {
+	case err == nil:
+		return conn, nil
+	case !reached:
+		return nil, fmt.Errorf("failed to dial: %w %s: %w", ErrProxyUnreachable, proxyAddr, err)
+	// x/net reports every failure reply of the proxy as "unknown error"
+	// followed by the reply, such as "host unreachable"
+	case strings.Contains(err.Error(), "unknown error "):
+		return nil, fmt.Errorf("failed to dial: %w: %s via %s: %w", ErrProxyTargetUnreachable, client.remoteAddr(), proxyAddr, err)
+	default:
+		return nil, fmt.Errorf("failed to dial: SOCKS5 handshake with %s failed: %w", proxyAddr, err)
+	}
+}
+
+// proxyDialer adapts a dial function to proxy.Dialer and proxy.ContextDialer.
+type proxyDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d proxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
@@ -0,0 +1,335 @@
+package sftpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultQueueMaxAttempts is how many times ProcessQueue tries an entry,
+// over all runs, before leaving it failed.
+const DefaultQueueMaxAttempts = 3
+
+// ErrQueueEntriesFailed is returned by ProcessQueue when some entries
+// failed; they are retried by the next run until DefaultQueueMaxAttempts.
+var ErrQueueEntriesFailed = errors.New("queue entries failed")
+
+// QueueStatus is the state of a file in a download queue.
+type QueueStatus string
+
+const (
+	QueuePending QueueStatus = "pending"
+	QueueDone    QueueStatus = "done"
+	QueueFailed  QueueStatus = "failed"
+)
+
+// QueueEntry is a file in a download queue with its processing state. Err
+// is the message of the last failure.
+type QueueEntry struct {
+	RemoteEntry
+	Status   QueueStatus
+	Attempts int
+	Err      string
+}
+
+// queueRecord is one line of a queue file. The file is only appended to;
+// the last line for a path holds its current state.
+type queueRecord struct {
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	ModTime  string      `json:"mtime"`
+	Status   QueueStatus `json:"status"`
+	Attempts int         `json:"attempts,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+func newQueueRecord(entry *QueueEntry) queueRecord {
+	return queueRecord{
+		Path:     entry.Path,
+		Size:     entry.Size,
+		ModTime:  formatTime(entry.ModTime),
+		Status:   entry.Status,
+		Attempts: entry.Attempts,
+		Error:    entry.Err,
+	}
+}
+
+// queueFile is an open queue file and the state replayed from it.
+type queueFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	entries map[string]*QueueEntry
+	order   []string
+}
+
+// openQueue opens or creates the queue file at queuePath and replays it. A
+// last line cut short by a crash is dropped.
+func openQueue(queuePath string) (*queueFile, error) {
+	f, err := os.OpenFile(queuePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue file: %w", err)
+	}
+	q := &queueFile{f: f, entries: make(map[string]*QueueEntry)}
+	err = q.replay()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read queue file %q: %w", queuePath, err)
+	}
+	return q, nil
+}
+
+func (q *queueFile) replay() error {
+	data, err := io.ReadAll(q.f)
+	if err != nil {
+		return err
+	}
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		err = q.f.Truncate(int64(complete))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = q.f.Seek(int64(complete), io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data[:complete]))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record queueRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		modTime, err := parseTime(record.ModTime)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		entry, ok := q.entries[record.Path]
+		if !ok {
+			entry = &QueueEntry{}
+			q.entries[record.Path] = entry
+			q.order = append(q.order, record.Path)
+		}
+		*entry = QueueEntry{
+			RemoteEntry: RemoteEntry{Name: path.Base(record.Path), Path: record.Path, Size: record.Size, ModTime: modTime},
+			Status:      record.Status,
+			Attempts:    record.Attempts,
+			Err:         record.Error,
+		}
+	}
+	return scanner.Err()
+}
+
+// save appends the state of entry, with a single write so that a crash
+// cannot interleave it with another line.
+func (q *queueFile) save(entry *QueueEntry) error {
+	line, err := json.Marshal(newQueueRecord(entry))
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, err = q.f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write queue file: %w", err)
+	}
+	return nil
+}
+
+func (q *queueFile) Close() error {
+	return q.f.Close()
+}
+
+// ReadQueue returns the entries of the queue file at queuePath in the order
+// they were first queued, with their current state.
+func ReadQueue(queuePath string) ([]QueueEntry, error) {
+	q, err := openQueue(queuePath)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	entries := make([]QueueEntry, 0, len(q.order))
+	for _, p := range q.order {
+		entries = append(entries, *q.entries[p])
+	}
+	return entries, nil
+}
+
+// ScanToQueue walks root and appends the regular files it finds to the
+// queue file at queuePath, creating it if needed, as pending entries.
+// Files already queued with the same size and modification time are left
+// alone whatever their state; files that changed are queued again. The
+// queue is plain JSON lines, one state per line, so it can be inspected
+// and repaired with the usual tools.
+func (client *SFTPClient) ScanToQueue(root string, queuePath string, opts ...WalkOption) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ScanToQueue", root)(&err)
+
+	params, err := newWalkParams(opts...)
+	if err != nil {
+		return err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	q, err := openQueue(queuePath)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	return client.walk(root, params, func(info RemoteFileInfo) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if queued, ok := q.entries[info.Path]; ok && queued.Size == info.Size() && queued.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+		entry := &QueueEntry{
+			RemoteEntry: RemoteEntry{Name: info.Name(), Path: info.Path, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode()},
+			Status:      QueuePending,
+		}
+		q.entries[info.Path] = entry
+		return q.save(entry)
+	})
+}
+
+// ProcessQueue runs handler for the entries of the queue file at queuePath
+// that are not done, on workers goroutines sharing the client, and records
+// every outcome in the queue as it happens. A run that is killed resumes
+// from the queue: entries recorded as done are not handed out again, an
+// entry in flight at the time is. Failed entries are retried by later runs
+// up to DefaultQueueMaxAttempts attempts in total; the run fails with
+// ErrQueueEntriesFailed when any entry failed.
+func (client *SFTPClient) ProcessQueue(queuePath string, workers int, handler func(RemoteEntry, *SFTPClient) error) error {
+	return client.ProcessQueueContext(context.Background(), queuePath, workers, handler)
+}
+
+// ProcessQueueContext is ProcessQueue handing out no more entries once ctx
+// is done. The handlers running finish and are recorded, the entries left
+// stay pending for the next run, and ctx.Err() is returned. Run it with
+// Group.Go to have Group.Shutdown stop it.
+func (client *SFTPClient) ProcessQueueContext(ctx context.Context, queuePath string, workers int, handler func(RemoteEntry, *SFTPClient) error) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("ProcessQueue", "")(&err)
+	if workers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", workers)
+	}
+	if handler == nil {
+		return fmt.Errorf("queue handler is nil")
+	}
+
+	q, err := openQueue(queuePath)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	work := make(chan *QueueEntry)
+	var (
+		mu       sync.Mutex
+		failed   int
+		firstErr error
+		saveErr  error
+		wg       sync.WaitGroup
+	)
+	// cancelled is set when ctx stopped the run before the last entry.
+	var cancelled error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range work {
+				err := handler(entry.RemoteEntry, client)
+				entry.Attempts++
+				entry.Status, entry.Err = QueueDone, ""
+				if err != nil {
+					entry.Status, entry.Err = QueueFailed, err.Error()
+				}
+				saved := q.save(entry)
+
+				mu.Lock()
+				if err != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%q: %w", entry.Path, err)
+					}
+				}
+				if saved != nil && saveErr == nil {
+					saveErr = saved
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, p := range q.order {
+		entry := q.entries[p]
+		if entry.Status == QueueDone || entry.Attempts >= DefaultQueueMaxAttempts {
+			continue
+		}
+		mu.Lock()
+		stop := saveErr != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		select {
+		case work <- entry:
+		case <-ctx.Done():
+			cancelled = ctx.Err()
+		}
+		if cancelled != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if saveErr != nil {
+		return saveErr
+	}
+	if cancelled != nil {
+		return cancelled
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d, first %w", ErrQueueEntriesFailed, failed, firstErr)
+	}
+	return nil
+}
+
+// DownloadQueueHandler returns a ProcessQueue handler that downloads every
+// entry below root to the same relative path below localDir.
+func DownloadQueueHandler(root, localDir string, opts ...TransferOption) func(RemoteEntry, *SFTPClient) error {
+	return func(entry RemoteEntry, client *SFTPClient) error {
+		rel := filepath.FromSlash(remoteRel(root, entry.Path))
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("queued path %q is not below %q", entry.Path, root)
+		}
+		localPath := filepath.Join(localDir, rel)
+		err := os.MkdirAll(filepath.Dir(localPath), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create local directory: %w", err)
+		}
+		_, err = client.Get(entry.Path, localPath, opts...)
+		return err
+	}
+}
@@ -0,0 +1,238 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// removeEmptyDir removes the directory p and types its failures. It checks
+// first that p is a directory, since some servers remove files on rmdir.
+// Servers report a non-empty directory as a generic failure, so the
+// directory is listed to tell.
+func (client *SFTPClient) removeEmptyDir(p string) error {
+	info, err := client.sftpConn().Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %q", ErrNotADirectory, p)
+	}
+
+	err = client.sftpConn().RemoveDirectory(p)
+	if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	entries, listErr := client.readDir(p)
+	if listErr == nil && len(entries) > 0 {
+		return fmt.Errorf("%w: %q", ErrDirectoryNotEmpty, p)
+	}
+	return err
+}
+
+// RemoveDirIfEmpty removes the directory remotePath if it has no entries and reports
+// whether it did. A missing or non-empty directory is not an error.
+func (client *SFTPClient) RemoveDirIfEmpty(remotePath string) (_ bool, err error) {
+	if client == nil {
+		return false, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("RemoveDirIfEmpty", remotePath)(&err)
+
+	err = client.ensureConnected()
+	if err != nil {
+		return false, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	client.dirs.forget(remotePath)
+	err = client.removeEmptyDir(remotePath)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrDirectoryNotEmpty) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to remove directory: %w", err)
+}
+
+// RemoveOption configures RemoveAll.
+type RemoveOption func(*removeParams) error
+
+type removeParams struct {
+	allowRoot bool
+	// dryRun walks without removing, see WithRemoveDryRun.
+	dryRun bool
+}
+
+func newRemoveParams(opts ...RemoveOption) (*removeParams, error) {
+	params := &removeParams{}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// WithAllowRoot lets RemoveAll empty the root or the working directory,
+// which it refuses by default. The directory itself is kept.
+func WithAllowRoot() RemoveOption {
+	return func(params *removeParams) error {
+		params.allowRoot = true
+		return nil
+	}
+}
+
+// WithRemoveDryRun makes RemoveAll walk as usual without removing anything.
+// The paths it would remove are recorded with StatusPlanned. See WithDryRun
+// and WithSyncDryRun.
+func WithRemoveDryRun() RemoveOption {
+	return func(params *removeParams) error {
+		params.dryRun = true
+		return nil
+	}
+}
+
+// isRootPath reports whether p names the root or the working directory,
+// "", "." and "/" as well as spellings like "//" or "./".
+func isRootPath(p string) bool {
+	clean := path.Clean(p)
+	return clean == "/" || clean == "."
+}
+
+// RemoveAll removes remotePath and everything below it, contents before
+// their directory, and records every path in the result in that order.
+// Like os.RemoveAll, a missing path is not an error and a file is simply
+// removed; neither are entries removed by someone else in the meantime.
+// Entries that cannot be removed do not stop the removal of the others, the
+// returned error lists them all. The root and the working directory fail
+// with ErrRootPath unless WithAllowRoot is passed.
+func (client *SFTPClient) RemoveAll(remotePath string, opts ...RemoveOption) (*BatchResult, error) {
+	return client.RemoveAllContext(context.Background(), remotePath, opts...)
+}
+
+// RemoveAllContext is RemoveAll giving up once ctx is done.
+func (client *SFTPClient) RemoveAllContext(ctx context.Context, remotePath string, opts ...RemoveOption) (_ *BatchResult, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "RemoveAll", remotePath)
+	defer done(&err)
+
+	params, err := newRemoveParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	root := isRootPath(remotePath)
+	if root && !params.allowRoot {
+		return nil, fmt.Errorf("%w: %q, pass WithAllowRoot to empty it", ErrRootPath, remotePath)
+	}
+
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	result := &BatchResult{Started: start, DryRun: params.dryRun}
+	client.dirs.forget(remotePath)
+	info, err := client.sftpConn().Lstat(remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.Duration = time.Since(start)
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+	if root {
+		err = client.removeContents(ctx, remotePath, params, result)
+	} else {
+		err = client.removeAll(ctx, remotePath, info, params, result)
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+	var failed []error
+	for _, item := range result.Items {
+		if item.Status == StatusFailed {
+			failed = append(failed, item.Err)
+		}
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("failed to remove %d paths: %w", len(failed), errors.Join(failed...))
+	}
+	return result, nil
+}
+
+// removeAll removes p, recording it and the entries below it in result. It
+// only returns an error once ctx is done.
+func (client *SFTPClient) removeAll(ctx context.Context, p string, info os.FileInfo, params *removeParams, result *BatchResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		client.removeEntry(p, params, result, "remote file", client.sftpConn().Remove)
+		return nil
+	}
+
+	before := len(result.Items)
+	err := client.removeContents(ctx, p, params, result)
+	if err != nil {
+		return err
+	}
+	for _, item := range result.Items[before:] {
+		if item.Status == StatusFailed {
+			// The directory cannot be empty
+			return nil
+		}
+	}
+	client.removeEntry(p, params, result, "directory", client.sftpConn().RemoveDirectory)
+	return nil
+}
+
+// removeEntry removes the kind entry p with remove, or only plans it in a
+// dry run, and records the outcome in result. Entries already gone are not
+// recorded.
+func (client *SFTPClient) removeEntry(p string, params *removeParams, result *BatchResult, kind string, remove func(string) error) {
+	item := BatchItem{RemotePath: p, Status: StatusRemoved}
+	if params.dryRun {
+		item.Status = StatusPlanned
+		result.add(item)
+		return
+	}
+	err := remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		item.Status = StatusFailed
+		item.Err = fmt.Errorf("failed to remove %s %q: %w", kind, p, err)
+	}
+	result.add(item)
+}
+
+// removeContents removes everything below the directory p, recording the
+// entries in result. It only returns an error once ctx is done.
+func (client *SFTPClient) removeContents(ctx context.Context, p string, params *removeParams, result *BatchResult) error {
+	entries, err := client.listDir(ctx, p)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			result.add(BatchItem{RemotePath: p, Status: StatusFailed, Err: fmt.Errorf("failed to list directory %q: %w", p, err)})
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		err = client.removeAll(ctx, path.Join(p, entry.Name()), entry, params, result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrReplayGap is returned when a reader-based upload failed further back
+// than its replay buffer reaches, see WithReplayBuffer.
+var ErrReplayGap = errors.New("remote file is behind the replay buffer")
+
+// errSourceFailed marks failures to read the upload source, which retrying
+// cannot help.
+var errSourceFailed = errors.New("failed to read source stream")
+
+// WithReplayBuffer keeps the last maxBytes of a reader-based upload in
+// memory. When the connection fails, the client reconnects, asks the server
+// how much of the file arrived and replays the missing tail from the buffer
+// before reading on from the source. Writes are sent one at a time so that
+// the remote size is a reliable resume point. It does not combine with
+// WithReadAhead or WithIdleChunkKeepAlive.
+func WithReplayBuffer(maxBytes int64) TransferOption {
+	return func(params *transferParams) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("invalid replay buffer size: %d", maxBytes)
+		}
+		params.replayBuffer = maxBytes
+		return nil
+	}
+}
+
+// replayBuffer is a ring holding the last bytes written to it.
+type replayBuffer struct {
+	buf []byte
+	max int
+	// pos is where the next byte goes once buf is full; end is the stream
+	// offset just past the last byte written.
+	pos int
+	end int64
+}
+
+func newReplayBuffer(max int64) *replayBuffer {
+	return &replayBuffer{max: int(max)}
+}
+
+func (b *replayBuffer) Write(p []byte) {
+	b.end += int64(len(p))
+	if room := b.max - len(b.buf); room > 0 {
+		n := min(room, len(p))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+		b.pos = len(b.buf) % b.max
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.pos:], p)
+		p = p[n:]
+		b.pos = (b.pos + n) % b.max
+	}
+}
+
+// start is the stream offset of the oldest byte held.
+func (b *replayBuffer) start() int64 {
+	return b.end - int64(len(b.buf))
+}
+
+// from returns the bytes held from the stream offset off on.
+func (b *replayBuffer) from(off int64) ([]byte, error) {
+	if off < b.start() || off > b.end {
+		return nil, fmt.Errorf("%w: remote has %d bytes, buffer holds %d to %d", ErrReplayGap, off, b.start(), b.end)
+	}
+	held := append(append(make([]byte, 0, len(b.buf)), b.buf[b.pos:]...), b.buf[:b.pos]...)
+	return held[off-b.start():], nil
+}
+
+// uploadReplay is Upload with a replay buffer, restarting after retryable
+// failures from the size the server confirms.
+func (client *SFTPClient) uploadReplay(src io.Reader, remotePath string, params *transferParams, stats *TransferStats) error {
+	replay := newReplayBuffer(params.replayBuffer)
+	chunk := make([]byte, streamChunkSize)
+	var pending []byte
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	policy := client.retryPolicy(params)
+
+	for attempt := 1; ; attempt++ {
+		stats.Attempts = attempt
+		err := client.writeReplay(params.context(), src, remotePath, flags, replay, chunk, pending, stats)
+		if err == nil || errors.Is(err, errSourceFailed) {
+			return err
+		}
+		if attempt >= policy.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+
+		client.params.Logger().Warnf("Upload failed, retrying... attempt %d: %v", attempt, err)
+		err = client.pause(params.context(), policy.Backoff().Delay(attempt), err)
+		if err != nil {
+			return err
+		}
+		err = client.ensureConnectedContext(params.context())
+		if err != nil {
+			return fmt.Errorf("failed to reconnect: %w", err)
+		}
+
+		remoteFileInfo, err := client.sftpConn().Stat(remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to get remote file info: %w", err)
+		}
+		pending, err = replay.from(remoteFileInfo.Size())
+		if err != nil {
+			return err
+		}
+		stats.ReplayedBytes += int64(len(pending))
+		flags = os.O_WRONLY
+	}
+}
+
+// writeReplay writes pending, the replayed tail ending at the current end of
+// the buffer, then the rest of src, buffering what it reads.
+func (client *SFTPClient) writeReplay(ctx context.Context, src io.Reader, remotePath string, flags int, replay *replayBuffer, chunk, pending []byte, stats *TransferStats) error {
+	remoteFile, err := client.openRemote(ctx, remotePath, flags)
+	if err != nil {
+		return fmt.Errorf("failed to open or create remote file: %w", client.opError("open", remotePath, err))
+	}
+	defer remoteFile.Close()
+
+	_, err = remoteFile.Seek(replay.end-int64(len(pending)), io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to seek in remote file: %w", err)
+	}
+	for len(pending) > 0 {
+		n := min(len(pending), streamChunkSize)
+		written, err := remoteFile.Write(pending[:n])
+		client.addBytes(ctx, DirectionUpload, int64(written))
+		if err != nil {
+			return fmt.Errorf("failed to copy stream to remote: %w", client.opError("write", remotePath, err))
+		}
+		pending = pending[n:]
+	}
+
+	for {
+		n, readErr := src.Read(chunk)
+		if n > 0 {
+			replay.Write(chunk[:n])
+			stats.BytesTransferred += int64(n)
+			written, err := remoteFile.Write(chunk[:n])
+			client.addBytes(ctx, DirectionUpload, int64(written))
+			if err != nil {
+				return fmt.Errorf("failed to copy stream to remote: %w", client.opError("write", remotePath, err))
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("%w: %w", errSourceFailed, readErr)
+		}
+	}
+
+	err = remoteFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close remote file: %w", err)
+	}
+	return nil
+}
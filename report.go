@@ -0,0 +1,665 @@
+package sftpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportSchemaVersion is the version of the JSON schema of BatchResult,
+// DiffReport, PreflightReport, CapabilityReport and SyncReport. Field names
+// and enum strings are stable within a version; durations are in
+// milliseconds and timestamps RFC 3339.
+const ReportSchemaVersion = 1
+
+// ReportedError is an error read back from a JSON report. It keeps the
+// class the original error had, and the status code and server message of
+// an *OpError.
+type ReportedError struct {
+	Message       string
+	Class         ErrorClass
+	Code          uint32
+	ServerMessage string
+}
+
+func (e *ReportedError) Error() string {
+	return e.Message
+}
+
+type errorJSON struct {
+	Message       string     `json:"message"`
+	Class         ErrorClass `json:"class"`
+	Code          uint32     `json:"code,omitempty"`
+	ServerMessage string     `json:"serverMessage,omitempty"`
+}
+
+func newErrorJSON(err error) *errorJSON {
+	if err == nil {
+		return nil
+	}
+	doc := &errorJSON{Message: err.Error(), Class: ClassifyError(err)}
+	var opErr *OpError
+	var reported *ReportedError
+	switch {
+	case errors.As(err, &opErr):
+		doc.Code, doc.ServerMessage = opErr.Code, opErr.ServerMessage
+	case errors.As(err, &reported):
+		doc.Code, doc.ServerMessage = reported.Code, reported.ServerMessage
+	}
+	return doc
+}
+
+func (e *errorJSON) err() error {
+	if e == nil {
+		return nil
+	}
+	return &ReportedError{Message: e.Message, Class: e.Class, Code: e.Code, ServerMessage: e.ServerMessage}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid report timestamp %q: %w", s, err)
+	}
+	return t, nil
+}
+
+func millis(d time.Duration) int64 {
+	return d.Milliseconds()
+}
+
+func fromMillis(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// reportHeader starts every report document.
+type reportHeader struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Kind          string `json:"kind"`
+}
+
+func (h reportHeader) check(kind string) error {
+	if h.SchemaVersion < 1 || h.SchemaVersion > ReportSchemaVersion {
+		return fmt.Errorf("unsupported report schema version %d", h.SchemaVersion)
+	}
+	if h.Kind != kind {
+		return fmt.Errorf("report kind %q, want %q", h.Kind, kind)
+	}
+	return nil
+}
+
+type transferStatsJSON struct {
+	BytesTransferred int64  `json:"bytesTransferred"`
+	TotalSize        int64  `json:"totalSize"`
+	StartOffset      int64  `json:"startOffset"`
+	Resumed          bool   `json:"resumed"`
+	Attempts         int    `json:"attempts"`
+	DurationMs       int64  `json:"durationMs"`
+	Checksum         string `json:"checksum,omitempty"`
+}
+
+type batchItemJSON struct {
+	LocalPath  string             `json:"localPath"`
+	RemotePath string             `json:"remotePath"`
+	Status     ItemStatus         `json:"status"`
+	Stats      *transferStatsJSON `json:"stats,omitempty"`
+	Error      *errorJSON         `json:"error,omitempty"`
+	Symlink    bool               `json:"symlink,omitempty"`
+}
+
+type batchResultJSON struct {
+	reportHeader
+	StartedAt        string          `json:"startedAt,omitempty"`
+	DurationMs       int64           `json:"durationMs"`
+	DirsCreated      int             `json:"dirsCreated"`
+	EmptyDirsCreated int             `json:"emptyDirsCreated"`
+	EmptyDirsPruned  int             `json:"emptyDirsPruned"`
+	Pauses           int             `json:"pauses"`
+	PausedMs         int64           `json:"pausedMs"`
+	Items            []batchItemJSON `json:"items"`
+	Sample           *sampleJSON     `json:"sample,omitempty"`
+}
+
+type sampleJSON struct {
+	Seed       int64    `json:"seed"`
+	ByBytes    bool     `json:"byBytes"`
+	Sampled    []string `json:"sampled"`
+	Mismatched []string `json:"mismatched"`
+}
+
+func newSampleJSON(s *VerificationSample) *sampleJSON {
+	if s == nil {
+		return nil
+	}
+	return &sampleJSON{
+		Seed:       s.Seed,
+		ByBytes:    s.ByBytes,
+		Sampled:    emptyIfNil(s.Sampled),
+		Mismatched: emptyIfNil(s.Mismatched),
+	}
+}
+
+func (s *sampleJSON) sample() *VerificationSample {
+	if s == nil {
+		return nil
+	}
+	return &VerificationSample{Seed: s.Seed, ByBytes: s.ByBytes, Sampled: s.Sampled, Mismatched: s.Mismatched}
+}
+
+// mergeSample adds the files of the sample b to a, either of which may be
+// nil.
+func mergeSample(a, b *VerificationSample) *VerificationSample {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &VerificationSample{Seed: b.Seed, ByBytes: b.ByBytes}
+	}
+	a.Sampled = append(a.Sampled, b.Sampled...)
+	a.Mismatched = append(a.Mismatched, b.Mismatched...)
+	return a
+}
+
+// MarshalJSON encodes the result in the versioned report schema.
+func (r *BatchResult) MarshalJSON() ([]byte, error) {
+	doc := batchResultJSON{
+		reportHeader:     reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "batch"},
+		StartedAt:        formatTime(r.Started),
+		DurationMs:       millis(r.Duration),
+		DirsCreated:      r.DirsCreated,
+		EmptyDirsCreated: r.EmptyDirsCreated,
+		EmptyDirsPruned:  r.EmptyDirsPruned,
+		Pauses:           r.Pauses,
+		PausedMs:         millis(r.Paused),
+		Items:            make([]batchItemJSON, 0, len(r.Items)),
+		Sample:           newSampleJSON(r.Sample),
+	}
+	for _, item := range r.Items {
+		entry := batchItemJSON{
+			LocalPath:  item.LocalPath,
+			RemotePath: item.RemotePath,
+			Status:     item.Status,
+			Error:      newErrorJSON(item.Err),
+			Symlink:    item.Symlink,
+		}
+		if stats := item.Stats; stats != nil {
+			entry.Stats = &transferStatsJSON{
+				BytesTransferred: stats.BytesTransferred,
+				TotalSize:        stats.TotalSize,
+				StartOffset:      stats.StartOffset,
+				Resumed:          stats.Resumed,
+				Attempts:         stats.Attempts,
+				DurationMs:       millis(stats.Duration),
+				Checksum:         stats.Checksum,
+			}
+		}
+		doc.Items = append(doc.Items, entry)
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a result written by MarshalJSON. Item errors come
+// back as *ReportedError.
+func (r *BatchResult) UnmarshalJSON(data []byte) error {
+	var doc batchResultJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("batch")
+	if err != nil {
+		return err
+	}
+
+	started, err := parseTime(doc.StartedAt)
+	if err != nil {
+		return err
+	}
+	*r = BatchResult{
+		Started:          started,
+		Duration:         fromMillis(doc.DurationMs),
+		DirsCreated:      doc.DirsCreated,
+		EmptyDirsCreated: doc.EmptyDirsCreated,
+		EmptyDirsPruned:  doc.EmptyDirsPruned,
+		Pauses:           doc.Pauses,
+		Paused:           fromMillis(doc.PausedMs),
+		Sample:           doc.Sample.sample(),
+	}
+	for _, entry := range doc.Items {
+		item := BatchItem{
+			LocalPath:  entry.LocalPath,
+			RemotePath: entry.RemotePath,
+			Status:     entry.Status,
+			Err:        entry.Error.err(),
+			Symlink:    entry.Symlink,
+		}
+		if stats := entry.Stats; stats != nil {
+			item.Stats = &TransferStats{
+				LocalPath:        entry.LocalPath,
+				RemotePath:       entry.RemotePath,
+				BytesTransferred: stats.BytesTransferred,
+				TotalSize:        stats.TotalSize,
+				StartOffset:      stats.StartOffset,
+				Resumed:          stats.Resumed,
+				Attempts:         stats.Attempts,
+				Duration:         fromMillis(stats.DurationMs),
+				Checksum:         stats.Checksum,
+			}
+		}
+		r.Items = append(r.Items, item)
+	}
+	return nil
+}
+
+// Merge adds the items and counters of other, a result of another shard of
+// the same run. The merged run starts with the earliest shard and lasts as
+// long as the longest one.
+func (r *BatchResult) Merge(other *BatchResult) {
+	r.Items = append(r.Items, other.Items...)
+	if r.Started.IsZero() || (!other.Started.IsZero() && other.Started.Before(r.Started)) {
+		r.Started = other.Started
+	}
+	r.Duration = max(r.Duration, other.Duration)
+	r.DirsCreated += other.DirsCreated
+	r.EmptyDirsCreated += other.EmptyDirsCreated
+	r.EmptyDirsPruned += other.EmptyDirsPruned
+	r.Pauses += other.Pauses
+	r.Paused += other.Paused
+	r.Sample = mergeSample(r.Sample, other.Sample)
+}
+
+// Summary returns a one line description for notifications.
+func (r *BatchResult) Summary() string {
+	counts := []string{}
+	for _, status := range []ItemStatus{StatusTransferred, StatusPlanned, StatusSkipped, StatusDeferred, StatusRemoved, StatusCancelled, StatusFailed} {
+		if n := r.Count(status); n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "no items")
+	}
+	return fmt.Sprintf("%s, %d bytes in %s", strings.Join(counts, ", "), r.Bytes(), r.Duration.Round(time.Millisecond))
+}
+
+type diffReportJSON struct {
+	reportHeader
+	Added       []string          `json:"added"`
+	Changed     []string          `json:"changed"`
+	Unchanged   []string          `json:"unchanged"`
+	MissingDirs []string          `json:"missingDirs"`
+	Extraneous  []string          `json:"extraneous"`
+	Mapped      map[string]string `json:"mapped,omitempty"`
+	RemoteLists int               `json:"remoteLists"`
+	RemoteStats int               `json:"remoteStats"`
+}
+
+// emptyIfNil keeps empty lists as [] rather than null in reports.
+func emptyIfNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// MarshalJSON encodes the report in the versioned report schema.
+func (r *DiffReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(diffReportJSON{
+		reportHeader: reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "diff"},
+		Added:        emptyIfNil(r.Added),
+		Changed:      emptyIfNil(r.Changed),
+		Unchanged:    emptyIfNil(r.Unchanged),
+		MissingDirs:  emptyIfNil(r.MissingDirs),
+		Extraneous:   emptyIfNil(r.Extraneous),
+		Mapped:       r.Mapped,
+		RemoteLists:  r.RemoteLists,
+		RemoteStats:  r.RemoteStats,
+	})
+}
+
+// UnmarshalJSON decodes a report written by MarshalJSON.
+func (r *DiffReport) UnmarshalJSON(data []byte) error {
+	var doc diffReportJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("diff")
+	if err != nil {
+		return err
+	}
+	*r = DiffReport{
+		Added:       doc.Added,
+		Changed:     doc.Changed,
+		Unchanged:   doc.Unchanged,
+		MissingDirs: doc.MissingDirs,
+		Extraneous:  doc.Extraneous,
+		Mapped:      doc.Mapped,
+		RemoteLists: doc.RemoteLists,
+		RemoteStats: doc.RemoteStats,
+	}
+	return nil
+}
+
+// Merge adds the entries of other, a report on a disjoint part of the
+// tree. File lists are kept sorted; the order of MissingDirs and Extraneous
+// is kept per shard, so parents still come before children and contents
+// before their directory.
+func (r *DiffReport) Merge(other *DiffReport) {
+	r.Added = mergeSorted(r.Added, other.Added)
+	r.Changed = mergeSorted(r.Changed, other.Changed)
+	r.Unchanged = mergeSorted(r.Unchanged, other.Unchanged)
+	r.MissingDirs = append(r.MissingDirs, other.MissingDirs...)
+	r.Extraneous = append(r.Extraneous, other.Extraneous...)
+	for local, remote := range other.Mapped {
+		if r.Mapped == nil {
+			r.Mapped = make(map[string]string)
+		}
+		r.Mapped[local] = remote
+	}
+	r.RemoteLists += other.RemoteLists
+	r.RemoteStats += other.RemoteStats
+}
+
+func mergeSorted(a, b []string) []string {
+	merged := append(append([]string(nil), a...), b...)
+	sort.Strings(merged)
+	return merged
+}
+
+// Summary returns a one line description for notifications.
+func (r *DiffReport) Summary() string {
+	return fmt.Sprintf("%d added, %d changed, %d unchanged, %d missing dirs, %d extraneous",
+		len(r.Added), len(r.Changed), len(r.Unchanged), len(r.MissingDirs), len(r.Extraneous))
+}
+
+type preflightResultJSON struct {
+	Name       string          `json:"name"`
+	Status     PreflightStatus `json:"status"`
+	Detail     string          `json:"detail,omitempty"`
+	Error      *errorJSON      `json:"error,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+}
+
+type preflightReportJSON struct {
+	reportHeader
+	Passed     bool                  `json:"passed"`
+	StartedAt  string                `json:"startedAt,omitempty"`
+	DurationMs int64                 `json:"durationMs"`
+	Results    []preflightResultJSON `json:"results"`
+}
+
+// MarshalJSON encodes the report in the versioned report schema.
+func (r *PreflightReport) MarshalJSON() ([]byte, error) {
+	doc := preflightReportJSON{
+		reportHeader: reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "preflight"},
+		Passed:       r.Passed(),
+		StartedAt:    formatTime(r.Started),
+		DurationMs:   millis(r.Duration),
+		Results:      make([]preflightResultJSON, 0, len(r.Results)),
+	}
+	for _, result := range r.Results {
+		doc.Results = append(doc.Results, preflightResultJSON{
+			Name:       result.Name,
+			Status:     result.Status,
+			Detail:     result.Detail,
+			Error:      newErrorJSON(result.Err),
+			DurationMs: millis(result.Duration),
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a report written by MarshalJSON. Check errors come
+// back as *ReportedError.
+func (r *PreflightReport) UnmarshalJSON(data []byte) error {
+	var doc preflightReportJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("preflight")
+	if err != nil {
+		return err
+	}
+
+	started, err := parseTime(doc.StartedAt)
+	if err != nil {
+		return err
+	}
+	*r = PreflightReport{Started: started, Duration: fromMillis(doc.DurationMs)}
+	for _, result := range doc.Results {
+		r.Results = append(r.Results, PreflightResult{
+			Name:     result.Name,
+			Status:   result.Status,
+			Detail:   result.Detail,
+			Err:      result.Error.err(),
+			Duration: fromMillis(result.DurationMs),
+		})
+	}
+	return nil
+}
+
+// Merge appends the results of other, for instance the checks of another
+// endpoint.
+func (r *PreflightReport) Merge(other *PreflightReport) {
+	r.Results = append(r.Results, other.Results...)
+	if r.Started.IsZero() || (!other.Started.IsZero() && other.Started.Before(r.Started)) {
+		r.Started = other.Started
+	}
+	r.Duration = max(r.Duration, other.Duration)
+}
+
+// Summary returns a one line description for notifications.
+func (r *PreflightReport) Summary() string {
+	counts := map[PreflightStatus]int{}
+	var failed []string
+	for _, result := range r.Results {
+		counts[result.Status]++
+		if result.Status == PreflightFail {
+			failed = append(failed, result.Name)
+		}
+	}
+	summary := fmt.Sprintf("%d passed, %d failed, %d skipped", counts[PreflightPass], counts[PreflightFail], counts[PreflightSkip])
+	if len(failed) > 0 {
+		summary += " (" + strings.Join(failed, ", ") + ")"
+	}
+	return summary
+}
+
+type capabilityResultJSON struct {
+	Name      string     `json:"name"`
+	Supported bool       `json:"supported"`
+	Detail    string     `json:"detail,omitempty"`
+	Error     *errorJSON `json:"error,omitempty"`
+}
+
+type capabilityReportJSON struct {
+	reportHeader
+	Server          string                 `json:"server,omitempty"`
+	Dir             string                 `json:"dir"`
+	StartedAt       string                 `json:"startedAt,omitempty"`
+	DurationMs      int64                  `json:"durationMs"`
+	Results         []capabilityResultJSON `json:"results"`
+	TimePrecisionMs int64                  `json:"timePrecisionMs,omitempty"`
+}
+
+// MarshalJSON encodes the report in the versioned report schema.
+func (r *CapabilityReport) MarshalJSON() ([]byte, error) {
+	doc := capabilityReportJSON{
+		reportHeader:    reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "capabilities"},
+		Server:          r.Server,
+		Dir:             r.Dir,
+		StartedAt:       formatTime(r.Started),
+		DurationMs:      millis(r.Duration),
+		Results:         make([]capabilityResultJSON, 0, len(r.Results)),
+		TimePrecisionMs: millis(r.TimePrecision),
+	}
+	for _, result := range r.Results {
+		doc.Results = append(doc.Results, capabilityResultJSON{
+			Name:      result.Name,
+			Supported: result.Supported,
+			Detail:    result.Detail,
+			Error:     newErrorJSON(result.Err),
+		})
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a report written by MarshalJSON. Probe errors come
+// back as *ReportedError.
+func (r *CapabilityReport) UnmarshalJSON(data []byte) error {
+	var doc capabilityReportJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("capabilities")
+	if err != nil {
+		return err
+	}
+
+	started, err := parseTime(doc.StartedAt)
+	if err != nil {
+		return err
+	}
+	*r = CapabilityReport{
+		Server:        doc.Server,
+		Dir:           doc.Dir,
+		Started:       started,
+		Duration:      fromMillis(doc.DurationMs),
+		TimePrecision: fromMillis(doc.TimePrecisionMs),
+	}
+	for _, result := range doc.Results {
+		r.Results = append(r.Results, CapabilityResult{
+			Name:      result.Name,
+			Supported: result.Supported,
+			Detail:    result.Detail,
+			Err:       result.Error.err(),
+		})
+	}
+	return nil
+}
+
+type syncReportJSON struct {
+	reportHeader
+	Transferred []string              `json:"transferred"`
+	Added       []string              `json:"added"`
+	Skipped     []string              `json:"skipped"`
+	SkipReasons map[string]string     `json:"skipReasons,omitempty"`
+	Failed      []string              `json:"failed"`
+	Errors      map[string]*errorJSON `json:"errors,omitempty"`
+	Deleted     []string              `json:"deleted"`
+	DirsCreated int                   `json:"dirsCreated"`
+	DurationMs  int64                 `json:"durationMs"`
+	DryRun      bool                  `json:"dryRun,omitempty"`
+	Sample      *sampleJSON           `json:"sample,omitempty"`
+}
+
+// MarshalJSON encodes the report in the versioned report schema.
+func (r *SyncReport) MarshalJSON() ([]byte, error) {
+	doc := syncReportJSON{
+		reportHeader: reportHeader{SchemaVersion: ReportSchemaVersion, Kind: "sync"},
+		Transferred:  emptyIfNil(r.Transferred),
+		Added:        emptyIfNil(r.Added),
+		Skipped:      emptyIfNil(r.Skipped),
+		SkipReasons:  r.SkipReasons,
+		Failed:       emptyIfNil(r.Failed),
+		Deleted:      emptyIfNil(r.Deleted),
+		DirsCreated:  r.DirsCreated,
+		DurationMs:   millis(r.Duration),
+		DryRun:       r.DryRun,
+		Sample:       newSampleJSON(r.Sample),
+	}
+	for rel, err := range r.Errors {
+		if doc.Errors == nil {
+			doc.Errors = make(map[string]*errorJSON, len(r.Errors))
+		}
+		doc.Errors[rel] = newErrorJSON(err)
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a report written by MarshalJSON. Errors come back
+// as *ReportedError.
+func (r *SyncReport) UnmarshalJSON(data []byte) error {
+	var doc syncReportJSON
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	err = doc.check("sync")
+	if err != nil {
+		return err
+	}
+	*r = SyncReport{
+		Transferred: doc.Transferred,
+		Added:       doc.Added,
+		Skipped:     doc.Skipped,
+		SkipReasons: doc.SkipReasons,
+		Failed:      doc.Failed,
+		Deleted:     doc.Deleted,
+		DirsCreated: doc.DirsCreated,
+		Duration:    fromMillis(doc.DurationMs),
+		DryRun:      doc.DryRun,
+		Sample:      doc.Sample.sample(),
+	}
+	for rel, entry := range doc.Errors {
+		if r.Errors == nil {
+			r.Errors = make(map[string]error, len(doc.Errors))
+		}
+		r.Errors[rel] = entry.err()
+	}
+	return nil
+}
+
+// Merge adds the entries of other, a report on a disjoint part of the
+// tree. File lists are kept sorted; Deleted keeps its order per shard, so
+// files still come before their directory. The merged sync lasts as long as
+// the longest shard.
+func (r *SyncReport) Merge(other *SyncReport) {
+	r.Transferred = mergeSorted(r.Transferred, other.Transferred)
+	r.Added = mergeSorted(r.Added, other.Added)
+	r.Skipped = mergeSorted(r.Skipped, other.Skipped)
+	r.Failed = mergeSorted(r.Failed, other.Failed)
+	r.Deleted = append(r.Deleted, other.Deleted...)
+	for rel, reason := range other.SkipReasons {
+		if r.SkipReasons == nil {
+			r.SkipReasons = make(map[string]string)
+		}
+		r.SkipReasons[rel] = reason
+	}
+	for rel, err := range other.Errors {
+		if r.Errors == nil {
+			r.Errors = make(map[string]error)
+		}
+		r.Errors[rel] = err
+	}
+	r.DirsCreated += other.DirsCreated
+	r.Duration = max(r.Duration, other.Duration)
+	r.DryRun = r.DryRun || other.DryRun
+	r.Sample = mergeSample(r.Sample, other.Sample)
+}
+
+// Summary returns a one line description for notifications.
+func (r *SyncReport) Summary() string {
+	summary := fmt.Sprintf("%d transferred (%d added), %d skipped, %d failed, %d deleted, %d dirs created in %s",
+		len(r.Transferred), len(r.Added), len(r.Skipped), len(r.Failed), len(r.Deleted), r.DirsCreated, r.Duration.Round(time.Millisecond))
+	if r.DryRun {
+		summary += " (dry run)"
+	}
+	return summary
+}
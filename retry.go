@@ -0,0 +1,108 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy says how often and how patiently the client retries:
+// reconnects, and transfers restarted after transport failures.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, 1 means no retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled for every
+	// further one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter waits a random duration up to the backoff instead, so that
+	// many clients do not retry in lockstep.
+	Jitter bool
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy says otherwise.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 2 * time.Second, MaxBackoff: 30 * time.Second, Jitter: true}
+
+// NoRetries tries once, for latency-sensitive calls, see
+// WithTransferRetryPolicy.
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retry policy needs at least one attempt, got %d", p.MaxAttempts)
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("invalid retry backoff: initial %s, max %s", p.InitialBackoff, p.MaxBackoff)
+	}
+	return nil
+}
+
+// Backoff returns the waits between the attempts of the policy.
+func (p RetryPolicy) Backoff() Backoff {
+	return Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff, Jitter: p.Jitter}
+}
+
+// Backoff is the wait between the attempts of a retry loop, doubled from
+// Initial for every retry up to Max. With Jitter the wait is instead a
+// random duration up to that, full jitter, so that many clients failing at
+// once do not retry in lockstep.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Jitter  bool
+}
+
+// Delay returns the wait before retry n, the first retry being 1.
+func (b Backoff) Delay(n int) time.Duration {
+	d := b.Initial
+	for i := 1; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	if b.Jitter && d > 0 {
+		d = rand.N(d + 1)
+	}
+	return d
+}
+
+// Wait waits the delay before retry n, returning the error of ctx if it is
+// done first.
+func (b Backoff) Wait(ctx context.Context, n int) error {
+	return sleepContext(ctx, b.Delay(n))
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy for every retry loop of the
+// client: maxAttempts counts the first attempt, and the backoff doubles
+// from initialBackoff up to maxBackoff, randomized with jitter.
+func WithRetryPolicy(maxAttempts int, initialBackoff, maxBackoff time.Duration, jitter bool) Options {
+	return func(params *SFTPClientParams) error {
+		policy := RetryPolicy{MaxAttempts: maxAttempts, InitialBackoff: initialBackoff, MaxBackoff: maxBackoff, Jitter: jitter}
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		params.retryPolicy = &policy
+		params.record("WithRetryPolicy", fmt.Sprintf("%+v", policy))
+		return nil
+	}
+}
+
+// WithTransferRetryPolicy overrides the retry policy of the client for one
+// transfer, such as NoRetries on latency-sensitive paths.
+func WithTransferRetryPolicy(policy RetryPolicy) TransferOption {
+	return func(params *transferParams) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+		params.retryPolicy = &policy
+		return nil
+	}
+}
+
+// retryPolicy returns the retry policy of a transfer.
+func (client *SFTPClient) retryPolicy(params *transferParams) RetryPolicy {
+	if params.retryPolicy != nil {
+		return *params.retryPolicy
+	}
+	return client.params.RetryPolicy()
+}
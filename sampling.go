@@ -0,0 +1,148 @@
+package sftpc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// samplingParams configures the checksum verification of a sample of the
+// files a batch transferred, see WithVerificationSampling.
+type samplingParams struct {
+	fraction float64
+	min      int
+	seed     int64
+	byBytes  bool
+	flagOnly bool
+}
+
+// WithVerificationSampling verifies the checksum of a random sample of the
+// files transferred by UploadDir or DownloadDir once they are all done:
+// fraction of them, but at least minPerRun. A mismatch fails its item, and
+// with it the run, unless WithSamplingFlagOnly is set. The same seed over
+// the same files draws the same sample, to reproduce a failed run.
+func WithVerificationSampling(fraction float64, minPerRun int, seed int64) TransferOption {
+	return func(params *transferParams) error {
+		if fraction < 0 || fraction > 1 || math.IsNaN(fraction) {
+			return fmt.Errorf("sampling fraction must be between 0 and 1, got %v", fraction)
+		}
+		if minPerRun < 0 {
+			return fmt.Errorf("minimum sample size must not be negative, got %d", minPerRun)
+		}
+		if params.sampling == nil {
+			params.sampling = &samplingParams{}
+		}
+		params.sampling.fraction = fraction
+		params.sampling.min = minPerRun
+		params.sampling.seed = seed
+		return nil
+	}
+}
+
+// WithSamplingByBytes draws the verification sample weighted by file size
+// instead of giving every file the same chance.
+func WithSamplingByBytes() TransferOption {
+	return func(params *transferParams) error {
+		if params.sampling == nil {
+			params.sampling = &samplingParams{}
+		}
+		params.sampling.byBytes = true
+		return nil
+	}
+}
+
+// WithSamplingFlagOnly records sampled files that fail verification in
+// VerificationSample.Mismatched without failing their items.
+func WithSamplingFlagOnly() TransferOption {
+	return func(params *transferParams) error {
+		if params.sampling == nil {
+			params.sampling = &samplingParams{}
+		}
+		params.sampling.flagOnly = true
+		return nil
+	}
+}
+
+// VerificationSample records which transferred files were verified by
+// WithVerificationSampling, by remote path.
+type VerificationSample struct {
+	Seed    int64
+	ByBytes bool
+	Sampled []string
+	// Mismatched are the sampled files that failed verification.
+	Mismatched []string
+}
+
+// sampleItems returns the indexes of the k items drawn from candidates,
+// weighted by size when byBytes is set, in candidate order. Weighted draws
+// use the Efraimidis-Spirakis keys u^(1/w), compared as ln(u)/w to stay
+// precise for large files; empty files come last.
+func sampleItems(candidates []int, sizes []int64, k int, seed int64, byBytes bool) []int {
+	rng := rand.New(rand.NewSource(seed))
+	keys := make([]float64, len(candidates))
+	for i := range candidates {
+		u := rng.Float64()
+		switch {
+		case !byBytes:
+			keys[i] = u
+		case sizes[i] > 0:
+			keys[i] = math.Log(u) / float64(sizes[i])
+		default:
+			keys[i] = math.Inf(-1)
+		}
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return keys[order[a]] > keys[order[b]]
+	})
+	order = order[:k]
+	sort.Ints(order)
+
+	picked := make([]int, k)
+	for i, o := range order {
+		picked[i] = candidates[o]
+	}
+	return picked
+}
+
+// verifySample checks the sample of the transferred items of result that
+// params asks for.
+func (client *SFTPClient) verifySample(result *BatchResult, params *transferParams) {
+	sampling := params.sampling
+	if sampling == nil || params.verify || (sampling.fraction == 0 && sampling.min == 0) {
+		return
+	}
+
+	var candidates []int
+	var sizes []int64
+	for i, item := range result.Items {
+		if item.Status == StatusTransferred && item.Stats != nil {
+			candidates = append(candidates, i)
+			sizes = append(sizes, item.Stats.TotalSize)
+		}
+	}
+	k := max(sampling.min, int(math.Ceil(sampling.fraction*float64(len(candidates)))))
+	k = min(k, len(candidates))
+
+	sample := &VerificationSample{Seed: sampling.seed, ByBytes: sampling.byBytes}
+	result.Sample = sample
+	for _, i := range sampleItems(candidates, sizes, k, sampling.seed, sampling.byBytes) {
+		item := &result.Items[i]
+		sample.Sampled = append(sample.Sampled, item.RemotePath)
+
+		err := client.verifyDownload(item.RemotePath, item.LocalPath, params, item.Stats)
+		if err == nil {
+			continue
+		}
+		sample.Mismatched = append(sample.Mismatched, item.RemotePath)
+		if !sampling.flagOnly {
+			item.Status = StatusFailed
+			item.Err = fmt.Errorf("sampled verification failed: %w", err)
+		}
+	}
+}
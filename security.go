@@ -0,0 +1,81 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInsecureHostKeyPolicy is returned in strict mode when no host key
+	// verification option is configured.
+	ErrInsecureHostKeyPolicy = errors.New("insecure host key policy")
+
+	// ErrPasswordAuthNotAllowed is returned in strict mode when a password
+	// would be sent to the server without WithAllowPasswordAuth.
+	ErrPasswordAuthNotAllowed = errors.New("password authentication not allowed")
+)
+
+// hostKeyOptions are the options that verify the server host key.
+var hostKeyOptions = []string{"WithHostKeyCallback", "WithKnownHostsFile", "WithHostKeyFingerprint"}
+
+// WithStrictSecurity refuses configurations that skip host key
+// verification or send a password over the wire without
+// WithAllowPasswordAuth. Building with the sftpc_strict tag turns it on for
+// every client.
+func WithStrictSecurity() Options {
+	return func(params *SFTPClientParams) error {
+		params.strict = true
+		params.record("WithStrictSecurity", "true")
+		return nil
+	}
+}
+
+// WithAllowPasswordAuth allows password authentication in strict mode.
+// Without it, a password set next to a private key is only used as the key
+// passphrase.
+func WithAllowPasswordAuth() Options {
+	return func(params *SFTPClientParams) error {
+		params.allowPasswordAuth = true
+		params.record("WithAllowPasswordAuth", "true")
+		return nil
+	}
+}
+
+func (p *SFTPClientParams) hasKeys() bool {
+	return p.privateKeyPath != "" || len(p.privateKeyB64) > 0 || len(p.additionalKeys) > 0 || len(p.signers) > 0
+}
+
+// passwordAuth reports whether the password is offered to the server.
+func (p *SFTPClientParams) passwordAuth() bool {
+	if p.Password() == "" {
+		return false
+	}
+	return !p.StrictSecurity() || p.allowPasswordAuth
+}
+
+// checkSecurity enforces strict mode.
+func (p *SFTPClientParams) checkSecurity() error {
+	if !p.StrictSecurity() {
+		return nil
+	}
+
+	if p.isSet("WithInsecureHostKey") {
+		return fmt.Errorf("%w: strict mode refuses WithInsecureHostKey", ErrInsecureHostKeyPolicy)
+	}
+	verified := false
+	for _, name := range hostKeyOptions {
+		verified = verified || p.isSet(name)
+	}
+	if !verified {
+		return fmt.Errorf("%w: strict mode requires host key verification, set %s", ErrInsecureHostKeyPolicy, strings.Join(hostKeyOptions, " or "))
+	}
+
+	if p.password != "" && !p.hasKeys() && !p.allowPasswordAuth {
+		return fmt.Errorf("%w: strict mode refuses to send the password, set WithAllowPasswordAuth or use a private key", ErrPasswordAuthNotAllowed)
+	}
+	if p.isSet("WithKeyboardInteractivePassword") && !p.allowPasswordAuth {
+		return fmt.Errorf("%w: strict mode refuses to send the keyboard-interactive password, set WithAllowPasswordAuth", ErrPasswordAuthNotAllowed)
+	}
+	return nil
+}
@@ -1,12 +1,15 @@
 package sftpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"io/fs"
+	"net"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -14,238 +17,584 @@ import (
 )
 
 type SFTPClient struct {
-	params     *SFTPClientParams
+	params *SFTPClientParams
+
+	// connMu guards the connection, which a reconnect replaces while other
+	// goroutines use it; read it through sshConn, sftpConn and connection.
+	connMu     sync.RWMutex
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
+	channels   *channelPool
+
+	// reconnectMu guards the reconnect state: reconnects counts the
+	// reconnects done and reconnectErr is the outcome of the last one,
+	// inflight is the reconnect in progress. failures counts the failed
+	// reconnects since the connection was lost at lostAt. closed is set by
+	// Close until the next reconnect.
+	reconnectMu  sync.Mutex
+	closed       bool
+	reconnects   uint64
+	reconnectErr error
+	inflight     chan struct{}
+	failures     int
+	lostAt       time.Time
+
+	handles   *handleLimiter
+	bandwidth *bandwidth
+
+	// network and addr are dialed on connect; addr is also presented to
+	// the host key callback. Read addr through remoteAddr, WithHosts
+	// changes it on reconnect.
+	network  string
+	addr     string
+	fromConn bool
+	// fromSSH is set for clients created with NewSFTPClientFromSSH, which
+	// close their ssh.Client only with WithOwnConnection.
+	fromSSH bool
+	// hostIndex is the host of WithHosts that last connected.
+	hostIndex int
+
+	sleep        func(time.Duration)
+	now          func() time.Time
+	sleepContext func(ctx context.Context, d time.Duration) error
+
+	// appendProbed is the append strategy probed on the connection of
+	// appendConn.
+	appendMu     sync.Mutex
+	appendProbed AppendStrategy
+	appendConn   *sftp.Client
+
+	dirs dirCache
+
+	// credMu guards the credentials in params, which UpdateCredentials
+	// replaces while the client is in use.
+	credMu sync.RWMutex
 }
 
 func NewSFTPClient(opts ...Options) (*SFTPClient, error) {
-	var authMethods []ssh.AuthMethod
-	var signer ssh.Signer
+	return NewSFTPClientContext(context.Background(), opts...)
+}
 
+// NewSFTPClientContext is NewSFTPClient giving up dialing and the handshake
+// once ctx is done.
+func NewSFTPClientContext(ctx context.Context, opts ...Options) (*SFTPClient, error) {
 	params, err := newsSFTPClientParams(opts...)
 	if err != nil {
 		return nil, err
 	}
+	err = params.checkRequired(true)
+	if err != nil {
+		return nil, err
+	}
 
-	if params.Password() != "" {
-		authMethods = append(authMethods, ssh.Password(params.Password()))
+	client := newClient(params)
+	err = client.connect(ctx, params.DialTimeout())
+	if err != nil {
+		return nil, err
 	}
+	return client, nil
+}
 
-	if params.PrivateKeyPath() != "" {
-		key, err := os.ReadFile(params.PrivateKeyPath())
-		if err != nil {
-			return nil, fmt.Errorf("failed to read private key: %w", err)
-		}
+// NewSFTPClientFromConn runs SSH and SFTP over an already established
+// connection, such as a tunnel that has no TCP address to dial. addr is the
+// address presented to the host key callback. The client owns conn from
+// then on, closing it exactly once, also when the handshake fails. Without
+// WithRedialFunc the client cannot reconnect once conn breaks.
+func NewSFTPClientFromConn(conn net.Conn, addr string, opts ...Options) (*SFTPClient, error) {
+	params, err := newsSFTPClientParams(opts...)
+	if err == nil {
+		err = params.checkRequired(false)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-		if params.Password() != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(params.Password()))
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse private key with passphrase: %w", err)
-			}
-		} else {
-			signer, err = ssh.ParsePrivateKey(key)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse private key: %w", err)
-			}
+	client := newClient(params)
+	client.fromConn = true
+	client.addr = addr
 
-		}
+	err = client.fetchCredentials(context.Background())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = client.attach(&onceCloseConn{Conn: conn}, params.DialTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+// NewSFTPClientFromSSH opens SFTP on an established ssh.Client, such as one
+// also used for exec sessions. Close only closes the SFTP subsystem and
+// leaves sshClient to the caller, unless WithOwnConnection. The client
+// reconnects through WithSSHReconnectFunc; without it, reconnecting fails.
+// Credential and dial options do not apply.
+func NewSFTPClientFromSSH(sshClient *ssh.Client, opts ...Options) (*SFTPClient, error) {
+	if sshClient == nil {
+		return nil, fmt.Errorf("ssh.Client is nil")
+	}
+	params, err := newsSFTPClientParams(opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	} else if len(params.PrivateKeyB64()) > 0 {
-		if params.Password() != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(params.PrivateKeyB64(), []byte(params.Password()))
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse private key with passphrase: %w", err)
-			}
-		} else {
-			signer, err = ssh.ParsePrivateKey(params.PrivateKeyB64())
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse private key: %w", err)
-			}
-		}
+	if params.User() == "" {
+		params.SetUser(sshClient.User())
+	}
+
+	client := newClient(params)
+	client.fromSSH = true
+	client.network = sshClient.RemoteAddr().Network()
+	client.addr = sshClient.RemoteAddr().String()
 
-		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	err = client.attachSSH(sshClient)
+	if err != nil {
+		return nil, err
 	}
+	return client, nil
+}
 
-	sshConfig := &ssh.ClientConfig{
-		User:            params.User(),
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         120 * time.Second,
+func newClient(params *SFTPClientParams) *SFTPClient {
+	client := &SFTPClient{
+		params:       params,
+		network:      "tcp",
+		addr:         net.JoinHostPort(params.Host(), params.Port()),
+		handles:      newHandleLimiter(params.MaxOpenHandles()),
+		bandwidth:    newBandwidth(params),
+		sleep:        time.Sleep,
+		now:          time.Now,
+		sleepContext: sleepContext,
+	}
+	if params.UnixSocket() != "" {
+		client.network = "unix"
+		client.addr = params.UnixSocket()
+	}
+	return client
+}
+
+// onceCloseConn makes repeated closes of a connection, by the SSH transport
+// and by Close, reach the underlying connection only once.
+type onceCloseConn struct {
+	net.Conn
+	once sync.Once
+	err  error
+}
+
+func (c *onceCloseConn) Close() error {
+	c.once.Do(func() {
+		c.err = c.Conn.Close()
+	})
+	return c.err
+}
+
+// dialContext opens the transport connection, through the redial function
+// when one is configured.
+func (client *SFTPClient) dialContext(ctx context.Context) (net.Conn, error) {
+	if redial := client.params.RedialFunc(); redial != nil {
+		conn, err := redial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial: %w", err)
+		}
+		return &onceCloseConn{Conn: conn}, nil
+	}
+	if dial := client.params.Dialer(); dial != nil {
+		conn, err := dial(client.network, client.remoteAddr())
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial: %w", err)
+		}
+		return &onceCloseConn{Conn: conn}, nil
+	}
+	if client.fromConn {
+		return nil, fmt.Errorf("failed to dial: client was created from a connection without WithRedialFunc or WithDialer")
+	}
+	if client.params.SOCKS5Proxy() != "" {
+		return client.dialSOCKS5(ctx)
 	}
 
-	addr := fmt.Sprintf("%s:%s", params.Host(), params.Port())
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
+	// Keepalive is set below, together with the other socket options
+	dialer := net.Dialer{KeepAlive: -1}
+	conn, err := dialer.DialContext(ctx, client.network, client.remoteAddr())
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial: %w", err)
 	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		err = client.params.applyTCPOptions(tcpConn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
 
-	sftpClient, err := sftp.NewClient(sshClient)
+// applyTCPOptions sets keepalive and nodelay on a connection made by the
+// default dialer, before the SSH handshake.
+func (p *SFTPClientParams) applyTCPOptions(conn *net.TCPConn) error {
+	period := p.TCPKeepAlive()
+	err := conn.SetKeepAlive(period > 0)
+	if err == nil && period > 0 {
+		err = conn.SetKeepAlivePeriod(period)
+	}
+	if err == nil {
+		err = conn.SetNoDelay(p.TCPNoDelay())
+	}
 	if err != nil {
-		sshClient.Close()
-		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
+		return fmt.Errorf("failed to set TCP options: %w", err)
 	}
+	return nil
+}
 
-	return &SFTPClient{
-		params:     params,
-		sshClient:  sshClient,
-		sftpClient: sftpClient,
-	}, nil
+// ConnectionInfo describes the current connection.
+type ConnectionInfo struct {
+	// Network is "tcp", "unix", "conn" for clients created from a
+	// connection, or "ssh" for clients created from an ssh.Client.
+	Network string
+	// RemoteAddr is host:port, the socket path for unix sockets, or the
+	// handshake address for clients created from a connection.
+	RemoteAddr    string
+	User          string
+	ServerVersion string
 }
 
-func (client *SFTPClient) Close() {
-	if client.sftpClient != nil {
-		client.sftpClient.Close()
+// ConnectionInfo reports where the client is connected to.
+func (client *SFTPClient) ConnectionInfo() ConnectionInfo {
+	info := ConnectionInfo{
+		Network:    client.network,
+		RemoteAddr: client.remoteAddr(),
+		User:       client.params.User(),
 	}
-	if client.sshClient != nil {
-		client.sshClient.Close()
+	if client.fromConn {
+		info.Network = "conn"
 	}
+	if client.fromSSH {
+		info.Network = "ssh"
+	}
+	if sshClient := client.sshConn(); sshClient != nil {
+		info.ServerVersion = string(sshClient.ServerVersion())
+	}
+	return info
 }
 
-func (client *SFTPClient) UploadFile(localPath, remotePath string) error {
-	if client == nil {
-		return fmt.Errorf("SFTPClient is nil")
+// connect dials and sets up the SSH and SFTP clients, within timeout unless
+// it is zero, and before ctx is done. With WithHosts it starts from the host
+// that last worked, each host getting the whole timeout.
+func (client *SFTPClient) connect(ctx context.Context, timeout time.Duration) error {
+	hosts := client.params.Hosts()
+	if len(hosts) < 2 || client.fromConn || client.fromSSH {
+		return client.connectAddr(ctx, timeout)
+	}
+
+	var errs []error
+	for i := range hosts {
+		n := (client.hostIndex + i) % len(hosts)
+		addr := net.JoinHostPort(hosts[n], client.params.Port())
+		client.setRemoteAddr(addr)
+		err := client.connectAddr(ctx, timeout)
+		if err == nil {
+			client.hostIndex = n
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
 	}
+	client.setRemoteAddr(net.JoinHostPort(hosts[client.hostIndex], client.params.Port()))
+	return fmt.Errorf("failed to connect to all %d hosts: %w", len(hosts), errors.Join(errs...))
+}
 
-	err := client.ensureConnected()
-	if err != nil {
-		return fmt.Errorf("failed to reconnect: %w", err)
+func (client *SFTPClient) remoteAddr() string {
+	client.connMu.RLock()
+	defer client.connMu.RUnlock()
+	return client.addr
+}
+
+func (client *SFTPClient) setRemoteAddr(addr string) {
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+	client.addr = addr
+}
+
+// connectAddr connects to the current remote address.
+func (client *SFTPClient) connectAddr(ctx context.Context, timeout time.Duration) error {
+	// The handshake gets no more time than ctx has left
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); timeout <= 0 || left < timeout {
+			timeout = max(left, time.Nanosecond)
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	_, err = os.Stat(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to get local file info: %w", err)
+	if client.fromSSH {
+		return client.reconnectSSH(ctx)
 	}
 
-	remoteFileInfo, err := client.sftpClient.Stat(remotePath)
-	var remoteFileSize int64
-	if err == nil {
-		remoteFileSize = remoteFileInfo.Size()
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to get remote file info: %w", err)
+	err := client.fetchCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	conn, err := client.dialContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Cancelling ctx interrupts the handshake, not the connection after it
+	var mu sync.Mutex
+	handshaking, interrupted := true, false
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if handshaking {
+			interrupted = true
+			conn.SetDeadline(time.Now())
+		}
+	})
+	err = client.attach(conn, timeout)
+	stop()
+	mu.Lock()
+	handshaking = false
+	mu.Unlock()
+	switch {
+	case !interrupted:
+		return err
+	case err == nil:
+		client.closeConn()
+		return fmt.Errorf("failed to dial: %w", ctx.Err())
+	default:
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
 	}
+}
+
+// attach runs SSH and SFTP over conn, closing it on failure.
+func (client *SFTPClient) attach(conn net.Conn, timeout time.Duration) error {
+	var u unwinder
+	defer u.unwind()
+	u.add(func() { conn.Close() })
 
-	srcFile, err := os.Open(localPath)
+	client.credMu.RLock()
+	sshConfig, err := client.params.sshClientConfig(timeout)
+	client.credMu.RUnlock()
 	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
+		return err
 	}
-	defer srcFile.Close()
 
-	_, err = srcFile.Seek(remoteFileSize, io.SeekStart)
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, client.remoteAddr(), sshConfig)
 	if err != nil {
-		return fmt.Errorf("failed to seek in local file: %w", err)
+		// x/crypto/ssh has no typed error for rejected credentials
+		if strings.Contains(err.Error(), "ssh: unable to authenticate") {
+			return fmt.Errorf("failed to dial: %w: %w", ErrAuthFailed, err)
+		}
+		return fmt.Errorf("failed to dial: %w", err)
 	}
+	conn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	u.add(func() { sshClient.Close() })
 
-	var dstFile *sftp.File
-	//if remoteFileSize > 0 {
-	dstFile, err = client.sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
-	//} else {
-	//	dstFile, err = client.sftpClient.Create(remotePath)
-	//}
+	err = client.attachSSH(sshClient)
 	if err != nil {
-		return fmt.Errorf("failed to open or create remote file: %w", err)
+		return err
 	}
-	defer dstFile.Close()
+	u.release()
+	return nil
+}
 
-	_, err = io.Copy(dstFile, srcFile)
+// attachSSH runs SFTP over sshClient, leaving sshClient open on failure.
+func (client *SFTPClient) attachSSH(sshClient *ssh.Client) error {
+	var u unwinder
+	defer u.unwind()
+
+	sftpClient, err := newSFTPChannel(sshClient)
 	if err != nil {
-		return fmt.Errorf("failed to copy file to remote: %w", err)
+		return fmt.Errorf("failed to create SFTP client: %w", err)
 	}
+	u.add(func() { sftpClient.Close() })
 
+	var channels *channelPool
+	if n := client.params.ChannelsPerConnection(); n > 1 {
+		channels, err = openChannels(sshClient, sftpClient, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.release()
+	client.connMu.Lock()
+	client.channels = channels
+	client.sshClient = sshClient
+	client.sftpClient = sftpClient
+	client.connMu.Unlock()
+	client.dirs.reset()
 	return nil
 }
 
-func (client *SFTPClient) DownloadFile(remotePath, localPath string) error {
-	if client == nil {
-		return fmt.Errorf("SFTPClient is nil")
+// reconnectSSH replaces the ssh.Client of a client created with
+// NewSFTPClientFromSSH by one from WithSSHReconnectFunc.
+func (client *SFTPClient) reconnectSSH(ctx context.Context) error {
+	reconnect := client.params.SSHReconnectFunc()
+	if reconnect == nil {
+		return fmt.Errorf("failed to dial: client was created from an ssh.Client without WithSSHReconnectFunc")
 	}
-
-	// Ensure connection before download
-	err := client.ensureConnectedWithRetries(3)
+	sshClient, err := reconnect(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to reconnect: %w", err)
+		return fmt.Errorf("failed to dial: %w", err)
 	}
-
-	// Get remote file info
-	remoteFileInfo, err := client.sftpClient.Stat(remotePath)
+	if sshClient == nil {
+		return fmt.Errorf("failed to dial: SSH reconnect function returned no client")
+	}
+	err = client.attachSSH(sshClient)
 	if err != nil {
-		// Skip permission denied errors
-		if os.IsPermission(err) {
-			log.Printf("Permission denied for file: %s", remotePath)
-			return nil // Skip this file
-		}
-		return fmt.Errorf("failed to get remote file info: %w", err)
+		client.closeSSH(sshClient)
+		return err
 	}
-	remoteFileSize := remoteFileInfo.Size()
+	return nil
+}
 
-	// Get local file info to resume download
-	var localFileSize int64
-	localFileInfo, err := os.Stat(localPath)
-	if err == nil {
-		localFileSize = localFileInfo.Size()
-		if localFileSize == remoteFileSize {
-			log.Printf("File already fully downloaded: %s", localPath)
-			return nil // File is fully downloaded
+// closeSSH closes sshClient unless it is borrowed from the caller of
+// NewSFTPClientFromSSH.
+func (client *SFTPClient) closeSSH(sshClient *ssh.Client) {
+	if sshClient != nil && (!client.fromSSH || client.params.OwnConnection()) {
+		sshClient.Close()
+	}
+}
+
+// sshClientConfig builds the ssh client configuration shared by the initial
+// dial and every reconnect.
+func (p *SFTPClientParams) sshClientConfig(timeout time.Duration) (*ssh.ClientConfig, error) {
+	var authMethods []ssh.AuthMethod
+	var signers []ssh.Signer
+
+	if p.passwordAuth() {
+		authMethods = append(authMethods, ssh.Password(p.Password()))
+	}
+
+	if p.PrivateKeyPath() != "" {
+		key, err := os.ReadFile(p.PrivateKeyPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		signer, err := p.parsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else if len(p.PrivateKeyB64()) > 0 {
+		signer, err := p.parsePrivateKey(p.PrivateKeyB64())
+		if err != nil {
+			return nil, err
 		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to get local file info: %w", err)
+		signers = append(signers, signer)
 	}
 
-	// Open the remote file
-	remoteFile, err := client.sftpClient.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to open remote file: %w", err)
+	for _, key := range p.AdditionalKeys() {
+		signer, err := p.parsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
 	}
-	defer remoteFile.Close()
+	signers = append(signers, p.Signers()...)
 
-	// Seek in the remote file to resume download
-	_, err = remoteFile.Seek(localFileSize, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("failed to seek in remote file: %w", err)
+	if len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
 	}
 
-	// Open the local file for append or create if it doesn't exist
-	var localFile *os.File
-	if localFileSize > 0 {
-		localFile, err = os.OpenFile(localPath, os.O_WRONLY|os.O_APPEND, 0644)
-	} else {
-		localFile, err = os.Create(localPath)
+	if cb := p.KeyboardInteractive(); cb != nil {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(cb))
 	}
-	if err != nil {
-		return fmt.Errorf("failed to open or create local file: %w", err)
+
+	sshConfig := &ssh.ClientConfig{
+		User:            p.User(),
+		Auth:            authMethods,
+		HostKeyCallback: p.HostKeyCallback(),
+		Timeout:         timeout,
 	}
-	defer localFile.Close()
+	sshConfig.RekeyThreshold = p.RekeyThreshold()
 
-	// Retry download loop
-	for retries := 0; retries < 3; retries++ {
-		_, err = io.Copy(localFile, remoteFile)
+	return sshConfig, nil
+}
+
+// parsePrivateKey parses a PEM private key, using the passphrase or else the
+// password to decrypt it when one is set.
+func (p *SFTPClientParams) parsePrivateKey(key []byte) (ssh.Signer, error) {
+	passphrase := p.PrivateKeyPassphrase()
+	if passphrase == "" {
+		passphrase = p.Password()
+	}
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
 		if err != nil {
-			if retries < 2 {
-				log.Printf("Download failed, retrying... attempt %d", retries+1)
-				time.Sleep(5 * time.Second)
-				err = client.ensureConnectedWithRetries(3) // Ensure reconnection before retry
-				if err != nil {
-					return fmt.Errorf("failed to reconnect: %w", err)
-				}
-			} else {
-				return fmt.Errorf("failed to copy file to local after 3 retries: %w", err)
-			}
-		} else {
-			break // Download successful, exit retry loop
+			return nil, fmt.Errorf("failed to parse private key with passphrase: %w", err)
 		}
+		return signer, nil
 	}
 
-	log.Printf("Resumed and downloaded file: %s", localPath)
-	return nil
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+func (client *SFTPClient) Close() {
+	client.reconnectMu.Lock()
+	client.closed = true
+	client.reconnectMu.Unlock()
+	client.closeConn()
+}
+
+// closeConn closes the current connection, leaving a borrowed ssh.Client
+// open.
+func (client *SFTPClient) closeConn() {
+	sshClient, sftpClient, channels := client.connection()
+	if channels != nil {
+		channels.closeExtra()
+	}
+	if sftpClient != nil {
+		sftpClient.Close()
+	}
+	client.closeSSH(sshClient)
+}
+
+// connection returns the current connection: the SSH client, the primary
+// SFTP client and the channel pool, nil with a single channel.
+func (client *SFTPClient) connection() (*ssh.Client, *sftp.Client, *channelPool) {
+	client.connMu.RLock()
+	defer client.connMu.RUnlock()
+	return client.sshClient, client.sftpClient, client.channels
+}
+
+// sftpConn returns the primary SFTP client of the current connection.
+func (client *SFTPClient) sftpConn() *sftp.Client {
+	_, sftpClient, _ := client.connection()
+	return sftpClient
+}
+
+// sshConn returns the SSH client of the current connection.
+func (client *SFTPClient) sshConn() *ssh.Client {
+	sshClient, _, _ := client.connection()
+	return sshClient
 }
 
 func (client *SFTPClient) RemoveFile(remotePath string) error {
+	return client.RemoveFileContext(context.Background(), remotePath)
+}
+
+// RemoveFileContext is RemoveFile giving up once ctx is done.
+func (client *SFTPClient) RemoveFileContext(ctx context.Context, remotePath string) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
-	err := client.sftpClient.Remove(remotePath)
+	ctx, done := client.trace(ctx, "RemoveFile", remotePath)
+	defer done(&err)
+	err = client.withConnContext(ctx, func() error {
+		return client.sftpConn().Remove(remotePath)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to remove remote file: %w", err)
 	}
@@ -254,10 +603,19 @@ func (client *SFTPClient) RemoveFile(remotePath string) error {
 }
 
 func (client *SFTPClient) MoveFile(oldPath, newPath string) error {
+	return client.MoveFileContext(context.Background(), oldPath, newPath)
+}
+
+// MoveFileContext is MoveFile giving up once ctx is done.
+func (client *SFTPClient) MoveFileContext(ctx context.Context, oldPath, newPath string) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
-	err := client.sftpClient.Rename(oldPath, newPath)
+	ctx, done := client.trace(ctx, "MoveFile", oldPath)
+	defer done(&err)
+	err = client.withConnContext(ctx, func() error {
+		return client.sftpConn().Rename(oldPath, newPath)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to move remote file: %w", err)
 	}
@@ -265,10 +623,21 @@ func (client *SFTPClient) MoveFile(oldPath, newPath string) error {
 }
 
 func (client *SFTPClient) List(remotePath string) ([]os.FileInfo, error) {
+	return client.ListContext(context.Background(), remotePath)
+}
+
+// ListContext is List giving up once ctx is done.
+func (client *SFTPClient) ListContext(ctx context.Context, remotePath string) (_ []os.FileInfo, err error) {
 	if client == nil {
 		return nil, fmt.Errorf("SFTPClient is nil")
 	}
-	files, err := client.sftpClient.ReadDir(remotePath)
+	ctx, done := client.trace(ctx, "List", remotePath)
+	defer done(&err)
+	return client.list(ctx, remotePath)
+}
+
+func (client *SFTPClient) list(ctx context.Context, remotePath string) ([]os.FileInfo, error) {
+	files, err := client.readDirContext(ctx, remotePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
@@ -276,21 +645,67 @@ func (client *SFTPClient) List(remotePath string) ([]os.FileInfo, error) {
 }
 
 func (client *SFTPClient) MakeDir(remotePath string) error {
+	return client.MakeDirContext(context.Background(), remotePath)
+}
+
+// MakeDirContext is MakeDir giving up once ctx is done.
+func (client *SFTPClient) MakeDirContext(ctx context.Context, remotePath string) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "MakeDir", remotePath)
+	defer done(&err)
+	err = client.withConnContext(ctx, func() error {
+		return client.sftpConn().Mkdir(remotePath)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return nil
+}
+
+// MakeDirAll creates remotePath and its missing parents. Directories that
+// already exist, or are created concurrently by another writer, are not an
+// error; a parent that is a file is.
+func (client *SFTPClient) MakeDirAll(remotePath string) error {
+	return client.MakeDirAllContext(context.Background(), remotePath)
+}
+
+// MakeDirAllContext is MakeDirAll giving up once ctx is done.
+func (client *SFTPClient) MakeDirAllContext(ctx context.Context, remotePath string) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
-	err := client.sftpClient.Mkdir(remotePath)
+	ctx, done := client.trace(ctx, "MakeDirAll", remotePath)
+	defer done(&err)
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+	err = client.mkdirAll(remotePath)
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 	return nil
 }
 
+// RemoveDir removes an empty directory. A directory that still has entries
+// fails with ErrDirectoryNotEmpty.
 func (client *SFTPClient) RemoveDir(remotePath string) error {
+	return client.RemoveDirContext(context.Background(), remotePath)
+}
+
+// RemoveDirContext is RemoveDir giving up once ctx is done.
+func (client *SFTPClient) RemoveDirContext(ctx context.Context, remotePath string) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
-	err := client.sftpClient.RemoveDirectory(remotePath)
+	ctx, done := client.trace(ctx, "RemoveDir", remotePath)
+	defer done(&err)
+	client.dirs.forget(remotePath)
+	err = client.withConnContext(ctx, func() error {
+		return client.removeEmptyDir(remotePath)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to remove directory: %w", err)
 	}
@@ -298,10 +713,20 @@ func (client *SFTPClient) RemoveDir(remotePath string) error {
 }
 
 func (client *SFTPClient) MoveDir(oldPath, newPath string) error {
+	return client.MoveDirContext(context.Background(), oldPath, newPath)
+}
+
+// MoveDirContext is MoveDir giving up once ctx is done.
+func (client *SFTPClient) MoveDirContext(ctx context.Context, oldPath, newPath string) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
-	err := client.sftpClient.Rename(oldPath, newPath)
+	ctx, done := client.trace(ctx, "MoveDir", oldPath)
+	defer done(&err)
+	client.dirs.forget(oldPath)
+	err = client.withConnContext(ctx, func() error {
+		return client.sftpConn().Rename(oldPath, newPath)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to move directory: %w", err)
 	}
@@ -309,10 +734,17 @@ func (client *SFTPClient) MoveDir(oldPath, newPath string) error {
 }
 
 func (client *SFTPClient) ListDirs(remotePath string) ([]os.FileInfo, error) {
+	return client.ListDirsContext(context.Background(), remotePath)
+}
+
+// ListDirsContext is ListDirs giving up once ctx is done.
+func (client *SFTPClient) ListDirsContext(ctx context.Context, remotePath string) (_ []os.FileInfo, err error) {
 	if client == nil {
 		return nil, fmt.Errorf("SFTPClient is nil")
 	}
-	dirs, err := client.sftpClient.ReadDir(remotePath)
+	ctx, done := client.trace(ctx, "ListDirs", remotePath)
+	defer done(&err)
+	dirs, err := client.readDirContext(ctx, remotePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
@@ -327,10 +759,17 @@ func (client *SFTPClient) ListDirs(remotePath string) ([]os.FileInfo, error) {
 }
 
 func (client *SFTPClient) ListFiles(remotePath string) ([]os.FileInfo, error) {
+	return client.ListFilesContext(context.Background(), remotePath)
+}
+
+// ListFilesContext is ListFiles giving up once ctx is done.
+func (client *SFTPClient) ListFilesContext(ctx context.Context, remotePath string) (_ []os.FileInfo, err error) {
 	if client == nil {
 		return nil, fmt.Errorf("SFTPClient is nil")
 	}
-	files, err := client.sftpClient.ReadDir(remotePath)
+	ctx, done := client.trace(ctx, "ListFiles", remotePath)
+	defer done(&err)
+	files, err := client.readDirContext(ctx, remotePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
@@ -344,158 +783,323 @@ func (client *SFTPClient) ListFiles(remotePath string) ([]os.FileInfo, error) {
 	return result, nil
 }
 
-func (client *SFTPClient) ReConnect() error {
-	var authMethods []ssh.AuthMethod
-	var signerIn ssh.Signer
+// ListFilesSince lists the files of remotePath modified at or after since.
+func (client *SFTPClient) ListFilesSince(remotePath string, since time.Time) ([]os.FileInfo, error) {
+	return client.ListFilesSinceContext(context.Background(), remotePath, since)
+}
 
-	// Close previous connections if they exist
-	if client.sftpClient != nil {
-		client.sftpClient.Close()
+// ListFilesSinceContext is ListFilesSince giving up once ctx is done.
+func (client *SFTPClient) ListFilesSinceContext(ctx context.Context, remotePath string, since time.Time) (_ []os.FileInfo, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
 	}
-	if client.sshClient != nil {
-		client.sshClient.Close()
+	ctx, done := client.trace(ctx, "ListFilesSince", remotePath)
+	defer done(&err)
+	return client.listFilesWhere(ctx, remotePath, func(info os.FileInfo) bool {
+		return !info.ModTime().Before(since)
+	})
+}
+
+// ListFilesWhere lists the files of remotePath, like ListFiles, keeping
+// those pred returns true for. pred sees the entries of the listing; no file
+// is looked up on its own.
+func (client *SFTPClient) ListFilesWhere(remotePath string, pred func(os.FileInfo) bool) ([]os.FileInfo, error) {
+	return client.ListFilesWhereContext(context.Background(), remotePath, pred)
+}
+
+// ListFilesWhereContext is ListFilesWhere giving up once ctx is done.
+func (client *SFTPClient) ListFilesWhereContext(ctx context.Context, remotePath string, pred func(os.FileInfo) bool) (_ []os.FileInfo, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	if pred == nil {
+		return nil, fmt.Errorf("list predicate is nil")
 	}
+	ctx, done := client.trace(ctx, "ListFilesWhere", remotePath)
+	defer done(&err)
+	return client.listFilesWhere(ctx, remotePath, pred)
+}
 
-	if client.params.Password() != "" {
-		authMethods = append(authMethods, ssh.Password(client.params.Password()))
+// listFilesWhere lists the files of remotePath pred returns true for.
+func (client *SFTPClient) listFilesWhere(ctx context.Context, remotePath string, pred func(os.FileInfo) bool) ([]os.FileInfo, error) {
+	files, err := client.readDirContext(ctx, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
 
-	if client.params.PrivateKeyPath() != "" {
-		key, err := os.ReadFile(client.params.PrivateKeyPath())
-		if err != nil {
-			return fmt.Errorf("failed to read private key: %w", err)
+	var result []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && pred(file) {
+			result = append(result, file)
 		}
+	}
+	return result, nil
+}
 
-		if client.params.Password() != "" {
-			signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(client.params.Password()))
-			if err != nil {
-				return fmt.Errorf("failed to parse private key with passphrase: %w", err)
-			}
-			signerIn = signer
-		} else {
-			signer, err := ssh.ParsePrivateKey(key)
-			if err != nil {
-				return fmt.Errorf("failed to parse private key: %w", err)
-			}
-			signerIn = signer
-
-		}
+// ReConnect replaces the connection by a new one. Concurrent calls share a
+// single reconnect.
+func (client *SFTPClient) ReConnect() error {
+	return client.ReConnectContext(context.Background())
+}
 
-		authMethods = append(authMethods, ssh.PublicKeys(signerIn))
+// ReConnectContext is ReConnect giving up once ctx is done.
+func (client *SFTPClient) ReConnectContext(ctx context.Context) (err error) {
+	ctx, done := client.trace(ctx, "ReConnect", "")
+	defer done(&err)
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	return deadlineError(ctx, client.reconnect(ctx, client.reconnectCount(), nil))
+}
 
-	} else if len(client.params.PrivateKeyB64()) > 0 {
-		if client.params.Password() != "" {
-			signer, err := ssh.ParsePrivateKeyWithPassphrase(client.params.PrivateKeyB64(), []byte(client.params.Password()))
-			if err != nil {
-				return fmt.Errorf("failed to parse private key with passphrase: %w", err)
-			}
-			signerIn = signer
-		} else {
-			signer, err := ssh.ParsePrivateKey(client.params.PrivateKeyB64())
-			if err != nil {
-				return fmt.Errorf("failed to parse private key: %w", err)
-			}
-			signerIn = signer
+// reconnect replaces the connection, unless another reconnect finished
+// since the caller saw seen of them; the caller then shares its outcome, so
+// that goroutines finding the same broken connection reconnect once. cause
+// is why the connection was found broken, nil for explicit reconnects. The
+// caller stops waiting for, or dialing, once ctx is done. The connection
+// hooks run outside of reconnectMu.
+func (client *SFTPClient) reconnect(ctx context.Context, seen uint64, cause error) error {
+	client.reconnectMu.Lock()
+	for client.inflight != nil && client.reconnects == seen {
+		inflight := client.inflight
+		client.reconnectMu.Unlock()
+		select {
+		case <-inflight:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: failed to wait for reconnect: %w", ErrNotConnected, ctx.Err())
 		}
+		client.reconnectMu.Lock()
+	}
+	if client.reconnects != seen {
+		err := client.reconnectErr
+		client.reconnectMu.Unlock()
+		return err
+	}
+	inflight := make(chan struct{})
+	client.inflight = inflight
+	if client.failures == 0 {
+		client.lostAt = client.now()
+	}
+	attempt, lostAt := client.failures+1, client.lostAt
+	client.reconnectMu.Unlock()
 
-		authMethods = append(authMethods, ssh.PublicKeys(signerIn))
+	hooks := client.params.ConnectionHooks()
+	if cause != nil && attempt == 1 && hooks.OnDisconnect != nil {
+		hooks.OnDisconnect(cause)
+	}
+	if hooks.OnReconnectAttempt != nil {
+		hooks.OnReconnectAttempt(attempt)
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User:            client.params.User(),
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         180 * time.Second,
+	client.params.Metrics().IncReconnect()
+	client.closeConn()
+	err := client.connect(ctx, client.params.DialTimeout())
+	// A handshake cut short at the deadline of ctx can fail before ctx
+	// itself is done, wait for it so that callers see why
+	if deadline, ok := ctx.Deadline(); ok && err != nil && !time.Now().Before(deadline) {
+		<-ctx.Done()
 	}
 
-	addr := fmt.Sprintf("%s:%s", client.params.Host(), client.params.Port())
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
 	if err != nil {
-		return fmt.Errorf("failed to dial: %w", err)
+		err = fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	client.reconnectMu.Lock()
+	client.reconnectErr = err
+	client.reconnects++
+	client.failures = 0
+	if err != nil {
+		client.failures = attempt
+	} else {
+		client.closed = false
+	}
+	client.inflight = nil
+	client.reconnectMu.Unlock()
+	close(inflight)
+
+	if err == nil && hooks.OnReconnected != nil {
+		hooks.OnReconnected(client.now().Sub(lostAt))
+	}
+	return err
+}
+
+// withConn runs op, which uses the current connection. When op fails with a
+// transport error, as the first operation after a silent drop does, the
+// client reconnects and runs op once more, unless it was closed.
+func (client *SFTPClient) withConn(op func() error) error {
+	return client.withConnContext(context.Background(), op)
+}
+
+// withConnContext is withConn for an operation running under ctx, which is
+// not started once ctx is done.
+func (client *SFTPClient) withConnContext(ctx context.Context, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	client.reconnectMu.Lock()
+	seen, closed := client.reconnects, client.closed
+	client.reconnectMu.Unlock()
+	err := op()
+	if err == nil || closed || ClassifyError(err) != ErrorClassTransport {
+		return err
 	}
 
-	sftpClient, err := sftp.NewClient(sshClient)
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	err = client.reconnect(ctx, seen, err)
 	if err != nil {
-		sshClient.Close()
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+		return deadlineError(ctx, fmt.Errorf("failed to reconnect: %w", err))
 	}
+	return deadlineError(ctx, op())
+}
 
-	client.sshClient = sshClient
-	client.sftpClient = sftpClient
+// readDirConn is readDir through withConn.
+func (client *SFTPClient) readDirConn(p string) ([]os.FileInfo, error) {
+	return client.readDirContext(context.Background(), p)
+}
 
-	return nil
+// readDirContext is readDir through withConnContext.
+func (client *SFTPClient) readDirContext(ctx context.Context, p string) ([]os.FileInfo, error) {
+	var files []os.FileInfo
+	err := client.withConnContext(ctx, func() (err error) {
+		files, err = client.listDir(ctx, p)
+		return err
+	})
+	return files, err
+}
+
+// statConn is stat through withConn.
+func (client *SFTPClient) statConn(p string) (os.FileInfo, error) {
+	return client.statContext(context.Background(), p)
 }
 
+// statContext is stat through withConnContext.
+func (client *SFTPClient) statContext(ctx context.Context, p string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := client.withConnContext(ctx, func() (err error) {
+		info, err = client.stat(p)
+		return err
+	})
+	return info, err
+}
+
+func (client *SFTPClient) reconnectCount() uint64 {
+	client.reconnectMu.Lock()
+	defer client.reconnectMu.Unlock()
+	return client.reconnects
+}
+
+// FolderExists is DirExists reporting errors as false.
 func (client *SFTPClient) FolderExists(remotePath string) bool {
-	if client == nil {
-		return false
-	}
-	_, err := client.sftpClient.Stat(remotePath)
+	var err error
+	defer client.track("FolderExists", remotePath)(&err)
+	info, err := client.existing(context.Background(), remotePath)
+	return err == nil && info.IsDir()
+}
 
+// FileExists is Exists reporting errors as false.
+func (client *SFTPClient) FileExists(remotePath string) bool {
+	var err error
+	defer client.track("FileExists", remotePath)(&err)
+	_, err = client.existing(context.Background(), remotePath)
 	return err == nil
 }
 
-func (client *SFTPClient) FileExists(remotePath string) bool {
-	if client == nil {
-		return false
-	}
-	_, err := client.sftpClient.Stat(remotePath)
-	if err != nil {
-		return false
-	}
-	return true
+// Exists reports whether remotePath exists, failing when that cannot be
+// told, such as when the server is unreachable.
+func (client *SFTPClient) Exists(remotePath string) (bool, error) {
+	return client.ExistsContext(context.Background(), remotePath)
+}
+
+// ExistsContext is Exists giving up once ctx is done.
+func (client *SFTPClient) ExistsContext(ctx context.Context, remotePath string) (_ bool, err error) {
+	ctx, done := client.trace(ctx, "Exists", remotePath)
+	defer done(&err)
+	_, err = client.existing(ctx, remotePath)
+	return err == nil, dropNotExist(err)
+}
+
+// DirExists reports whether remotePath exists and is a directory, failing
+// when that cannot be told.
+func (client *SFTPClient) DirExists(remotePath string) (bool, error) {
+	return client.DirExistsContext(context.Background(), remotePath)
+}
+
+// DirExistsContext is DirExists giving up once ctx is done.
+func (client *SFTPClient) DirExistsContext(ctx context.Context, remotePath string) (_ bool, err error) {
+	ctx, done := client.trace(ctx, "DirExists", remotePath)
+	defer done(&err)
+	info, err := client.existing(ctx, remotePath)
+	return err == nil && info.IsDir(), dropNotExist(err)
 }
 
-func (client *SFTPClient) ListFilesAndFolders(remotePath string) ([]os.FileInfo, error) {
+// existing stats remotePath after making sure the connection works, so
+// that a broken connection is not mistaken for a missing path.
+func (client *SFTPClient) existing(ctx context.Context, remotePath string) (os.FileInfo, error) {
 	if client == nil {
 		return nil, fmt.Errorf("SFTPClient is nil")
 	}
-	files, err := client.sftpClient.ReadDir(remotePath)
+	err := client.ensureConnectedOp(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list directory: %w", err)
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
 	}
-	return files, nil
+	info, err := client.statContext(ctx, remotePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat remote path: %w", err)
+	}
+	return info, err
+}
+
+// dropNotExist returns err unless it says the path does not exist.
+func dropNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
 }
 
 func (client *SFTPClient) WalkFile(remotePath string, walkFn func(path string, info os.FileInfo) error) error {
+	return client.WalkFileContext(context.Background(), remotePath, walkFn)
+}
+
+// WalkFileContext is WalkFile giving up once ctx is done, also in the
+// middle of a listing.
+func (client *SFTPClient) WalkFileContext(ctx context.Context, remotePath string, walkFn func(path string, info os.FileInfo) error) (err error) {
 	if client == nil {
 		return fmt.Errorf("SFTPClient is nil")
 	}
+	ctx, done := client.trace(ctx, "WalkFile", remotePath)
+	defer done(&err)
+	return client.walkFile(ctx, remotePath, walkFn)
+}
 
-	// Normalize the path by stripping leading slash if needed
-	normalizedPath := remotePath
-	if len(remotePath) > 1 && remotePath[0] == '/' {
-		normalizedPath = remotePath[1:]
-	}
-
-	files, err := client.sftpClient.ReadDir(normalizedPath)
+func (client *SFTPClient) walkFile(ctx context.Context, remotePath string, walkFn func(path string, info os.FileInfo) error) error {
+	files, err := client.readDirContext(ctx, remotePath)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		// Handle permission denied error
 		if os.IsPermission(err) {
-			log.Printf("permission denied: %s", normalizedPath)
+			if !client.params.SkipPermissionErrors() {
+				return fmt.Errorf("failed to list directory: %w: %q: %w", ErrPermissionDenied, remotePath, err)
+			}
+			client.params.Logger().Warnf("permission denied: %q", remotePath)
 			return nil // Skip this directory and continue
 		}
 
 		// Handle file does not exist error
-		if os.IsNotExist(err) {
-			log.Printf("file or directory does not exist: %s", normalizedPath)
+		if os.IsNotExist(err) && client.params.SkipPermissionErrors() {
+			client.params.Logger().Warnf("file or directory does not exist: %q", remotePath)
 			return nil // Skip and continue
 		}
 
-		// Retry without the leading slash if path exists but failed
-		if normalizedPath != remotePath {
-			log.Printf("retrying without leading slash: %s", normalizedPath)
-			files, err = client.sftpClient.ReadDir(normalizedPath)
-			if err != nil {
-				return fmt.Errorf("failed to list directory after retry: %w", err) // Stop recursion
-			}
-		} else {
-			return fmt.Errorf("failed to list directory: %w", err) // Stop recursion
-		}
+		return fmt.Errorf("failed to list directory: %w", err) // Stop recursion
 	}
 
 	for _, file := range files {
-		fullPath := normalizedPath + "/" + file.Name()
+		fullPath := path.Join(remotePath, file.Name())
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		err = walkFn(fullPath, file)
 		if err != nil {
 			return err
@@ -503,7 +1107,7 @@ func (client *SFTPClient) WalkFile(remotePath string, walkFn func(path string, i
 
 		// If the file is a directory, recursively walk into it
 		if file.IsDir() {
-			err = client.WalkFile(fullPath, walkFn)
+			err = client.walkFile(ctx, fullPath, walkFn)
 			if err != nil {
 				return err
 			}
@@ -513,258 +1117,82 @@ func (client *SFTPClient) WalkFile(remotePath string, walkFn func(path string, i
 	return nil
 }
 
-func (client *SFTPClient) ensureConnectedWithRetries(retries int) error {
-	for i := 0; i < retries; i++ {
-		err := client.ensureConnected()
+// ensureConnectedWithRetries reconnects if needed, as often as the retry
+// policy of the client allows and ctx leaves time for.
+func (client *SFTPClient) ensureConnectedWithRetries(ctx context.Context) error {
+	policy := client.params.RetryPolicy()
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = client.ensureConnectedContext(ctx)
 		if err == nil {
 			return nil
 		}
-		log.Printf("Reconnection attempt %d failed: %v", i+1, err)
-		time.Sleep(2 * time.Second) // Sleep before retrying
-	}
-	return fmt.Errorf("failed to reconnect after %d attempts", retries)
-}
-
-func (client *SFTPClient) ensureConnected() error {
-	if client.isConnected() {
-		return nil // Connection is fine
-	}
-	// Try reconnecting
-	return client.ReConnect()
-}
-
-func (client *SFTPClient) isConnected() bool {
-	if client == nil || client.sftpClient == nil || client.sshClient == nil {
-		return false
-	}
-	// Try a simple operation to check if the connection is active
-	_, err := client.sftpClient.ReadDir(".")
-	return err == nil
-}
-
-// CreateRemoteDirRecursive creates remote directories recursively starting from the first missing directory.
-// It ensures the correct relative path is built for the remoteBasePath.
-func (client *SFTPClient) CreateRemoteDirRecursive(remoteBasePath string) error {
-	// // Ensure that the local path contains the relevant folder part after the base path
-	// baseIndex := strings.LastIndex(fullLocalPath, remoteBasePath)
-	// if baseIndex == -1 {
-	// 	// Instead of returning an error, return nil and skip processing if remoteBasePath is not found
-	// 	log.Printf("remoteBasePath '%s' not found in local path '%s', skipping...\n", remoteBasePath, fullLocalPath)
-	// 	return nil
-	// }
-
-	// // Get the relative directory path starting from remoteBasePath
-	// relativeDir := fullLocalPath[baseIndex+len(remoteBasePath):]
-
-	// // Combine remoteBasePath and the relative path to form the full remote directory structure
-	// relativeDir = filepath.Join(remoteBasePath, relativeDir)
-
-	// Split the relative path into directories
-	dirs := strings.Split(remoteBasePath, string(filepath.Separator))
-	var currentPath string
-
-	// Iterate through the directories and create each if missing
-	for _, dir := range dirs {
-		if dir == "" {
-			continue // Skip any empty components
+		client.params.Logger().Warnf("Reconnection attempt %d failed: %v", attempt, err)
+		if attempt >= policy.MaxAttempts {
+			break
 		}
-
-		// Build the current path
-		if currentPath == "" {
-			currentPath = dir
-		} else {
-			currentPath = filepath.Join(currentPath, dir)
+		if !IsRetryable(err) {
+			return err
 		}
-
-		// Check if the current directory exists
-		if !client.FolderExists(currentPath) {
-			// Create the directory if it doesn't exist
-			err := client.MakeDir(currentPath)
-			if err != nil {
-				return fmt.Errorf("failed to create directory '%s', error: %v", currentPath, err)
-			}
-			log.Printf("Created remote directory: %s\n", currentPath)
-		} else {
-			log.Printf("Directory already exists: %s\n", currentPath)
+		if perr := client.pause(ctx, policy.Backoff().Delay(attempt), err); perr != nil {
+			return perr
 		}
 	}
-	return nil
+	return fmt.Errorf("failed to reconnect after %d attempts: %w", policy.MaxAttempts, err)
 }
 
-func (client *SFTPClient) UploadFileWithProgress(localPath, remotePath string) error {
-	if client == nil {
-		return fmt.Errorf("SFTPClient is nil")
-	}
-
-	// Ensure connection
-	err := client.ensureConnected()
-	if err != nil {
-		return fmt.Errorf("failed to reconnect: %w", err)
-	}
-
-	// Get local file info
-	localFileInfo, err := os.Stat(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to get local file info: %w", err)
-	}
-	localFileSize := localFileInfo.Size()
-
-	// Open the local file
-	localFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
-	}
-	defer localFile.Close()
-
-	// Check if the remote file already exists and get its size
-	remoteFileInfo, err := client.sftpClient.Stat(remotePath)
-	var remoteFileSize int64
-	if err == nil {
-		remoteFileSize = remoteFileInfo.Size()
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to get remote file info: %w", err)
-	}
-
-	// Seek in the local file to resume upload from where it left off
-	_, err = localFile.Seek(remoteFileSize, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("failed to seek in local file: %w", err)
-	}
-
-	// Open or create the remote file
-	//remoteFile, err := client.sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
-
-	remoteFile, err := client.sftpClient.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to open or create remote file: %w", err)
-	}
-	defer remoteFile.Close()
-
-	// Upload file with progress tracking
-	buffer := make([]byte, 32*1024) // 32 KB buffer
-	var totalBytesRead int64
-
-	for {
-		n, readErr := localFile.Read(buffer)
-		if n > 0 {
-			_, writeErr := remoteFile.Write(buffer[:n])
-			if writeErr != nil {
-				return fmt.Errorf("failed to write to remote file: %w", writeErr)
-			}
-
-			totalBytesRead += int64(n)
-			percent := float64(totalBytesRead) / float64(localFileSize) * 100
-			fmt.Printf("\rUploading... %.2f%% complete", percent)
-		}
-
-		if readErr != nil {
-			if readErr == io.EOF {
-				break // End of file reached
-			}
-			return fmt.Errorf("failed to read from local file: %w", readErr)
-		}
-	}
-
-	fmt.Println("\nFile uploaded successfully")
-	return nil
+// ensureConnected is ensureConnectedContext for a single operation.
+func (client *SFTPClient) ensureConnected() error {
+	return client.ensureConnectedOp(context.Background())
 }
 
-func (client *SFTPClient) DownloadFileWithProgress(remotePath, localPath string) error {
-	if client == nil {
-		return fmt.Errorf("SFTPClient is nil")
+// ensureConnectedOp is ensureConnectedContext for a single operation
+// running under parent.
+func (client *SFTPClient) ensureConnectedOp(parent context.Context) error {
+	if err := parent.Err(); err != nil {
+		return err
 	}
+	ctx, cancel := client.operationContext(parent)
+	defer cancel()
+	return deadlineError(ctx, client.ensureConnectedContext(ctx))
+}
 
-	// Ensure connection before download
-	err := client.ensureConnectedWithRetries(3)
-	if err != nil {
-		return fmt.Errorf("failed to reconnect: %w", err)
-	}
-
-	// Get remote file info
-	remoteFileInfo, err := client.sftpClient.Stat(remotePath)
-	if err != nil {
-		// Skip permission denied errors
-		if os.IsPermission(err) {
-			log.Printf("Permission denied for file: %s", remotePath)
-			return nil // Skip this file
-		}
-		return fmt.Errorf("failed to get remote file info: %w", err)
-	}
-	remoteFileSize := remoteFileInfo.Size()
-
-	// Get local file info to resume download
-	var localFileSize int64
-	localFileInfo, err := os.Stat(localPath)
+// ensureConnectedContext reconnects if the connection does not work, giving
+// up once ctx is done.
+func (client *SFTPClient) ensureConnectedContext(ctx context.Context) error {
+	seen := client.reconnectCount()
+	err := client.checkConnection()
 	if err == nil {
-		localFileSize = localFileInfo.Size()
-		if localFileSize == remoteFileSize {
-			log.Printf("File already fully downloaded: %s", localPath)
-			return nil // File is fully downloaded
-		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("failed to get local file info: %w", err)
-	}
-
-	// Open the remote file
-	remoteFile, err := client.sftpClient.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to open remote file: %w", err)
-	}
-	defer remoteFile.Close()
-
-	// Seek in the remote file to resume download
-	_, err = remoteFile.Seek(localFileSize, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("failed to seek in remote file: %w", err)
-	}
-
-	// Open the local file for append or create if it doesn't exist
-	var localFile *os.File
-	if localFileSize > 0 {
-		localFile, err = os.OpenFile(localPath, os.O_WRONLY|os.O_APPEND, 0644)
-	} else {
-		localFile, err = os.Create(localPath)
-	}
-	if err != nil {
-		return fmt.Errorf("failed to open or create local file: %w", err)
+		return nil // Connection is fine
 	}
-	defer localFile.Close()
-
-	// Download the file with progress tracking
-	buffer := make([]byte, 32*1024) // 32 KB buffer
-	var totalBytesRead int64 = localFileSize
-
-	for {
-		n, readErr := remoteFile.Read(buffer)
-		if n > 0 {
-			_, writeErr := localFile.Write(buffer[:n])
-			if writeErr != nil {
-				return fmt.Errorf("failed to write to local file: %w", writeErr)
-			}
-
-			totalBytesRead += int64(n)
-			percent := float64(totalBytesRead) / float64(remoteFileSize) * 100
-			fmt.Printf("\rDownloading... %.2f%% complete", percent)
-		}
+	// Try reconnecting, unless another goroutine already did
+	return client.reconnect(ctx, seen, err)
+}
 
-		if readErr != nil {
-			if readErr == io.EOF {
-				break // End of file reached
-			}
-			return fmt.Errorf("failed to read from remote file: %w", readErr)
-		}
+// checkConnection returns why the connection does not work, if it does not.
+func (client *SFTPClient) checkConnection() error {
+	sshClient, sftpClient, _ := client.connection()
+	if sftpClient == nil || sshClient == nil {
+		return ErrNotConnected
 	}
-
-	fmt.Println("\nFile downloaded successfully")
-	return nil
+	// Try a simple operation to check if the connection is active
+	_, err := sftpClient.ReadDir(".")
+	return err
 }
 
 func (client *SFTPClient) FileInfo(filePath string) (os.FileInfo, error) {
+	return client.FileInfoContext(context.Background(), filePath)
+}
+
+// FileInfoContext is FileInfo giving up once ctx is done.
+func (client *SFTPClient) FileInfoContext(ctx context.Context, filePath string) (_ os.FileInfo, err error) {
 	if client == nil {
 		return nil, fmt.Errorf("SFTPClient is nil")
 	}
+	ctx, done := client.trace(ctx, "FileInfo", filePath)
+	defer done(&err)
 
-	fileInfo, err := client.sftpClient.Stat(filePath)
+	fileInfo, err := client.statContext(ctx, filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
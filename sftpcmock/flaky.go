@@ -0,0 +1,453 @@
+package sftpcmock
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	sftpc "github.com/thiagozs/go-sftpc"
+)
+
+// ErrConnectionLost is the error the SFTP client reports when the
+// connection drops. sftpc.ClassifyError reports it as a transport error.
+var ErrConnectionLost error = sftp.ErrSSHFxConnectionLost
+
+// NotExist returns the error a missing remote path produces.
+func NotExist(op, p string) error {
+	return &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+}
+
+// PermissionDenied returns the error a forbidden remote path produces.
+func PermissionDenied(op, p string) error {
+	return &fs.PathError{Op: op, Path: p, Err: fs.ErrPermission}
+}
+
+// fault fails calls of one operation on matching remote paths.
+type fault struct {
+	op      string
+	pattern string
+	err     error
+}
+
+// FlakyOption configures a FlakyClient.
+type FlakyOption func(*FlakyClient)
+
+// FailEvery fails every nth call, counting all operations, with err.
+func FailEvery(n int, err error) FlakyOption {
+	return func(f *FlakyClient) {
+		f.failEvery = n
+		f.failEveryErr = err
+	}
+}
+
+// FailRandomly fails each call with probability p using the client's seeded
+// source.
+func FailRandomly(p float64, err error) FlakyOption {
+	return func(f *FlakyClient) {
+		f.failRate = p
+		f.failRateErr = err
+	}
+}
+
+// FailOp fails calls of the operation op (a method name such as "Get", or
+// "" for any) whose remote path matches the path.Match pattern (or "" for
+// any) with err.
+func FailOp(op, pattern string, err error) FlakyOption {
+	return func(f *FlakyClient) {
+		f.faults = append(f.faults, fault{op: op, pattern: pattern, err: err})
+	}
+}
+
+// LatencyFunc draws the delay added before a call.
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+// UniformLatency delays calls uniformly between min and max.
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// ExponentialLatency delays calls exponentially distributed around mean.
+func ExponentialLatency(mean time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// WithLatency adds a delay drawn from latency before every call.
+func WithLatency(latency LatencyFunc) FlakyOption {
+	return func(f *FlakyClient) {
+		f.latency = latency
+	}
+}
+
+// DisconnectAfter makes the next count transfers (all of them when count is
+// zero) stop after n bytes with ErrConnectionLost, leaving the partial
+// destination behind exactly like a dropped connection does.
+func DisconnectAfter(n int64, count int) FlakyOption {
+	return func(f *FlakyClient) {
+		f.disconnectAfter = n
+		f.disconnects = count
+		f.disconnectAll = count == 0
+	}
+}
+
+// FlakyClient decorates a Client with injected failures, latency and
+// disconnects. Decisions are drawn from a source seeded at construction so
+// test runs are reproducible. It is safe for concurrent use, although the
+// order of concurrent calls, and therefore their outcome, is not.
+type FlakyClient struct {
+	inner sftpc.Client
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	calls int
+	sleep func(time.Duration)
+
+	failEvery       int
+	failEveryErr    error
+	failRate        float64
+	failRateErr     error
+	faults          []fault
+	latency         LatencyFunc
+	disconnectAfter int64
+	disconnects     int
+	disconnectAll   bool
+}
+
+var _ sftpc.Client = (*FlakyClient)(nil)
+
+// NewFlakyClient wraps inner with the failures configured by opts.
+func NewFlakyClient(inner sftpc.Client, seed int64, opts ...FlakyOption) *FlakyClient {
+	f := &FlakyClient{
+		inner: inner,
+		rng:   rand.New(rand.NewSource(seed)),
+		sleep: time.Sleep,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Calls returns the number of calls made so far.
+func (f *FlakyClient) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// before runs the injected behavior of a call and returns the error to fail
+// it with, if any.
+func (f *FlakyClient) before(op, remotePath string) error {
+	f.mu.Lock()
+	f.calls++
+	var delay time.Duration
+	if f.latency != nil {
+		delay = f.latency(f.rng)
+	}
+	err := f.pickFault(op, remotePath)
+	f.mu.Unlock()
+
+	if delay > 0 {
+		f.sleep(delay)
+	}
+	return err
+}
+
+func (f *FlakyClient) pickFault(op, remotePath string) error {
+	if f.failEvery > 0 && f.calls%f.failEvery == 0 {
+		return f.failEveryErr
+	}
+	if f.failRate > 0 && f.rng.Float64() < f.failRate {
+		return f.failRateErr
+	}
+	for _, fault := range f.faults {
+		if fault.op != "" && fault.op != op {
+			continue
+		}
+		if fault.pattern != "" {
+			if ok, _ := path.Match(fault.pattern, remotePath); !ok {
+				continue
+			}
+		}
+		return fault.err
+	}
+	return nil
+}
+
+// disconnectNow reports whether the current transfer should be cut.
+func (f *FlakyClient) disconnectNow() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.disconnectAfter <= 0 {
+		return false
+	}
+	if f.disconnectAll {
+		return true
+	}
+	if f.disconnects > 0 {
+		f.disconnects--
+		return true
+	}
+	return false
+}
+
+// Get downloads through the wrapped client. A simulated disconnect leaves
+// only the first bytes of the file locally.
+func (f *FlakyClient) Get(remotePath, localPath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	if err := f.before("Get", remotePath); err != nil {
+		return nil, err
+	}
+	if !f.disconnectNow() {
+		return f.inner.Get(remotePath, localPath, opts...)
+	}
+
+	stats, err := f.inner.Get(remotePath, localPath, opts...)
+	if err != nil {
+		return stats, err
+	}
+	cut := stats.StartOffset + f.disconnectAfter
+	if cut >= stats.StartOffset+stats.BytesTransferred {
+		return stats, nil
+	}
+	err = os.Truncate(stats.LocalPath, cut)
+	if err != nil {
+		return stats, fmt.Errorf("failed to simulate disconnect: %w", err)
+	}
+	stats.BytesTransferred = f.disconnectAfter
+	return stats, fmt.Errorf("failed to copy file to local: %w", ErrConnectionLost)
+}
+
+// Put uploads through the wrapped client. A simulated disconnect uploads
+// only the first bytes of the file.
+func (f *FlakyClient) Put(localPath, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	if err := f.before("Put", remotePath); err != nil {
+		return nil, err
+	}
+	if !f.disconnectNow() {
+		return f.inner.Put(localPath, remotePath, opts...)
+	}
+
+	partial, err := f.truncatedCopy(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(partial)
+
+	stats, err := f.inner.Put(partial, remotePath, opts...)
+	if err != nil {
+		return stats, err
+	}
+	stats.LocalPath = localPath
+	return stats, fmt.Errorf("failed to copy file to remote: %w", ErrConnectionLost)
+}
+
+// Upload streams through the wrapped client. A simulated disconnect uploads
+// only the first bytes of r.
+func (f *FlakyClient) Upload(r io.Reader, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	if err := f.before("Upload", remotePath); err != nil {
+		return nil, err
+	}
+	if !f.disconnectNow() {
+		return f.inner.Upload(r, remotePath, opts...)
+	}
+
+	stats, err := f.inner.Upload(io.LimitReader(r, f.disconnectAfter), remotePath, opts...)
+	if err != nil {
+		return stats, err
+	}
+	return stats, fmt.Errorf("failed to copy stream to remote: %w", ErrConnectionLost)
+}
+
+// UploadAppend appends through the wrapped client. A simulated disconnect
+// appends only the first bytes of r.
+func (f *FlakyClient) UploadAppend(r io.Reader, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	if err := f.before("UploadAppend", remotePath); err != nil {
+		return nil, err
+	}
+	if !f.disconnectNow() {
+		return f.inner.UploadAppend(r, remotePath, opts...)
+	}
+
+	stats, err := f.inner.UploadAppend(io.LimitReader(r, f.disconnectAfter), remotePath, opts...)
+	if err != nil {
+		return stats, err
+	}
+	return stats, fmt.Errorf("failed to append stream to remote: %w", ErrConnectionLost)
+}
+
+// truncatedCopy writes the first disconnectAfter bytes of localPath into a
+// temporary file.
+func (f *FlakyClient) truncatedCopy(localPath string) (string, error) {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "sftpcmock-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to simulate disconnect: %w", err)
+	}
+	defer dst.Close()
+
+	_, err = io.CopyN(dst, src, f.disconnectAfter)
+	if err != nil && err != io.EOF {
+		os.Remove(dst.Name())
+		return "", fmt.Errorf("failed to simulate disconnect: %w", err)
+	}
+	return dst.Name(), nil
+}
+
+func (f *FlakyClient) UploadDir(localDir, remoteDir string, opts ...sftpc.TransferOption) (*sftpc.BatchResult, error) {
+	if err := f.before("UploadDir", remoteDir); err != nil {
+		return nil, err
+	}
+	return f.inner.UploadDir(localDir, remoteDir, opts...)
+}
+
+func (f *FlakyClient) DownloadDir(remoteDir, localDir string, opts ...sftpc.TransferOption) (*sftpc.BatchResult, error) {
+	if err := f.before("DownloadDir", remoteDir); err != nil {
+		return nil, err
+	}
+	return f.inner.DownloadDir(remoteDir, localDir, opts...)
+}
+
+func (f *FlakyClient) DiffLocalRemote(localDir, remoteDir string, opts ...sftpc.TransferOption) (*sftpc.DiffReport, error) {
+	if err := f.before("DiffLocalRemote", remoteDir); err != nil {
+		return nil, err
+	}
+	return f.inner.DiffLocalRemote(localDir, remoteDir, opts...)
+}
+
+func (f *FlakyClient) CleanupTempFiles(dir string, olderThan time.Duration, recursive bool) (*sftpc.BatchResult, error) {
+	if err := f.before("CleanupTempFiles", dir); err != nil {
+		return nil, err
+	}
+	return f.inner.CleanupTempFiles(dir, olderThan, recursive)
+}
+
+func (f *FlakyClient) Walk(root string, fn func(info sftpc.RemoteFileInfo) error, opts ...sftpc.WalkOption) error {
+	if err := f.before("Walk", root); err != nil {
+		return err
+	}
+	return f.inner.Walk(root, fn, opts...)
+}
+
+func (f *FlakyClient) ExportInventory(root string, format sftpc.InventoryFormat, w io.Writer, opts ...sftpc.WalkOption) error {
+	if err := f.before("ExportInventory", root); err != nil {
+		return err
+	}
+	return f.inner.ExportInventory(root, format, w, opts...)
+}
+
+func (f *FlakyClient) Snapshot(root string, opts ...sftpc.WalkOption) (*sftpc.TreeSnapshot, error) {
+	if err := f.before("Snapshot", root); err != nil {
+		return nil, err
+	}
+	return f.inner.Snapshot(root, opts...)
+}
+
+func (f *FlakyClient) List(remotePath string) ([]os.FileInfo, error) {
+	if err := f.before("List", remotePath); err != nil {
+		return nil, err
+	}
+	return f.inner.List(remotePath)
+}
+
+func (f *FlakyClient) FileInfo(filePath string) (os.FileInfo, error) {
+	if err := f.before("FileInfo", filePath); err != nil {
+		return nil, err
+	}
+	return f.inner.FileInfo(filePath)
+}
+
+func (f *FlakyClient) Exists(remotePath string) (bool, error) {
+	if err := f.before("Exists", remotePath); err != nil {
+		return false, err
+	}
+	return f.inner.Exists(remotePath)
+}
+
+func (f *FlakyClient) DirExists(remotePath string) (bool, error) {
+	if err := f.before("DirExists", remotePath); err != nil {
+		return false, err
+	}
+	return f.inner.DirExists(remotePath)
+}
+
+func (f *FlakyClient) MakeDir(remotePath string) error {
+	if err := f.before("MakeDir", remotePath); err != nil {
+		return err
+	}
+	return f.inner.MakeDir(remotePath)
+}
+
+func (f *FlakyClient) MakeDirAll(remotePath string) error {
+	if err := f.before("MakeDirAll", remotePath); err != nil {
+		return err
+	}
+	return f.inner.MakeDirAll(remotePath)
+}
+
+func (f *FlakyClient) RemoveDir(remotePath string) error {
+	if err := f.before("RemoveDir", remotePath); err != nil {
+		return err
+	}
+	return f.inner.RemoveDir(remotePath)
+}
+
+func (f *FlakyClient) RemoveDirIfEmpty(remotePath string) (bool, error) {
+	if err := f.before("RemoveDirIfEmpty", remotePath); err != nil {
+		return false, err
+	}
+	return f.inner.RemoveDirIfEmpty(remotePath)
+}
+
+func (f *FlakyClient) RemoveAll(remotePath string, opts ...sftpc.RemoveOption) (*sftpc.BatchResult, error) {
+	if err := f.before("RemoveAll", remotePath); err != nil {
+		return nil, err
+	}
+	return f.inner.RemoveAll(remotePath, opts...)
+}
+
+func (f *FlakyClient) RemoveFile(remotePath string) error {
+	if err := f.before("RemoveFile", remotePath); err != nil {
+		return err
+	}
+	return f.inner.RemoveFile(remotePath)
+}
+
+func (f *FlakyClient) MoveFile(oldPath, newPath string) error {
+	if err := f.before("MoveFile", oldPath); err != nil {
+		return err
+	}
+	return f.inner.MoveFile(oldPath, newPath)
+}
+
+func (f *FlakyClient) ClaimFile(srcPath, claimDir string) (string, error) {
+	if err := f.before("ClaimFile", srcPath); err != nil {
+		return "", err
+	}
+	return f.inner.ClaimFile(srcPath, claimDir)
+}
+
+// Stats and Close are passed through without injected failures.
+func (f *FlakyClient) Stats() sftpc.ClientStats {
+	return f.inner.Stats()
+}
+
+func (f *FlakyClient) Close() {
+	f.inner.Close()
+}
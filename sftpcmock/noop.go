@@ -0,0 +1,100 @@
+// Package sftpcmock provides fakes of sftpc.Client for testing code that
+// depends on it without an SFTP server.
+package sftpcmock
+
+import (
+	"io"
+	"os"
+	"path"
+	"time"
+
+	sftpc "github.com/thiagozs/go-sftpc"
+)
+
+// NoopClient is a Client that succeeds at everything without doing
+// anything. Listings are empty and lookups report nothing found.
+type NoopClient struct{}
+
+var _ sftpc.Client = NoopClient{}
+
+func (NoopClient) Get(remotePath, localPath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	return &sftpc.TransferStats{RemotePath: remotePath, LocalPath: localPath, Attempts: 1}, nil
+}
+
+func (NoopClient) Put(localPath, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	return &sftpc.TransferStats{RemotePath: remotePath, LocalPath: localPath, Attempts: 1}, nil
+}
+
+func (NoopClient) Upload(r io.Reader, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	n, err := io.Copy(io.Discard, r)
+	return &sftpc.TransferStats{RemotePath: remotePath, BytesTransferred: n, TotalSize: n, Attempts: 1}, err
+}
+
+func (NoopClient) UploadAppend(r io.Reader, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	n, err := io.Copy(io.Discard, r)
+	return &sftpc.TransferStats{RemotePath: remotePath, BytesTransferred: n, TotalSize: n, Attempts: 1}, err
+}
+
+func (NoopClient) UploadDir(localDir, remoteDir string, opts ...sftpc.TransferOption) (*sftpc.BatchResult, error) {
+	return &sftpc.BatchResult{}, nil
+}
+
+func (NoopClient) DownloadDir(remoteDir, localDir string, opts ...sftpc.TransferOption) (*sftpc.BatchResult, error) {
+	return &sftpc.BatchResult{}, nil
+}
+
+func (NoopClient) DiffLocalRemote(localDir, remoteDir string, opts ...sftpc.TransferOption) (*sftpc.DiffReport, error) {
+	return &sftpc.DiffReport{}, nil
+}
+
+func (NoopClient) CleanupTempFiles(dir string, olderThan time.Duration, recursive bool) (*sftpc.BatchResult, error) {
+	return &sftpc.BatchResult{}, nil
+}
+
+func (NoopClient) Walk(root string, fn func(info sftpc.RemoteFileInfo) error, opts ...sftpc.WalkOption) error {
+	return nil
+}
+
+func (NoopClient) ExportInventory(root string, format sftpc.InventoryFormat, w io.Writer, opts ...sftpc.WalkOption) error {
+	return nil
+}
+
+func (NoopClient) Snapshot(root string, opts ...sftpc.WalkOption) (*sftpc.TreeSnapshot, error) {
+	return &sftpc.TreeSnapshot{Root: root, DirMtimeReliable: true}, nil
+}
+
+func (NoopClient) List(remotePath string) ([]os.FileInfo, error) {
+	return nil, nil
+}
+
+func (NoopClient) FileInfo(filePath string) (os.FileInfo, error) {
+	return nil, NotExist("stat", filePath)
+}
+
+func (NoopClient) Exists(remotePath string) (bool, error) {
+	return false, nil
+}
+
+func (NoopClient) DirExists(remotePath string) (bool, error) {
+	return false, nil
+}
+
+func (NoopClient) RemoveDirIfEmpty(remotePath string) (bool, error) {
+	return false, nil
+}
+
+func (NoopClient) ClaimFile(srcPath, claimDir string) (string, error) {
+	return path.Join(claimDir, path.Base(srcPath)), nil
+}
+
+func (NoopClient) RemoveAll(remotePath string, opts ...sftpc.RemoveOption) (*sftpc.BatchResult, error) {
+	return &sftpc.BatchResult{}, nil
+}
+
+func (NoopClient) MakeDir(remotePath string) error        { return nil }
+func (NoopClient) MakeDirAll(remotePath string) error     { return nil }
+func (NoopClient) RemoveDir(remotePath string) error      { return nil }
+func (NoopClient) RemoveFile(remotePath string) error     { return nil }
+func (NoopClient) MoveFile(oldPath, newPath string) error { return nil }
+func (NoopClient) Stats() sftpc.ClientStats               { return sftpc.ClientStats{} }
+func (NoopClient) Close()                                 {}
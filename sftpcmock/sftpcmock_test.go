@@ -0,0 +1,88 @@
+package sftpcmock
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sftpc "github.com/thiagozs/go-sftpc"
+)
+
+// recordingClient remembers the size of the last uploaded file.
+type recordingClient struct {
+	NoopClient
+	uploaded int64
+}
+
+func (c *recordingClient) Put(localPath, remotePath string, opts ...sftpc.TransferOption) (*sftpc.TransferStats, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	c.uploaded = info.Size()
+	return &sftpc.TransferStats{RemotePath: remotePath, LocalPath: localPath, BytesTransferred: info.Size()}, nil
+}
+
+func TestFlakyClientInjectsTypedFailures(t *testing.T) {
+	client := NewFlakyClient(NoopClient{}, 1,
+		FailEvery(3, ErrConnectionLost),
+		FailOp("RemoveFile", "/locked/*", PermissionDenied("remove", "/locked/x")),
+	)
+
+	var failed []int
+	for i := 1; i <= 6; i++ {
+		if err := client.MakeDir("/dir"); err != nil {
+			if sftpc.ClassifyError(err) != sftpc.ErrorClassTransport {
+				t.Fatalf("injected error classified as %q", sftpc.ClassifyError(err))
+			}
+			failed = append(failed, i)
+		}
+	}
+	if len(failed) != 2 || failed[0] != 3 || failed[1] != 6 {
+		t.Fatalf("failed calls = %v, want [3 6]", failed)
+	}
+
+	if err := client.RemoveFile("/open/x"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	err := client.RemoveFile("/locked/x")
+	if !errors.Is(err, fs.ErrPermission) || sftpc.IsRetryable(err) {
+		t.Fatalf("RemoveFile error = %v, want permanent permission error", err)
+	}
+}
+
+func TestFlakyClientIsReproducible(t *testing.T) {
+	run := func() []bool {
+		client := NewFlakyClient(NoopClient{}, 42, FailRandomly(0.5, ErrConnectionLost))
+		var outcome []bool
+		for i := 0; i < 32; i++ {
+			outcome = append(outcome, client.RemoveFile("x") != nil)
+		}
+		return outcome
+	}
+	first, second := run(), run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("runs with the same seed diverge at call %d", i)
+		}
+	}
+}
+
+func TestFlakyClientDisconnectsMidUpload(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "data.bin")
+	os.WriteFile(local, make([]byte, 1000), 0644)
+
+	inner := &recordingClient{}
+	client := NewFlakyClient(inner, 1, DisconnectAfter(100, 1))
+
+	_, err := client.Put(local, "/data.bin")
+	if !sftpc.IsRetryable(err) || inner.uploaded != 100 {
+		t.Fatalf("first Put err = %v after %d bytes, want transport error after 100", err, inner.uploaded)
+	}
+	_, err = client.Put(local, "/data.bin")
+	if err != nil || inner.uploaded != 1000 {
+		t.Fatalf("second Put err = %v after %d bytes, want full upload", err, inner.uploaded)
+	}
+}
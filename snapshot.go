@@ -0,0 +1,381 @@
+package sftpc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// DefaultSnapshotNewest is the number of newest files a snapshot keeps
+// unless WithNewestFiles says otherwise.
+const DefaultSnapshotNewest = 10
+
+// DirAggregate summarizes the files directly inside one directory.
+type DirAggregate struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modTime"`
+	Files   int       `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	// Newest is the modification time of the newest file, zero without
+	// files.
+	Newest time.Time `json:"newest,omitempty"`
+	// Names is a digest of the entry names, used to detect servers that do
+	// not update directory modification times.
+	Names string `json:"names"`
+}
+
+// SnapshotFile is one of the newest files of a snapshot.
+type SnapshotFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// TreeSnapshot is a compact, serializable summary of a remote tree. Paths
+// are relative to Root, the root itself being ".".
+type TreeSnapshot struct {
+	Root    string         `json:"root"`
+	TakenAt time.Time      `json:"takenAt"`
+	Dirs    []DirAggregate `json:"dirs"`
+	Newest  []SnapshotFile `json:"newest"`
+
+	// DirMtimeReliable is set once a walk listing a directory whose entries
+	// changed since the previous snapshot found its modification time
+	// moved too. Until then, and for good once DirMtimeStale is set,
+	// snapshots based on this one walk the whole tree.
+	DirMtimeReliable bool `json:"dirMtimeReliable"`
+	// DirMtimeStale is set once a walk finds a directory whose entries
+	// changed while its modification time did not.
+	DirMtimeStale bool `json:"dirMtimeStale,omitempty"`
+	// ReusedDirs counts the directories copied from the previous snapshot
+	// instead of being listed.
+	ReusedDirs int `json:"reusedDirs"`
+}
+
+// Files returns the number of files in the tree.
+func (s *TreeSnapshot) Files() int {
+	n := 0
+	for _, dir := range s.Dirs {
+		n += dir.Files
+	}
+	return n
+}
+
+// Bytes returns the total size of the files in the tree.
+func (s *TreeSnapshot) Bytes() int64 {
+	var n int64
+	for _, dir := range s.Dirs {
+		n += dir.Bytes
+	}
+	return n
+}
+
+// NewestModTime returns the modification time of the newest file.
+func (s *TreeSnapshot) NewestModTime() time.Time {
+	if len(s.Newest) == 0 {
+		return time.Time{}
+	}
+	return s.Newest[0].ModTime
+}
+
+// Save writes the snapshot as JSON.
+func (s *TreeSnapshot) Save(w io.Writer) error {
+	err := json.NewEncoder(w).Encode(s)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads a snapshot written by Save.
+func LoadSnapshot(r io.Reader) (*TreeSnapshot, error) {
+	snapshot := &TreeSnapshot{}
+	err := json.NewDecoder(r).Decode(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// WithNewestFiles sets how many of the newest files a snapshot keeps.
+func WithNewestFiles(n int) WalkOption {
+	return func(params *walkParams) error {
+		if n < 0 {
+			return fmt.Errorf("invalid number of newest files: %d", n)
+		}
+		params.newest = n
+		return nil
+	}
+}
+
+// WithPreviousSnapshot lets Snapshot skip listing directories whose
+// modification time is unchanged since prev, taking their aggregate from it
+// and only checking their subdirectories with a stat. Directory
+// modification times only change when entries are added, removed or
+// renamed, so files rewritten in place are only seen by a full walk. A full
+// walk happens whenever prev is older than maxAge, the freshness budget, or
+// the server is not known to update directory modification times, see
+// TreeSnapshot.DirMtimeReliable.
+func WithPreviousSnapshot(prev *TreeSnapshot, maxAge time.Duration) WalkOption {
+	return func(params *walkParams) error {
+		params.previous = prev
+		params.maxAge = maxAge
+		return nil
+	}
+}
+
+type snapshotWalk struct {
+	client   *SFTPClient
+	root     string
+	snapshot *TreeSnapshot
+	newest   int
+	prev     map[string]DirAggregate
+	previous *TreeSnapshot
+	reuse    bool
+}
+
+// Snapshot summarizes the tree below root: per directory file counts and
+// sizes plus the newest files. See WithPreviousSnapshot to avoid listing
+// unchanged directories.
+func (client *SFTPClient) Snapshot(root string, opts ...WalkOption) (_ *TreeSnapshot, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("Snapshot", root)(&err)
+
+	params, err := newWalkParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	root = path.Clean(root)
+	w := &snapshotWalk{
+		client:   client,
+		root:     root,
+		snapshot: &TreeSnapshot{Root: root, TakenAt: client.now().UTC()},
+		newest:   params.newest,
+	}
+	if prev := params.previous; prev != nil && prev.Root == root {
+		w.previous = prev
+		w.prev = make(map[string]DirAggregate, len(prev.Dirs))
+		for _, dir := range prev.Dirs {
+			w.prev[dir.Path] = dir
+		}
+		w.snapshot.DirMtimeReliable = prev.DirMtimeReliable
+		w.snapshot.DirMtimeStale = prev.DirMtimeStale
+		w.reuse = prev.DirMtimeReliable && w.snapshot.TakenAt.Sub(prev.TakenAt) < params.maxAge
+	}
+
+	info, err := client.stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("remote path %q is not a directory", root)
+	}
+
+	err = w.dir(".", info.ModTime())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(w.snapshot.Dirs, func(i, j int) bool {
+		return w.snapshot.Dirs[i].Path < w.snapshot.Dirs[j].Path
+	})
+	w.trimNewest()
+	return w.snapshot, nil
+}
+
+func (w *snapshotWalk) dir(rel string, modTime time.Time) error {
+	p := w.root
+	if rel != "." {
+		p = path.Join(w.root, rel)
+	}
+
+	prev, known := w.prev[rel]
+	if w.reuse && known && prev.ModTime.Equal(modTime) {
+		return w.reuseDir(prev, p)
+	}
+
+	entries, err := w.client.readDir(p)
+	if err != nil {
+		return fmt.Errorf("failed to list directory %q: %w", p, err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	agg := DirAggregate{Path: rel, ModTime: modTime}
+	names := sha256.New()
+	var subdirs []os.FileInfo
+	for _, entry := range entries {
+		names.Write([]byte(entry.Name()))
+		names.Write([]byte{0})
+
+		entry, link, err := w.client.followEntry(path.Join(p, entry.Name()), entry)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir() && !link:
+			subdirs = append(subdirs, entry)
+		case entry.Mode().IsRegular():
+			agg.Files++
+			agg.Bytes += entry.Size()
+			if entry.ModTime().After(agg.Newest) {
+				agg.Newest = entry.ModTime()
+			}
+			w.addNewest(SnapshotFile{Path: childRel(rel, entry.Name()), Size: entry.Size(), ModTime: entry.ModTime()})
+		}
+	}
+	agg.Names = hex.EncodeToString(names.Sum(nil)[:8])
+	if known && prev.Names != agg.Names {
+		if prev.ModTime.Equal(modTime) {
+			w.snapshot.DirMtimeStale = true
+		}
+		w.snapshot.DirMtimeReliable = !w.snapshot.DirMtimeStale
+	}
+	w.snapshot.Dirs = append(w.snapshot.Dirs, agg)
+
+	for _, subdir := range subdirs {
+		err = w.dir(childRel(rel, subdir.Name()), subdir.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func childRel(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// reuseDir takes the aggregate of an unchanged directory from the previous
+// snapshot without listing it. Its subdirectories cannot have been added or
+// removed, so only their modification times are checked.
+func (w *snapshotWalk) reuseDir(prev DirAggregate, p string) error {
+	w.snapshot.Dirs = append(w.snapshot.Dirs, prev)
+	w.snapshot.ReusedDirs++
+	for _, file := range w.previous.Newest {
+		if path.Dir(file.Path) == prev.Path {
+			w.addNewest(file)
+		}
+	}
+
+	for _, dir := range w.previous.Dirs {
+		if dir.Path == "." || path.Dir(dir.Path) != prev.Path {
+			continue
+		}
+		info, err := w.client.sftpConn().Stat(path.Join(w.root, dir.Path))
+		if err != nil {
+			return fmt.Errorf("failed to get remote file info: %w", err)
+		}
+		err = w.dir(dir.Path, info.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *snapshotWalk) addNewest(file SnapshotFile) {
+	if w.newest == 0 {
+		return
+	}
+	w.snapshot.Newest = append(w.snapshot.Newest, file)
+	if len(w.snapshot.Newest) > 2*w.newest {
+		w.trimNewest()
+	}
+}
+
+func (w *snapshotWalk) trimNewest() {
+	newest := w.snapshot.Newest
+	sort.Slice(newest, func(i, j int) bool {
+		if !newest[i].ModTime.Equal(newest[j].ModTime) {
+			return newest[i].ModTime.After(newest[j].ModTime)
+		}
+		return newest[i].Path < newest[j].Path
+	})
+	if len(newest) > w.newest {
+		w.snapshot.Newest = newest[:w.newest]
+	}
+}
+
+// DirChange describes how one directory differs between two snapshots.
+type DirChange struct {
+	Path       string
+	FilesDelta int
+	BytesDelta int64
+}
+
+// SnapshotDiffReport summarizes the changes between two snapshots.
+type SnapshotDiffReport struct {
+	AddedDirs   []string
+	RemovedDirs []string
+	ChangedDirs []DirChange
+	FilesDelta  int
+	BytesDelta  int64
+	// NewFiles are the newest files of the new snapshot modified after the
+	// old snapshot was taken.
+	NewFiles []SnapshotFile
+}
+
+// Summary returns a one line description of the changes.
+func (r *SnapshotDiffReport) Summary() string {
+	return fmt.Sprintf("%+d files, %+d bytes, %d dirs added, %d removed, %d changed",
+		r.FilesDelta, r.BytesDelta, len(r.AddedDirs), len(r.RemovedDirs), len(r.ChangedDirs))
+}
+
+// SnapshotDiff compares two snapshots of the same tree.
+func SnapshotDiff(old, new *TreeSnapshot) *SnapshotDiffReport {
+	report := &SnapshotDiffReport{
+		FilesDelta: new.Files() - old.Files(),
+		BytesDelta: new.Bytes() - old.Bytes(),
+	}
+
+	oldDirs := make(map[string]DirAggregate, len(old.Dirs))
+	for _, dir := range old.Dirs {
+		oldDirs[dir.Path] = dir
+	}
+	for _, dir := range new.Dirs {
+		before, ok := oldDirs[dir.Path]
+		if !ok {
+			report.AddedDirs = append(report.AddedDirs, dir.Path)
+			continue
+		}
+		delete(oldDirs, dir.Path)
+		if before.Files != dir.Files || before.Bytes != dir.Bytes || before.Names != dir.Names {
+			report.ChangedDirs = append(report.ChangedDirs, DirChange{
+				Path:       dir.Path,
+				FilesDelta: dir.Files - before.Files,
+				BytesDelta: dir.Bytes - before.Bytes,
+			})
+		}
+	}
+	for p := range oldDirs {
+		report.RemovedDirs = append(report.RemovedDirs, p)
+	}
+	sort.Strings(report.RemovedDirs)
+
+	for _, file := range new.Newest {
+		if file.ModTime.After(old.TakenAt) {
+			report.NewFiles = append(report.NewFiles, file)
+		}
+	}
+	return report
+}
@@ -0,0 +1,6 @@
+//go:build sftpc_strict
+
+package sftpc
+
+// defaultStrictSecurity turns WithStrictSecurity on for every client.
+const defaultStrictSecurity = true
@@ -0,0 +1,6 @@
+//go:build !sftpc_strict
+
+package sftpc
+
+// defaultStrictSecurity leaves strict mode to WithStrictSecurity.
+const defaultStrictSecurity = false
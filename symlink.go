@@ -0,0 +1,101 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// SymlinkPolicy is how the client treats remote symbolic links when it
+// stats, checks, downloads or walks remote paths.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow operates on link targets; a dangling link looks like a
+	// missing file. This is the default.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkNoFollow operates on links themselves. Downloading a link
+	// fails with ErrIsSymlink.
+	SymlinkNoFollow
+	// SymlinkFollowSafe follows links but fails with ErrBrokenSymlink when
+	// the target is missing.
+	SymlinkFollowSafe
+)
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkFollow:
+		return "follow"
+	case SymlinkNoFollow:
+		return "no-follow"
+	case SymlinkFollowSafe:
+		return "follow-safe"
+	}
+	return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+}
+
+// WithSymlinkPolicy sets how FileInfo, FileExists, FolderExists, the
+// downloads and the walks treat remote symbolic links. Walks report links
+// with RemoteFileInfo.Symlink set and never descend into linked
+// directories, whatever the policy.
+func WithSymlinkPolicy(policy SymlinkPolicy) Options {
+	return func(params *SFTPClientParams) error {
+		if policy < SymlinkFollow || policy > SymlinkFollowSafe {
+			return fmt.Errorf("invalid symlink policy: %d", int(policy))
+		}
+		params.symlinkPolicy = policy
+		params.record("WithSymlinkPolicy", policy.String())
+		return nil
+	}
+}
+
+// stat returns the info of the remote path p under the symlink policy.
+func (client *SFTPClient) stat(p string) (os.FileInfo, error) {
+	switch client.params.SymlinkPolicy() {
+	case SymlinkNoFollow:
+		return client.sftpConn().Lstat(p)
+	case SymlinkFollowSafe:
+		info, err := client.sftpConn().Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			if link, lerr := client.sftpConn().Lstat(p); lerr == nil && link.Mode()&os.ModeSymlink != 0 {
+				return nil, &fs.PathError{Op: "stat", Path: p, Err: ErrBrokenSymlink}
+			}
+		}
+		return info, err
+	}
+	return client.sftpConn().Stat(p)
+}
+
+// statSource returns the info of the remote file p about to be downloaded.
+func (client *SFTPClient) statSource(p string) (os.FileInfo, error) {
+	info, err := client.stat(p)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: ErrIsSymlink}
+	}
+	return info, err
+}
+
+// followEntry resolves the listed entry info at p under the symlink policy
+// and reports whether it was a link. A dangling link stays as listed
+// unless the policy is SymlinkFollowSafe.
+func (client *SFTPClient) followEntry(p string, info os.FileInfo) (os.FileInfo, bool, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return info, false, nil
+	}
+	policy := client.params.SymlinkPolicy()
+	if policy == SymlinkNoFollow {
+		return info, true, nil
+	}
+
+	target, err := client.sftpConn().Stat(p)
+	switch {
+	case err == nil:
+		return target, true, nil
+	case errors.Is(err, fs.ErrNotExist) && policy == SymlinkFollowSafe:
+		return nil, true, &fs.PathError{Op: "stat", Path: p, Err: ErrBrokenSymlink}
+	case errors.Is(err, fs.ErrNotExist):
+		return info, true, nil
+	}
+	return nil, true, fmt.Errorf("failed to follow symlink %q: %w", p, err)
+}
@@ -0,0 +1,569 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// SyncOption configures SyncToRemote and SyncToLocal.
+type SyncOption func(*syncParams) error
+
+type syncParams struct {
+	transfer       *transferParams
+	delete         bool
+	deleteExcludes []string
+}
+
+func newSyncParams(opts ...SyncOption) (*syncParams, error) {
+	params := &syncParams{transfer: &transferParams{indexBudget: DefaultIndexBudget}}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// WithSyncExclude leaves alone the files and directories whose relative
+// path or base name matches one of the path.Match patterns, see WithExclude.
+func WithSyncExclude(patterns ...string) SyncOption {
+	return WithSyncTransfer(WithExclude(patterns...))
+}
+
+// WithSyncTransfer applies opts, such as WithInclude, WithProgress,
+// WithVerifyChecksum or WithVerificationSampling, to the comparison of the
+// trees and to every file a sync copies.
+func WithSyncTransfer(opts ...TransferOption) SyncOption {
+	return func(params *syncParams) error {
+		for _, opt := range opts {
+			if err := opt(params.transfer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithSyncDryRun makes a sync compare the trees as usual but write nothing:
+// no file is copied or deleted and no directory created. The report lists
+// what the sync would have done. See WithDryRun.
+func WithSyncDryRun() SyncOption {
+	return WithSyncTransfer(WithDryRun())
+}
+
+// WithDelete makes a sync remove the destination files and directories that
+// no longer exist at the source, like rsync --delete, once the copies are
+// done. Entries hidden by the include and exclude filters are left alone;
+// see WithDeleteExcludes to protect others. SyncToLocal deletes nothing when
+// part of the remote tree could not be listed.
+func WithDelete() SyncOption {
+	return func(params *syncParams) error {
+		params.delete = true
+		return nil
+	}
+}
+
+// WithDeleteExcludes protects from WithDelete the destination entries whose
+// relative path or base name matches one of the path.Match patterns, and
+// everything below a matching directory.
+func WithDeleteExcludes(patterns ...string) SyncOption {
+	return func(params *syncParams) error {
+		if err := validatePatterns(patterns); err != nil {
+			return err
+		}
+		params.deleteExcludes = append(params.deleteExcludes, patterns...)
+		return nil
+	}
+}
+
+// protected reports whether WithDeleteExcludes protects rel, or one of the
+// directories above it.
+func (params *syncParams) protected(rel string) bool {
+	for ; rel != "."; rel = path.Dir(rel) {
+		if matchAny(params.deleteExcludes, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncReport is the outcome of a sync. Paths are relative to the synced
+// directories and slash separated.
+type SyncReport struct {
+	// Transferred are the files copied because they were new or changed.
+	Transferred []string
+	// Added are the files of Transferred that were new, missing at the
+	// destination; the others had changed.
+	Added []string
+	// Skipped are the files already up to date, and the files left alone
+	// for another reason, which SkipReasons holds.
+	Skipped     []string
+	SkipReasons map[string]string
+	// Failed are the files that could not be copied, with their error in
+	// Errors.
+	Failed []string
+	Errors map[string]error
+	// Deleted are the destination files and directories removed by
+	// WithDelete, files first.
+	Deleted     []string
+	DirsCreated int
+	Duration    time.Duration
+	// DryRun is set under WithSyncDryRun: nothing was written, and the
+	// lists and DirsCreated hold what the sync would have done.
+	DryRun bool
+	// Sample is set when WithVerificationSampling was used. Copies that
+	// fail the check are in Failed and keep no source modification time,
+	// so the next sync copies them again.
+	Sample *VerificationSample
+}
+
+// transferred records the file rel as copied, and whether it was new.
+func (r *SyncReport) transferred(rel string, added bool) {
+	r.Transferred = append(r.Transferred, rel)
+	if added {
+		r.Added = append(r.Added, rel)
+	}
+}
+
+// skip records the file rel as left alone for reason.
+func (r *SyncReport) skip(rel, reason string) {
+	if r.SkipReasons == nil {
+		r.SkipReasons = make(map[string]string)
+	}
+	r.Skipped = append(r.Skipped, rel)
+	r.SkipReasons[rel] = reason
+}
+
+// fail records the failure of the file rel.
+func (r *SyncReport) fail(rel string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]error)
+	}
+	r.Failed = append(r.Failed, rel)
+	r.Errors[rel] = err
+}
+
+// syncDest is the destination of a sync as deleteExtraneous sees it.
+type syncDest struct {
+	isDir func(rel string) bool
+	// list returns the names in the directory rel, for dry runs.
+	list   func(rel string) ([]string, error)
+	remove func(rel string, dir bool) error
+}
+
+// deleteExtraneous removes the extraneous entries, contents before their
+// directory, that WithDeleteExcludes does not protect: the files first, then
+// the directories left empty. Directories holding a protected entry, one
+// that failed to be removed or one hidden by the filters are kept. A dry run
+// only records what would be removed.
+func (r *SyncReport) deleteExtraneous(extraneous []string, dest syncDest, params *syncParams) {
+	gone := make(map[string]bool)
+	remove := func(rel string, dir bool) error {
+		if !params.transfer.dryRun {
+			return dest.remove(rel, dir)
+		}
+		if !dir {
+			return nil
+		}
+		names, err := dest.list(rel)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !gone[path.Join(rel, name)] {
+				return fmt.Errorf("%w: %q", ErrDirectoryNotEmpty, rel)
+			}
+		}
+		return nil
+	}
+
+	keep := make(map[string]bool)
+	hold := func(rel string) {
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			keep[dir] = true
+		}
+	}
+
+	var dirs []string
+	for _, rel := range extraneous {
+		switch {
+		case params.protected(rel):
+			hold(rel)
+			keep[rel] = true
+		case dest.isDir(rel):
+			dirs = append(dirs, rel)
+		default:
+			if err := remove(rel, false); err != nil {
+				r.fail(rel, err)
+				hold(rel)
+				continue
+			}
+			gone[rel] = true
+			r.Deleted = append(r.Deleted, rel)
+		}
+	}
+	for _, rel := range dirs {
+		if keep[rel] {
+			continue
+		}
+		err := remove(rel, true)
+		if err != nil {
+			if !errors.Is(err, ErrDirectoryNotEmpty) {
+				r.fail(rel, err)
+			}
+			hold(rel)
+			continue
+		}
+		gone[rel] = true
+		r.Deleted = append(r.Deleted, rel)
+	}
+}
+
+// err joins the errors of the failed files, or returns nil.
+func (r *SyncReport) err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(r.Failed))
+	for _, rel := range r.Failed {
+		errs = append(errs, r.Errors[rel])
+	}
+	total := len(r.Transferred) + len(r.Failed)
+	return fmt.Errorf("%d of %d files failed: %w", len(r.Failed), total, errors.Join(errs...))
+}
+
+// SyncToRemote makes remoteDir a copy of localDir: files missing on the
+// remote side or differing in size or modification time are uploaded,
+// missing directories created, and the rest left alone. Uploaded files get
+// the modification time of their local file, so a sync interrupted by a
+// crash is completed by running it again, which uploads only what is still
+// missing or partial. Failures of individual files are recorded in the
+// report and joined in the returned error; the remaining files are still
+// uploaded.
+func (client *SFTPClient) SyncToRemote(localDir, remoteDir string, opts ...SyncOption) (_ *SyncReport, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("SyncToRemote", remoteDir)(&err)
+
+	params, err := newSyncParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	plan, err := localTreePlan(localDir, params.transfer)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.mapTargets(params.transfer.mapper, true)
+	if err != nil {
+		return nil, err
+	}
+	idx := client.newRemoteIndex(remoteDir, params.transfer.indexBudget)
+	diff, err := idx.diff(plan, params.transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReport{Skipped: diff.Unchanged, DryRun: params.transfer.dryRun}
+	missing := diff.MissingDirs
+	if report.DryRun {
+		report.DirsCreated, missing = len(missing), nil
+	} else if err := client.mkdirAll(remoteDir); err != nil {
+		return nil, err
+	}
+	for _, dir := range missing {
+		created, err := client.mkdir(path.Join(remoteDir, dir))
+		if err != nil {
+			report.Duration = time.Since(start)
+			return report, err
+		}
+		if created {
+			report.DirsCreated++
+		}
+	}
+
+	modTimes := make(map[string]time.Time, len(plan.files))
+	for _, file := range plan.files {
+		modTimes[file.rel] = file.modTime
+	}
+	files := append(append([]string(nil), diff.Added...), diff.Changed...)
+	sort.Strings(files)
+
+	batch := &BatchResult{}
+	var copied []string
+	for _, rel := range files {
+		if report.DryRun {
+			report.transferred(rel, slices.Contains(diff.Added, rel))
+			continue
+		}
+		target := rel
+		if diff.Mapped != nil {
+			target = diff.Mapped[rel]
+		}
+		item := BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(rel)),
+			RemotePath: path.Join(remoteDir, target),
+		}
+		client.runItem(batch, item, params.transfer, true)
+		copied = append(copied, rel)
+	}
+	client.verifySample(batch, params.transfer)
+	report.Sample = batch.Sample
+	for i, rel := range copied {
+		if done := batch.Items[i]; done.Status != StatusTransferred {
+			report.fail(rel, done.Err)
+			continue
+		}
+		p := batch.Items[i].RemotePath
+		err := client.sftpConn().Chtimes(p, modTimes[rel], modTimes[rel])
+		if err != nil {
+			client.params.Logger().Warnf("Failed to set the modification time of %q, the next sync uploads it again: %v", p, err)
+		}
+		report.transferred(rel, slices.Contains(diff.Added, rel))
+	}
+
+	if params.delete {
+		report.deleteExtraneous(diff.Extraneous, syncDest{
+			isDir: func(rel string) bool {
+				d := idx.dirs[rel]
+				return d != nil && !d.missing
+			},
+			list: func(rel string) ([]string, error) {
+				entries, err := idx.children(rel)
+				names := make([]string, 0, len(entries))
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				return names, err
+			},
+			remove: func(rel string, dir bool) error {
+				p := path.Join(remoteDir, rel)
+				if dir {
+					return client.removeEmptyDir(p)
+				}
+				err := client.sftpConn().Remove(p)
+				if err != nil {
+					return fmt.Errorf("failed to remove remote file %q: %w", p, err)
+				}
+				return nil
+			},
+		}, params)
+	}
+
+	sort.Strings(report.Failed)
+	report.Duration = time.Since(start)
+	return report, report.err()
+}
+
+// SyncToLocal makes localDir a copy of remoteDir: files missing locally or
+// differing in size or modification time are downloaded, and the rest left
+// alone. Like SyncToRemote, downloaded files get the modification time of
+// their remote file and a sync is completed by running it again. Remote
+// directories that cannot be listed for lack of permission are reported as
+// failed, like the files that fail to download; files still being uploaded,
+// see WithUploaderConventions, are skipped with a reason and left for the
+// next sync.
+func (client *SFTPClient) SyncToLocal(remoteDir, localDir string, opts ...SyncOption) (_ *SyncReport, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("SyncToLocal", remoteDir)(&err)
+
+	params, err := newSyncParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	start := time.Now()
+	report := &SyncReport{DryRun: params.transfer.dryRun}
+	plan, err := client.remoteTreePlan(remoteDir, params.transfer, func(rel string, err error) {
+		report.fail(rel, fmt.Errorf("%w: %q: %w", ErrPermissionDenied, path.Join(remoteDir, rel), err))
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = plan.mapTargets(params.transfer.mapper, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.DryRun {
+		err = os.MkdirAll(localDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local directory: %w", err)
+		}
+	}
+	dirs, _ := plan.dirsToCreate(false)
+	for _, dir := range dirs {
+		localPath := filepath.Join(localDir, filepath.FromSlash(dir.rel))
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+		if report.DryRun {
+			report.DirsCreated++
+			continue
+		}
+		err = os.MkdirAll(localPath, 0755)
+		if err != nil {
+			report.Duration = time.Since(start)
+			return report, fmt.Errorf("failed to create local directory: %w", err)
+		}
+		report.DirsCreated++
+	}
+
+	precision := client.params.TimePrecision()
+	batch := &BatchResult{}
+	var copied []treeEntry
+	var added []bool
+	for _, partial := range plan.partials {
+		report.skip(partial.rel, "partial upload")
+	}
+	for _, file := range plan.files {
+		if _, ok := plan.inProgress[file.rel]; ok {
+			report.skip(file.rel, "still being uploaded")
+			continue
+		}
+		item := BatchItem{
+			LocalPath:  filepath.Join(localDir, filepath.FromSlash(file.target)),
+			RemotePath: path.Join(remoteDir, file.rel),
+			Symlink:    file.link,
+		}
+		local, err := os.Stat(item.LocalPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			report.fail(file.rel, fmt.Errorf("failed to get local file info: %w", err))
+			continue
+		}
+		if err == nil && sameFile(file, local, precision) {
+			report.Skipped = append(report.Skipped, file.rel)
+			continue
+		}
+		if report.DryRun {
+			report.transferred(file.rel, local == nil)
+			continue
+		}
+
+		client.runItem(batch, item, params.transfer, false)
+		copied = append(copied, file)
+		added = append(added, local == nil)
+	}
+	client.verifySample(batch, params.transfer)
+	report.Sample = batch.Sample
+	for i, file := range copied {
+		if done := batch.Items[i]; done.Status != StatusTransferred {
+			report.fail(file.rel, done.Err)
+			continue
+		}
+		p := batch.Items[i].LocalPath
+		err = os.Chtimes(p, file.modTime, file.modTime)
+		if err != nil {
+			client.params.Logger().Warnf("Failed to set the modification time of %q, the next sync downloads it again: %v", p, err)
+		}
+		report.transferred(file.rel, added[i])
+	}
+
+	switch {
+	case params.delete && len(report.Failed) > 0:
+		client.params.Logger().Warnf("Not deleting below %q, the remote tree could not be read entirely", localDir)
+	case params.delete:
+		keep := map[string]bool{".": true}
+		dirs := plan.dirs
+		if plan.mapped {
+			dirs = plan.targetDirs()
+		}
+		for _, entries := range [][]treeEntry{dirs, plan.files, plan.links} {
+			for _, entry := range entries {
+				keep[entry.target] = true
+			}
+		}
+		isDir := make(map[string]bool)
+		extraneous, err := localExtraneous(localDir, ".", keep, params.transfer, isDir)
+		if err != nil && !(report.DryRun && errors.Is(err, fs.ErrNotExist)) {
+			report.Duration = time.Since(start)
+			return report, err
+		}
+		list := func(rel string) ([]string, error) {
+			entries, err := os.ReadDir(filepath.Join(localDir, filepath.FromSlash(rel)))
+			names := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			return names, err
+		}
+		report.deleteExtraneous(extraneous, syncDest{
+			isDir: func(rel string) bool { return isDir[rel] },
+			list:  list,
+			remove: func(rel string, dir bool) error {
+				if dir {
+					names, err := list(rel)
+					if err == nil && len(names) > 0 {
+						return fmt.Errorf("%w: %q", ErrDirectoryNotEmpty, rel)
+					}
+				}
+				return os.Remove(filepath.Join(localDir, filepath.FromSlash(rel)))
+			},
+		}, params)
+	}
+
+	sort.Strings(report.Failed)
+	report.Duration = time.Since(start)
+	return report, report.err()
+}
+
+// localExtraneous is remoteIndex.extraneous for the local tree root: the
+// entries below rel not in keep, contents before their directory, with the
+// directories among them added to isDir.
+func localExtraneous(root, rel string, keep map[string]bool, params *transferParams, isDir map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local directory: %w", err)
+	}
+
+	var result []string
+	for _, entry := range entries {
+		child := entry.Name()
+		if rel != "." {
+			child = rel + "/" + child
+		}
+
+		if entry.IsDir() {
+			if matchAny(params.excludes, child) {
+				continue
+			}
+			nested, err := localExtraneous(root, child, keep, params, isDir)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, nested...)
+			if !keep[child] {
+				isDir[child] = true
+				result = append(result, child)
+			}
+			continue
+		}
+
+		if keep[child] || !params.selectsFile(child) {
+			continue
+		}
+		result = append(result, child)
+	}
+	return result, nil
+}
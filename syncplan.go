@@ -0,0 +1,369 @@
+package sftpc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// DefaultIndexBudget is the number of remote directory entries the sync
+// planner keeps in memory unless WithIndexBudget says otherwise.
+const DefaultIndexBudget = 1000000
+
+// WithIndexBudget caps how many remote directory entries the sync planner
+// holds in memory. Directories whose listing does not fit in the remaining
+// budget are not indexed; their files are looked up with one Stat each
+// instead. Zero disables the index altogether.
+func WithIndexBudget(entries int) TransferOption {
+	return func(params *transferParams) error {
+		if entries < 0 {
+			return fmt.Errorf("index budget must not be negative, got %d", entries)
+		}
+		params.indexBudget = entries
+		return nil
+	}
+}
+
+// indexedDir is the state of one remote directory in a remoteIndex.
+type indexedDir struct {
+	missing bool
+	// entries is nil when the directory was too large for the budget.
+	entries map[string]os.FileInfo
+}
+
+// remoteIndex answers existence and metadata questions about a remote tree
+// with one ReadDir per directory instead of one Stat per file. Directories
+// are listed lazily on first use and never twice.
+type remoteIndex struct {
+	client *SFTPClient
+	root   string
+	budget int
+	used   int
+	dirs   map[string]*indexedDir
+
+	// lists and stats count the round trips made to the server.
+	lists int
+	stats int
+}
+
+func (client *SFTPClient) newRemoteIndex(root string, budget int) *remoteIndex {
+	return &remoteIndex{
+		client: client,
+		root:   path.Clean(root),
+		budget: budget,
+		dirs:   make(map[string]*indexedDir),
+	}
+}
+
+func (idx *remoteIndex) remotePath(rel string) string {
+	if rel == "." {
+		return idx.root
+	}
+	return path.Join(idx.root, rel)
+}
+
+// readDir lists the directory rel, counting the round trip.
+func (idx *remoteIndex) readDir(rel string) ([]os.FileInfo, error) {
+	idx.lists++
+	entries, err := idx.client.readDir(idx.remotePath(rel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %q: %w", idx.remotePath(rel), err)
+	}
+	return entries, nil
+}
+
+// dir returns the indexed state of the directory rel, listing it if needed.
+// Directories below a missing one are known to be missing without asking
+// the server.
+func (idx *remoteIndex) dir(rel string) (*indexedDir, error) {
+	if d, ok := idx.dirs[rel]; ok {
+		return d, nil
+	}
+
+	d := &indexedDir{}
+	if rel != "." {
+		parent, err := idx.dir(path.Dir(rel))
+		if err != nil {
+			return nil, err
+		}
+		info, err := idx.lookupIn(parent, rel)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || !info.IsDir() {
+			d.missing = true
+			idx.dirs[rel] = d
+			return d, nil
+		}
+	}
+
+	entries, err := idx.readDir(rel)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		d.missing = true
+	case err != nil:
+		return nil, err
+	case idx.used+len(entries) <= idx.budget:
+		d.entries = make(map[string]os.FileInfo, len(entries))
+		for _, entry := range entries {
+			d.entries[entry.Name()] = entry
+		}
+		idx.used += len(entries)
+	}
+	idx.dirs[rel] = d
+	return d, nil
+}
+
+// lookupIn returns the entry rel of the directory d, or nil when it does not
+// exist. Symbolic links are resolved according to the symlink policy; the
+// listings used for extraneous entries keep them as links.
+func (idx *remoteIndex) lookupIn(d *indexedDir, rel string) (os.FileInfo, error) {
+	if d.missing {
+		return nil, nil
+	}
+
+	var info os.FileInfo
+	if d.entries != nil {
+		info = d.entries[path.Base(rel)]
+		if info == nil {
+			return nil, nil
+		}
+	} else {
+		idx.stats++
+		var err error
+		info, err = idx.client.sftpConn().Lstat(idx.remotePath(rel))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get remote file info: %w", err)
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 && idx.client.params.SymlinkPolicy() != SymlinkNoFollow {
+		idx.stats++
+	}
+	info, _, err := idx.client.followEntry(idx.remotePath(rel), info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// lookup returns the remote entry at rel, or nil when it does not exist.
+func (idx *remoteIndex) lookup(rel string) (os.FileInfo, error) {
+	d, err := idx.dir(path.Dir(rel))
+	if err != nil {
+		return nil, err
+	}
+	return idx.lookupIn(d, rel)
+}
+
+// children returns the sorted entries of the existing directory rel. Entries
+// of directories that did not fit in the budget are listed again and not
+// retained.
+func (idx *remoteIndex) children(rel string) ([]os.FileInfo, error) {
+	d, err := idx.dir(rel)
+	if err != nil {
+		return nil, err
+	}
+	if d.missing {
+		return nil, nil
+	}
+
+	var entries []os.FileInfo
+	if d.entries != nil {
+		for _, entry := range d.entries {
+			entries = append(entries, entry)
+		}
+	} else {
+		entries, err = idx.readDir(rel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// DiffReport compares a local tree with a remote one. All paths are
+// relative to the compared directories and slash separated.
+type DiffReport struct {
+	// Added are local files missing on the remote side.
+	Added []string
+	// Changed are files present on both sides whose size or modification
+	// time differ.
+	Changed   []string
+	Unchanged []string
+	// MissingDirs are directories missing on the remote side, parents
+	// first.
+	MissingDirs []string
+	// Extraneous are remote entries without a local counterpart, contents
+	// before their directory so they can be removed in order.
+	Extraneous []string
+	// Mapped gives the remote path of every local file when a PathMapper is
+	// used; the lists above hold local paths, except MissingDirs and
+	// Extraneous which are remote.
+	Mapped map[string]string
+
+	// RemoteLists and RemoteStats count the directory listings and single
+	// entry lookups sent to the server while planning.
+	RemoteLists int
+	RemoteStats int
+}
+
+// WithTimePrecision sets how far apart a local and a remote modification
+// time may be and still count as equal, for servers that keep times coarser
+// than the one second SFTP carries, such as 2s on FAT file systems.
+func WithTimePrecision(precision time.Duration) Options {
+	return func(params *SFTPClientParams) error {
+		if precision < time.Second {
+			return fmt.Errorf("time precision must be at least one second, got %s", precision)
+		}
+		params.timePrecision = precision
+		params.record("WithTimePrecision", precision.String())
+		return nil
+	}
+}
+
+// sameFile reports whether a local and a remote file are considered equal.
+// SFTP carries modification times with one second resolution; precision may
+// be coarser.
+func sameFile(local treeEntry, remote os.FileInfo, precision time.Duration) bool {
+	d := local.modTime.Truncate(time.Second).Sub(remote.ModTime().Truncate(time.Second))
+	return remote.Mode().IsRegular() &&
+		local.size == remote.Size() &&
+		d > -precision && d < precision
+}
+
+// DiffLocalRemote compares the files below localDir with the ones below
+// remoteDir by size and modification time. The remote tree is read with one
+// listing per directory; see WithIndexBudget for very large directories.
+// Include and exclude filters apply to both sides.
+func (client *SFTPClient) DiffLocalRemote(localDir, remoteDir string, opts ...TransferOption) (_ *DiffReport, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	defer client.track("DiffLocalRemote", remoteDir)(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.ensureConnected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	plan, err := localTreePlan(localDir, params)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.mapTargets(params.mapper, true)
+	if err != nil {
+		return nil, err
+	}
+	idx := client.newRemoteIndex(remoteDir, params.indexBudget)
+	return idx.diff(plan, params)
+}
+
+func (idx *remoteIndex) diff(plan *treePlan, params *transferParams) (*DiffReport, error) {
+	report := &DiffReport{}
+	local := map[string]bool{".": true}
+
+	dirs := plan.dirs
+	if plan.mapped {
+		dirs = plan.targetDirs()
+		report.Mapped = make(map[string]string, len(plan.files))
+	}
+	for _, dir := range dirs {
+		local[dir.rel] = true
+		info, err := idx.lookup(dir.rel)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || !info.IsDir() {
+			report.MissingDirs = append(report.MissingDirs, dir.rel)
+		}
+	}
+
+	for _, file := range plan.files {
+		local[file.target] = true
+		if plan.mapped {
+			report.Mapped[file.rel] = file.target
+		}
+		info, err := idx.lookup(file.target)
+		switch {
+		case err != nil:
+			return nil, err
+		case info == nil:
+			report.Added = append(report.Added, file.rel)
+		case sameFile(file, info, idx.client.params.TimePrecision()):
+			report.Unchanged = append(report.Unchanged, file.rel)
+		default:
+			report.Changed = append(report.Changed, file.rel)
+		}
+	}
+
+	extraneous, err := idx.extraneous(".", local, params)
+	if err != nil {
+		return nil, err
+	}
+	report.Extraneous = extraneous
+	report.RemoteLists = idx.lists
+	report.RemoteStats = idx.stats
+	return report, nil
+}
+
+// extraneous returns the remote entries below the directory rel that are not
+// in keep, contents before their directory. Entries hidden by the filters
+// are left alone.
+func (idx *remoteIndex) extraneous(rel string, keep map[string]bool, params *transferParams) ([]string, error) {
+	entries, err := idx.children(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, entry := range entries {
+		child := entry.Name()
+		if rel != "." {
+			child = rel + "/" + child
+		}
+
+		if entry.IsDir() {
+			if matchAny(params.excludes, child) {
+				continue
+			}
+			if keep[child] {
+				nested, err := idx.extraneous(child, keep, params)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, nested...)
+				continue
+			}
+			// Everything below a directory missing locally goes as well
+			nested, err := idx.extraneous(child, nil, params)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, nested...)
+			result = append(result, child)
+			continue
+		}
+
+		if keep[child] || !params.selectsFile(child) {
+			continue
+		}
+		result = append(result, child)
+	}
+	return result, nil
+}
@@ -0,0 +1,102 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Tracer starts a span for every public operation of a client, as an
+// adapter to OpenTelemetry or the like would. Start returns the context of
+// the span, which the operation runs under, and the function ending it with
+// the error the operation returned. The attributes of the operation are
+// read with OperationAttributes from the context returned by Start by the
+// time the span ends.
+type Tracer interface {
+	Start(ctx context.Context, op string) (context.Context, func(err error))
+}
+
+// SpanAttributes describe a traced operation: the server, the remote path
+// it was called with, the bytes of file data transferred and whether the
+// client reconnected while it ran.
+type SpanAttributes struct {
+	Host        string
+	Path        string
+	Bytes       int64
+	Reconnected bool
+}
+
+// WithTracer starts a span with t for every public operation.
+func WithTracer(t Tracer) Options {
+	return func(params *SFTPClientParams) error {
+		if t == nil {
+			return fmt.Errorf("tracer must not be nil")
+		}
+		params.tracer = t
+		params.record("WithTracer", fmt.Sprintf("%p", t))
+		return nil
+	}
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+
+type operationKey struct{}
+
+// operation holds the attributes of the public operation running under a
+// context, updated as it goes.
+type operation struct {
+	mu    sync.Mutex
+	attrs SpanAttributes
+}
+
+// OperationAttributes returns the attributes of the operation ctx belongs
+// to, zero outside of one.
+func OperationAttributes(ctx context.Context) SpanAttributes {
+	op, ok := ctx.Value(operationKey{}).(*operation)
+	if !ok {
+		return SpanAttributes{}
+	}
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.attrs
+}
+
+// trace starts the public operation name on remotePath under ctx. It
+// returns the context to run it under and the function to call with the
+// error it returns, which wraps it in an *OpError, ends its span and
+// reports it to the metrics:
+//
+//	ctx, done := client.trace(ctx, "Get", remotePath)
+//	defer done(&err)
+func (client *SFTPClient) trace(ctx context.Context, name, remotePath string) (context.Context, func(errp *error)) {
+	if client == nil {
+		return ctx, func(*error) {}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	op := &operation{attrs: SpanAttributes{Host: client.params.Host(), Path: remotePath}}
+	ctx, end := client.params.Tracer().Start(context.WithValue(ctx, operationKey{}, op), name)
+	start, seen := time.Now(), client.reconnectCount()
+	return ctx, func(errp *error) {
+		*errp = client.operationError(name, remotePath, *errp)
+		op.mu.Lock()
+		op.attrs.Reconnected = client.reconnectCount() != seen
+		op.mu.Unlock()
+		client.params.Metrics().ObserveOperation(name, time.Since(start), *errp)
+		end(*errp)
+	}
+}
+
+// track is trace for operations that do not need its context:
+//
+//	defer client.track("List", remotePath)(&err)
+func (client *SFTPClient) track(name, remotePath string) func(errp *error) {
+	_, done := client.trace(context.Background(), name, remotePath)
+	return done
+}
@@ -0,0 +1,574 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// TransferOption configures a single transfer operation.
+type TransferOption func(*transferParams) error
+
+type transferParams struct {
+	resume                 bool
+	strictResume           bool
+	overwrite              bool
+	autoDecompress         bool
+	stripCompressionSuffix bool
+	verify                 bool
+	progress               func(ProgressInfo)
+
+	includes        []string
+	excludes        []string
+	pruneEmptyDirs  bool
+	createEmptyDirs bool
+	followLocal     bool
+	stopOnError     bool
+	preserveTimes   bool
+
+	atomic          bool
+	autoTempCleanup bool
+
+	indexBudget int
+
+	// ctx, when set, aborts the transfer once done.
+	ctx    context.Context
+	events func(TransferEvent)
+	window *transferWindow
+
+	idleKeepAlive time.Duration
+	readAhead     int
+
+	partialSuffixes []string
+	mapper          PathMapper
+
+	// label is the bandwidth sharing label, see WithTransferLabel.
+	label string
+
+	sampling *samplingParams
+
+	replayBuffer int64
+
+	// retryPolicy overrides the one of the client, see
+	// WithTransferRetryPolicy.
+	retryPolicy *RetryPolicy
+
+	manifestChecksums bool
+
+	// dryRun plans the transfers without making them, see WithDryRun.
+	dryRun bool
+
+	// opened, when set, receives the destination path once it was opened
+	// for writing, see runItem.
+	opened *string
+}
+
+func newTransferParams(opts ...TransferOption) (*transferParams, error) {
+	params := &transferParams{indexBudget: DefaultIndexBudget}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// WithWriteOnly is for drop boxes that refuse to stat uploaded files. Failed
+// uploads then restart from scratch instead of from the size the server
+// confirms, and WithResume is refused.
+func WithWriteOnly() Options {
+	return func(params *SFTPClientParams) error {
+		params.writeOnly = true
+		params.record("WithWriteOnly", "true")
+		return nil
+	}
+}
+
+// WithResume continues a previous partial transfer from the size of the
+// existing destination file instead of starting over. A destination larger
+// than the source is transferred again from scratch, unless
+// WithStrictResume.
+func WithResume() TransferOption {
+	return func(params *transferParams) error {
+		params.resume = true
+		return nil
+	}
+}
+
+// WithStrictResume is WithResume failing with ErrResumeMismatch, and leaving
+// the destination alone, when it is larger than the source.
+func WithStrictResume() TransferOption {
+	return func(params *transferParams) error {
+		params.resume = true
+		params.strictResume = true
+		return nil
+	}
+}
+
+// WithOverwrite allows replacing an existing destination file in modes that
+// refuse to touch it by default.
+func WithOverwrite() TransferOption {
+	return func(params *transferParams) error {
+		params.overwrite = true
+		return nil
+	}
+}
+
+// WithDryRun makes UploadDir, DownloadDir and DownloadByManifest compare and
+// plan as usual without writing anything: no file is opened for writing and
+// no directory created. The files they would transfer are recorded with
+// StatusPlanned and DirsCreated counts the directories they would create.
+// Operations that cannot plan without writing, such as Get or Upload, fail
+// with ErrDryRun. See WithSyncDryRun and WithRemoveDryRun.
+func WithDryRun() TransferOption {
+	return func(params *transferParams) error {
+		params.dryRun = true
+		return nil
+	}
+}
+
+// markOpened records p as the destination opened for writing.
+func (params *transferParams) markOpened(p string) {
+	if params.opened != nil {
+		*params.opened = p
+	}
+}
+
+// refuseDryRun fails op under WithDryRun.
+func (params *transferParams) refuseDryRun(op string) error {
+	if params.dryRun {
+		return fmt.Errorf("%s: %w", op, ErrDryRun)
+	}
+	return nil
+}
+
+// TransferStats describes the outcome of a single transfer.
+type TransferStats struct {
+	RemotePath       string
+	LocalPath        string
+	BytesTransferred int64
+	TotalSize        int64
+	StartOffset      int64
+	Resumed          bool
+	Attempts         int
+	Duration         time.Duration
+
+	// CompressedBytes and UncompressedBytes are only set when the remote
+	// stream was decompressed on the fly (see WithAutoDecompress).
+	CompressedBytes   int64
+	UncompressedBytes int64
+
+	// Checksum is the verified digest, set by WithVerifyChecksum.
+	Checksum       string
+	PhaseDurations map[Phase]time.Duration
+
+	// ReplayedBytes counts the bytes sent again from the replay buffer, see
+	// WithReplayBuffer.
+	ReplayedBytes int64
+}
+
+// AverageThroughput returns the bytes transferred per second over the
+// whole duration, retries and reconnects included.
+func (stats *TransferStats) AverageThroughput() float64 {
+	if stats.Duration <= 0 {
+		return 0
+	}
+	return float64(stats.BytesTransferred) / stats.Duration.Seconds()
+}
+
+// bytes returns the bytes transferred, zero for nil stats.
+func (stats *TransferStats) bytes() int64 {
+	if stats == nil {
+		return 0
+	}
+	return stats.BytesTransferred
+}
+
+func (stats *TransferStats) addPhaseDuration(phase Phase, d time.Duration) {
+	if stats.PhaseDurations == nil {
+		stats.PhaseDurations = make(map[Phase]time.Duration)
+	}
+	stats.PhaseDurations[phase] += d
+}
+
+// Get downloads remotePath into localPath and reports what was transferred.
+func (client *SFTPClient) Get(remotePath, localPath string, opts ...TransferOption) (*TransferStats, error) {
+	return client.GetContext(context.Background(), remotePath, localPath, opts...)
+}
+
+// GetContext is Get giving up once ctx is done.
+func (client *SFTPClient) GetContext(ctx context.Context, remotePath, localPath string, opts ...TransferOption) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "Get", remotePath)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	params.ctx = ctx
+	err = client.ensureConnectedContext(ctx)
+	if err != nil {
+		return nil, deadlineError(ctx, fmt.Errorf("failed to reconnect: %w", err))
+	}
+
+	stats, err := client.get(remotePath, localPath, params)
+	return stats, deadlineError(ctx, err)
+}
+
+func (client *SFTPClient) get(remotePath, localPath string, params *transferParams) (*TransferStats, error) {
+	if err := params.refuseDryRun("Get"); err != nil {
+		return nil, err
+	}
+	if params.resume && params.autoDecompress {
+		return nil, fmt.Errorf("%w: auto-decompression rewrites the stream, remove WithResume", ErrResumeUnsupported)
+	}
+
+	release, err := client.startTransfer(params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	stats := &TransferStats{RemotePath: remotePath, LocalPath: localPath}
+
+	remoteFileInfo, err := client.statSource(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote file info: %w", err)
+	}
+	stats.TotalSize = remoteFileInfo.Size()
+
+	if params.resume {
+		localFileInfo, err := os.Stat(localPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to get local file info: %w", quotePath(err))
+		}
+		// A larger local file means the remote file was replaced with a
+		// smaller one, start over
+		if err == nil && localFileInfo.Size() > stats.TotalSize && params.strictResume {
+			return nil, fmt.Errorf("%w: local file has %d bytes, remote file %d", ErrResumeMismatch, localFileInfo.Size(), stats.TotalSize)
+		}
+		if err == nil && localFileInfo.Size() <= stats.TotalSize {
+			stats.StartOffset = localFileInfo.Size()
+			stats.Resumed = stats.StartOffset > 0
+			if stats.Resumed && client.params.ResumeVerification() > 0 {
+				match, err := client.downloadTailMatches(params.context(), remotePath, localPath, stats.StartOffset)
+				if err != nil {
+					return nil, err
+				}
+				if !match {
+					client.params.Logger().Infof("Partial file %q differs from the remote file, starting over", localPath)
+					stats.StartOffset = 0
+					stats.Resumed = false
+				}
+			}
+			if stats.StartOffset == stats.TotalSize {
+				params.reportComplete(remotePath, stats.TotalSize)
+				stats.Duration = time.Since(start)
+				return stats, nil
+			}
+		}
+	}
+
+	remoteFile, err := client.openRemote(params.context(), remotePath, os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remote file: %w", client.opError("open", remotePath, err))
+	}
+	defer remoteFile.Close()
+
+	if stats.StartOffset > 0 {
+		_, err = remoteFile.Seek(stats.StartOffset, io.SeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seek in remote file: %w", err)
+		}
+	}
+
+	var src io.Reader = remoteFile
+	var compressed *countingReader
+	if params.autoDecompress {
+		compressed = &countingReader{r: remoteFile}
+		decoded, decoder, err := decompressStream(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress remote file %q: %w", remotePath, err)
+		}
+		src = decoded
+		if decoder != nil && params.stripCompressionSuffix {
+			localPath = decoder.stripSuffix(localPath)
+			stats.LocalPath = localPath
+		}
+	}
+
+	var localFile *os.File
+	if stats.StartOffset > 0 {
+		localFile, err = os.OpenFile(localPath, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		localFile, err = os.Create(localPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open or create local file: %w", quotePath(err))
+	}
+	defer localFile.Close()
+	params.markOpened(localPath)
+
+	total := stats.TotalSize
+	if compressed != nil {
+		// The decompressed size is unknown up front
+		total = -1
+	}
+	src = newProgressReader(client.source(src, params), ProgressInfo{
+		Phase:       PhaseTransfer,
+		Path:        remotePath,
+		Transferred: stats.StartOffset,
+		Total:       total,
+	}, params.progress)
+
+	n, err := io.Copy(localFile, src)
+	client.addBytes(params.context(), DirectionDownload, n)
+	stats.BytesTransferred = n
+	if compressed != nil {
+		stats.CompressedBytes = compressed.n
+		stats.UncompressedBytes = n
+	}
+	stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+	if err != nil {
+		stats.Duration = time.Since(start)
+		return stats, fmt.Errorf("failed to copy file to local: %w", err)
+	}
+
+	// Release the handle before verification opens its own, so that a
+	// handle limit of one cannot deadlock
+	remoteFile.Close()
+
+	if params.verify {
+		err = localFile.Sync()
+		if err != nil {
+			return stats, fmt.Errorf("failed to sync local file: %w", err)
+		}
+		err = client.verifyDownload(remotePath, localPath, params, stats)
+		if err != nil {
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+	}
+
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Put uploads localPath to remotePath and reports what was transferred.
+//
+// When the connection drops mid-transfer (including SSH re-key and channel
+// failures) the client reconnects and re-stats the remote file, continuing
+// from the size the server actually confirms rather than from the number of
+// bytes written before the failure.
+func (client *SFTPClient) Put(localPath, remotePath string, opts ...TransferOption) (*TransferStats, error) {
+	return client.PutContext(context.Background(), localPath, remotePath, opts...)
+}
+
+// PutContext is Put giving up once ctx is done.
+func (client *SFTPClient) PutContext(ctx context.Context, localPath, remotePath string, opts ...TransferOption) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "Put", remotePath)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	params.ctx = ctx
+	err = client.ensureConnectedContext(ctx)
+	if err != nil {
+		return nil, deadlineError(ctx, fmt.Errorf("failed to reconnect: %w", err))
+	}
+
+	stats, err := client.put(localPath, remotePath, params)
+	return stats, deadlineError(ctx, err)
+}
+
+func (client *SFTPClient) put(localPath, remotePath string, params *transferParams) (*TransferStats, error) {
+	if err := params.refuseDryRun("Put"); err != nil {
+		return nil, err
+	}
+	if params.resume && client.params.WriteOnly() {
+		return nil, fmt.Errorf("%w: the server does not stat uploaded files, see WithWriteOnly", ErrResumeUnsupported)
+	}
+
+	release, err := client.startTransfer(params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if params.atomic {
+		return client.putAtomic(localPath, remotePath, params)
+	}
+	return client.putFile(localPath, remotePath, params)
+}
+
+func (client *SFTPClient) putFile(localPath, remotePath string, params *transferParams) (*TransferStats, error) {
+	start := time.Now()
+	stats := &TransferStats{RemotePath: remotePath, LocalPath: localPath}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local file: %w", quotePath(err))
+	}
+	defer localFile.Close()
+
+	localFileInfo, err := localFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local file info: %w", err)
+	}
+	stats.TotalSize = localFileInfo.Size()
+
+	truncate := true
+	if params.resume {
+		remoteFileInfo, err := client.sftpConn().Stat(remotePath)
+		if err == nil && remoteFileInfo.Size() > stats.TotalSize && params.strictResume {
+			return nil, fmt.Errorf("%w: remote file has %d bytes, local file %d", ErrResumeMismatch, remoteFileInfo.Size(), stats.TotalSize)
+		}
+		if err == nil && remoteFileInfo.Size() <= stats.TotalSize {
+			stats.StartOffset = remoteFileInfo.Size()
+			stats.Resumed = stats.StartOffset > 0
+			truncate = false
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to get remote file info: %w", err)
+		}
+		if stats.Resumed && client.params.ResumeVerification() > 0 {
+			match, err := client.tailMatches(params.context(), remotePath, localFile, stats.StartOffset)
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				client.params.Logger().Infof("Partial file %q differs from the local file, starting over", remotePath)
+				stats.StartOffset = 0
+				stats.Resumed = false
+				truncate = true
+			}
+		}
+		if !truncate && stats.StartOffset == stats.TotalSize {
+			params.reportComplete(remotePath, stats.TotalSize)
+			stats.Duration = time.Since(start)
+			return stats, nil
+		}
+	}
+
+	policy := client.retryPolicy(params)
+	offset := stats.StartOffset
+	for attempt := 1; ; attempt++ {
+		stats.Attempts = attempt
+		n, err := client.putAt(localFile, remotePath, offset, truncate, params, stats.TotalSize)
+		stats.BytesTransferred += n
+		if err == nil {
+			break
+		}
+		if attempt >= policy.MaxAttempts || !IsRetryable(err) {
+			stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+
+		client.params.Logger().Warnf("Upload failed, retrying... attempt %d: %v", attempt, err)
+		err = client.pause(params.context(), policy.Backoff().Delay(attempt), err)
+		if err != nil {
+			stats.Duration = time.Since(start)
+			return stats, err
+		}
+		err = client.ensureConnectedContext(params.context())
+		if err != nil {
+			stats.Duration = time.Since(start)
+			return stats, fmt.Errorf("failed to reconnect: %w", err)
+		}
+
+		// Bytes written before the failure may not have landed, trust only
+		// what the server reports
+		if client.params.WriteOnly() {
+			offset = 0
+			truncate = true
+			continue
+		}
+		remoteFileInfo, err := client.sftpConn().Stat(remotePath)
+		switch {
+		case err == nil && remoteFileInfo.Size() <= stats.TotalSize:
+			offset = remoteFileInfo.Size()
+			truncate = false
+		case err == nil || os.IsNotExist(err):
+			offset = 0
+			truncate = true
+		default:
+			stats.Duration = time.Since(start)
+			return stats, fmt.Errorf("failed to get remote file info: %w", err)
+		}
+	}
+
+	stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// putAt uploads the local file from offset onwards into the remote file at
+// the same offset.
+func (client *SFTPClient) putAt(localFile *os.File, remotePath string, offset int64, truncate bool, params *transferParams, total int64) (int64, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+
+	remoteFile, err := client.openRemote(params.context(), remotePath, flags)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open or create remote file: %w", client.opError("open", remotePath, err))
+	}
+	defer remoteFile.Close()
+	params.markOpened(remotePath)
+
+	_, err = remoteFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek in remote file: %w", err)
+	}
+	_, err = localFile.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek in local file: %w", err)
+	}
+
+	src := newProgressReader(client.source(localFile, params), ProgressInfo{
+		Phase:       PhaseTransfer,
+		Path:        remotePath,
+		Transferred: offset,
+		Total:       total,
+	}, params.progress)
+
+	n, err := io.Copy(remoteFile, src)
+	client.addBytes(params.context(), DirectionUpload, n)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy file to remote: %w", client.opError("write", remotePath, err))
+	}
+
+	err = remoteFile.Close()
+	if err != nil {
+		return n, fmt.Errorf("failed to close remote file: %w", client.opError("close", remotePath, err))
+	}
+	return n, nil
+}
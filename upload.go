@@ -0,0 +1,237 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// streamChunkSize is the size of the chunks read from the source of a
+// reader-based upload.
+const streamChunkSize = 32 * 1024
+
+// WithIdleChunkKeepAlive keeps the session of a reader-based upload alive
+// while its source stalls: whenever no data arrived for the interval, the
+// open remote handle is stat'ed. A failing probe only fails the upload if
+// the next write fails too.
+func WithIdleChunkKeepAlive(interval time.Duration) TransferOption {
+	return func(params *transferParams) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid keepalive interval: %s", interval)
+		}
+		params.idleKeepAlive = interval
+		return nil
+	}
+}
+
+// WithReadAhead lets a reader-based upload read up to n bytes ahead of the
+// remote writes, to smooth out a bursty source.
+func WithReadAhead(n int) TransferOption {
+	return func(params *transferParams) error {
+		if n < 0 {
+			return fmt.Errorf("invalid read-ahead size: %d", n)
+		}
+		params.readAhead = n
+		return nil
+	}
+}
+
+// Upload streams r into remotePath, replacing it. The length of r does not
+// need to be known, so progress reports a Total of -1. See
+// WithIdleChunkKeepAlive and WithReadAhead for slow or bursty sources, and
+// WithReplayBuffer to survive connection failures. With
+// either of them, r is read by a separate goroutine that returns once a
+// pending Read does, even when the upload already failed.
+func (client *SFTPClient) Upload(r io.Reader, remotePath string, opts ...TransferOption) (*TransferStats, error) {
+	return client.UploadContext(context.Background(), r, remotePath, opts...)
+}
+
+// UploadContext is Upload giving up once ctx is done.
+func (client *SFTPClient) UploadContext(ctx context.Context, r io.Reader, remotePath string, opts ...TransferOption) (_ *TransferStats, err error) {
+	if client == nil {
+		return nil, fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "Upload", remotePath)
+	defer done(&err)
+
+	params, err := newTransferParams(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.refuseDryRun("Upload"); err != nil {
+		return nil, err
+	}
+	if params.resume {
+		return nil, fmt.Errorf("%w: a reader cannot be rewound, remove WithResume", ErrResumeUnsupported)
+	}
+	if params.atomic || params.autoTempCleanup || params.verify {
+		return nil, fmt.Errorf("atomic, temporary file and verification options do not apply to a reader")
+	}
+	if params.replayBuffer > 0 && (params.idleKeepAlive > 0 || params.readAhead > 0) {
+		return nil, fmt.Errorf("the replay buffer does not combine with read-ahead or idle keepalive")
+	}
+	if params.replayBuffer > 0 && client.params.WriteOnly() {
+		return nil, fmt.Errorf("the replay buffer needs the server to stat uploaded files, see WithWriteOnly")
+	}
+
+	ctx, cancel := client.operationContext(ctx)
+	defer cancel()
+	params.ctx = ctx
+	stats, err := client.upload(r, remotePath, params)
+	return stats, deadlineError(ctx, err)
+}
+
+func (client *SFTPClient) upload(r io.Reader, remotePath string, params *transferParams) (*TransferStats, error) {
+	err := client.ensureConnectedContext(params.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	release, err := client.startTransfer(params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	stats := &TransferStats{RemotePath: remotePath, TotalSize: -1, Attempts: 1}
+
+	if params.replayBuffer > 0 {
+		src := newProgressReader(client.source(r, params), ProgressInfo{
+			Phase: PhaseTransfer,
+			Path:  remotePath,
+			Total: -1,
+		}, params.progress)
+		err = client.uploadReplay(src, remotePath, params, stats)
+		stats.TotalSize = stats.BytesTransferred
+		stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+		stats.Duration = time.Since(start)
+		return stats, err
+	}
+
+	remoteFile, err := client.openRemote(params.context(), remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open or create remote file: %w", client.opError("open", remotePath, err))
+	}
+	defer remoteFile.Close()
+
+	src := newProgressReader(client.source(r, params), ProgressInfo{
+		Phase: PhaseTransfer,
+		Path:  remotePath,
+		Total: -1,
+	}, params.progress)
+
+	if params.idleKeepAlive > 0 || params.readAhead > 0 {
+		stats.BytesTransferred, err = pumpStream(remoteFile, src, params)
+	} else {
+		stats.BytesTransferred, err = io.Copy(remoteFile, src)
+		if err != nil {
+			err = fmt.Errorf("failed to copy stream to remote: %w", client.opError("write", remotePath, err))
+		}
+	}
+	client.addBytes(params.context(), DirectionUpload, stats.BytesTransferred)
+	if err == nil {
+		err = remoteFile.Close()
+		if err != nil {
+			err = fmt.Errorf("failed to close remote file: %w", err)
+		}
+	}
+
+	stats.TotalSize = stats.BytesTransferred
+	stats.addPhaseDuration(PhaseTransfer, time.Since(start))
+	stats.Duration = time.Since(start)
+	return stats, err
+}
+
+type streamChunk struct {
+	data []byte
+	err  error
+}
+
+// pumpStream reads src in its own goroutine, up to params.readAhead bytes
+// ahead, and writes the chunks to dst, probing dst while src stalls.
+func pumpStream(dst *remoteFile, src io.Reader, params *transferParams) (int64, error) {
+	depth := params.readAhead / streamChunkSize
+	chunks := make(chan streamChunk, depth)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			buf := make([]byte, streamChunkSize)
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- streamChunk{data: buf[:n]}:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				select {
+				case chunks <- streamChunk{err: err}:
+				case <-done:
+				}
+				return
+			}
+		}
+	}()
+
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if params.idleKeepAlive > 0 {
+		timer = time.NewTimer(params.idleKeepAlive)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	resetIdle := func() {
+		if timer == nil {
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(params.idleKeepAlive)
+	}
+	var cancelled <-chan struct{}
+	if params.ctx != nil {
+		cancelled = params.ctx.Done()
+	}
+
+	var written int64
+	var probeErr error
+	for {
+		select {
+		case chunk := <-chunks:
+			if chunk.err == io.EOF {
+				return written, nil
+			}
+			if chunk.err != nil {
+				return written, fmt.Errorf("failed to read source stream: %w", chunk.err)
+			}
+			n, err := dst.Write(chunk.data)
+			written += int64(n)
+			if err != nil {
+				if probeErr != nil {
+					return written, fmt.Errorf("failed to copy stream to remote: %w (keepalive probe failed before: %v)", err, probeErr)
+				}
+				return written, fmt.Errorf("failed to copy stream to remote: %w", err)
+			}
+			probeErr = nil
+			resetIdle()
+		case <-idle:
+			_, err := dst.Stat()
+			if err != nil {
+				probeErr = err
+			}
+			timer.Reset(params.idleKeepAlive)
+		case <-cancelled:
+			return written, params.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package sftpc
+
+import "strings"
+
+// DefaultPartialSuffixes are the suffixes other clients give files they are
+// still uploading: WinSCP writes "name.filepart" and lftp keeps
+// "name.lftp-pget-status" next to the file it is writing. The "name.part"
+// several other tools use is too common a suffix for finished files to be
+// assumed, pass it to WithUploaderConventions when a partner uses it.
+var DefaultPartialSuffixes = []string{".filepart", ".lftp-pget-status"}
+
+// WithUploaderConventions makes DownloadDir ignore files that other clients
+// are still uploading, recognized by DefaultPartialSuffixes plus the extra
+// suffixes given. A file whose partial sibling still exists, such as "a.csv"
+// next to "a.csv.filepart", is not downloaded but recorded with
+// StatusDeferred, to be picked up by a later run; the partial file itself is
+// recorded with StatusSkipped. Both carry ErrUploadInProgress.
+func WithUploaderConventions(extraSuffixes ...string) TransferOption {
+	return func(params *transferParams) error {
+		params.partialSuffixes = append(append([]string{}, DefaultPartialSuffixes...), extraSuffixes...)
+		return nil
+	}
+}
+
+// partialOf returns the name of the file rel is a partial upload of, or
+// false if it has no partial suffix.
+func (params *transferParams) partialOf(rel string) (string, bool) {
+	for _, suffix := range params.partialSuffixes {
+		if name, ok := strings.CutSuffix(rel, suffix); ok && name != "" && !strings.HasSuffix(name, "/") {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,178 @@
+package sftpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// WithVerifyChecksum re-reads both sides after the transfer and compares
+// their SHA-256 digests, failing with ErrChecksumMismatch when they differ.
+// Progress for this pass is reported with PhaseVerify.
+func WithVerifyChecksum() TransferOption {
+	return func(params *transferParams) error {
+		params.verify = true
+		return nil
+	}
+}
+
+// verifyDownload hashes the remote file and the local copy in lockstep so
+// that the verify progress reflects the bytes actually compared.
+func (client *SFTPClient) verifyDownload(remotePath, localPath string, params *transferParams, stats *TransferStats) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file for verification: %w", quotePath(err))
+	}
+	defer localFile.Close()
+
+	return client.verifyFile(remotePath, localFile, params, stats)
+}
+
+// verifyFile compares the remote file with the whole content of local,
+// reading it with ReadAt so that the file offset is left untouched.
+func (client *SFTPClient) verifyFile(remotePath string, local *os.File, params *transferParams, stats *TransferStats) error {
+	start := time.Now()
+	defer func() {
+		stats.addPhaseDuration(PhaseVerify, time.Since(start))
+	}()
+
+	localFileInfo, err := local.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get local file info: %w", err)
+	}
+
+	remoteFile, err := client.openRemote(params.context(), remotePath, os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file for verification: %w", err)
+	}
+	defer remoteFile.Close()
+
+	var remote io.Reader = remoteFile
+	if params.autoDecompress {
+		remote, _, err = decompressStream(remoteFile)
+		if err != nil {
+			return fmt.Errorf("failed to decompress remote file %q: %w", remotePath, err)
+		}
+	}
+
+	remoteSum, localSum, err := hashLockstep(remote, io.NewSectionReader(local, 0, localFileInfo.Size()), ProgressInfo{
+		Phase: PhaseVerify,
+		Path:  remotePath,
+		Total: localFileInfo.Size(),
+	}, params.progress)
+	if err != nil {
+		return err
+	}
+
+	if remoteSum != localSum {
+		return fmt.Errorf("%w: remote %q sha256:%s, local %q sha256:%s", ErrChecksumMismatch, remotePath, remoteSum, local.Name(), localSum)
+	}
+	stats.Checksum = "sha256:" + localSum
+	return nil
+}
+
+// hashLockstep reads a and b chunk by chunk, hashing both, and reports the
+// number of bytes hashed on each side after every chunk.
+func hashLockstep(a, b io.Reader, info ProgressInfo, report func(ProgressInfo)) (string, string, error) {
+	hashA := sha256.New()
+	hashB := sha256.New()
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	rate := newRateMeter(info.Transferred, time.Now())
+
+	for {
+		n, readErr := io.ReadFull(a, bufA)
+		if n > 0 {
+			hashA.Write(bufA[:n])
+			m, err := io.ReadFull(b, bufB[:n])
+			hashB.Write(bufB[:m])
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return "", "", fmt.Errorf("failed to read local file for verification: %w", err)
+			}
+
+			info.Transferred += int64(n)
+			if report != nil {
+				rate.update(&info, time.Now())
+				report(info)
+			}
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", "", fmt.Errorf("failed to read remote file for verification: %w", readErr)
+		}
+	}
+
+	// Anything left on the b side is extra data that must count in its digest
+	_, err := io.CopyBuffer(hashB, b, bufB)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read local file for verification: %w", err)
+	}
+
+	return hex.EncodeToString(hashA.Sum(nil)), hex.EncodeToString(hashB.Sum(nil)), nil
+}
+
+// DefaultResumeVerification is the window WithResumeVerification compares
+// when given zero.
+const DefaultResumeVerification = 64 << 10
+
+// WithResumeVerification compares the last window bytes of the partial
+// destination with the same range of the source before resuming a
+// transfer, and starts over when they differ, as when the source was
+// changed in place. Zero means DefaultResumeVerification.
+func WithResumeVerification(window int64) Options {
+	return func(params *SFTPClientParams) error {
+		if window < 0 {
+			return fmt.Errorf("invalid resume verification window: %d", window)
+		}
+		if window == 0 {
+			window = DefaultResumeVerification
+		}
+		params.resumeVerification = window
+		params.record("WithResumeVerification", strconv.FormatInt(window, 10))
+		return nil
+	}
+}
+
+// downloadTailMatches is tailMatches for the partial local file at
+// localPath.
+func (client *SFTPClient) downloadTailMatches(ctx context.Context, remotePath, localPath string, offset int64) (bool, error) {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open local file for verification: %w", quotePath(err))
+	}
+	defer localFile.Close()
+
+	return client.tailMatches(ctx, remotePath, localFile, offset)
+}
+
+// tailMatches reports whether the remote and the local file hold the same
+// bytes in the resume verification window ending at offset.
+func (client *SFTPClient) tailMatches(ctx context.Context, remotePath string, local io.ReaderAt, offset int64) (bool, error) {
+	window := min(client.params.ResumeVerification(), offset)
+	remoteFile, err := client.openRemote(ctx, remotePath, os.O_RDONLY)
+	if err != nil {
+		return false, fmt.Errorf("failed to open remote file for verification: %w", client.opError("open", remotePath, err))
+	}
+	defer remoteFile.Close()
+
+	remoteTail := make([]byte, window)
+	_, err = remoteFile.ReadAt(remoteTail, offset-window)
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read remote file for verification: %w", err)
+	}
+	localTail := make([]byte, window)
+	_, err = local.ReadAt(localTail, offset-window)
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read local file for verification: %w", err)
+	}
+	return bytes.Equal(remoteTail, localTail), nil
+}
@@ -0,0 +1,210 @@
+package sftpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// RemoteFileInfo is an os.FileInfo enriched with the full remote path and
+// the ownership reported by the server.
+type RemoteFileInfo struct {
+	os.FileInfo
+	Path string
+	// Symlink is set for a symbolic link; FileInfo then describes its
+	// target unless the policy is SymlinkNoFollow or the link is dangling.
+	Symlink bool
+}
+
+// UID returns the numeric owner, or 0 when the server did not report it.
+func (i RemoteFileInfo) UID() uint32 {
+	if stat, ok := i.Sys().(*sftp.FileStat); ok {
+		return stat.UID
+	}
+	return 0
+}
+
+// GID returns the numeric group, or 0 when the server did not report it.
+func (i RemoteFileInfo) GID() uint32 {
+	if stat, ok := i.Sys().(*sftp.FileStat); ok {
+		return stat.GID
+	}
+	return 0
+}
+
+// WalkOption configures Walk and the operations built on top of it.
+type WalkOption func(*walkParams) error
+
+type walkParams struct {
+	sorted          bool
+	checksum        bool
+	checksumMaxSize int64
+
+	newest   int
+	previous *TreeSnapshot
+	maxAge   time.Duration
+
+	// ctx stops the walk once done, nil for none.
+	ctx context.Context
+	// denied, when set, is told about the directories that cannot be
+	// listed for lack of permission, which the walk then skips.
+	denied func(dir string, err error)
+}
+
+// context returns the context of the walk, never nil.
+func (params *walkParams) context() context.Context {
+	if params.ctx == nil {
+		return context.Background()
+	}
+	return params.ctx
+}
+
+// stopped returns the error of the context of the walk, if done.
+func (params *walkParams) stopped() error {
+	return params.context().Err()
+}
+
+func newWalkParams(opts ...WalkOption) (*walkParams, error) {
+	params := &walkParams{newest: DefaultSnapshotNewest}
+	for _, opt := range opts {
+		if err := opt(params); err != nil {
+			return nil, err
+		}
+	}
+	return params, nil
+}
+
+// WithSortedWalk visits the entries of every directory in lexical order so
+// that repeated walks over the same tree produce the same sequence.
+func WithSortedWalk() WalkOption {
+	return func(params *walkParams) error {
+		params.sorted = true
+		return nil
+	}
+}
+
+// Walk visits every entry below root depth-first, calling fn for each of
+// them before descending into directories. Returning fs.SkipDir from fn for
+// a directory skips its contents. Listing errors stop the walk. Symbolic
+// links are resolved according to WithSymlinkPolicy and linked directories
+// are not descended into.
+func (client *SFTPClient) Walk(root string, fn func(info RemoteFileInfo) error, opts ...WalkOption) error {
+	return client.WalkContext(context.Background(), root, fn, opts...)
+}
+
+// WalkContext is Walk giving up once ctx is done.
+func (client *SFTPClient) WalkContext(ctx context.Context, root string, fn func(info RemoteFileInfo) error, opts ...WalkOption) (err error) {
+	if client == nil {
+		return fmt.Errorf("SFTPClient is nil")
+	}
+	ctx, done := client.trace(ctx, "Walk", root)
+	defer done(&err)
+
+	params, err := newWalkParams(opts...)
+	if err != nil {
+		return err
+	}
+	params.ctx = ctx
+
+	err = client.ensureConnectedOp(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect: %w", err)
+	}
+
+	return client.walk(root, params, fn)
+}
+
+func (client *SFTPClient) walk(dir string, params *walkParams, fn func(info RemoteFileInfo) error) error {
+	if err := params.stopped(); err != nil {
+		return err
+	}
+	entries, err := client.listDir(params.context(), dir)
+	if err != nil && params.denied != nil && errors.Is(err, fs.ErrPermission) {
+		params.denied(dir, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list directory %q: %w", dir, err)
+	}
+
+	if params.sorted {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+
+	for _, entry := range entries {
+		info := RemoteFileInfo{Path: path.Join(dir, entry.Name())}
+		info.FileInfo, info.Symlink, err = client.followEntry(info.Path, entry)
+		if err != nil {
+			return err
+		}
+		if err := params.stopped(); err != nil {
+			return err
+		}
+		err = fn(info)
+		if err == fs.SkipDir && info.IsDir() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && !info.Symlink {
+			err = client.walk(info.Path, params, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readDir lists the remote directory p without the "." and ".." entries
+// some servers return, which would make every recursive operation loop.
+// All listings go through it.
+func (client *SFTPClient) readDir(p string) ([]os.FileInfo, error) {
+	return client.listDir(context.Background(), p)
+}
+
+// listDir is readDir giving up once ctx is done, also in the middle of a
+// listing.
+func (client *SFTPClient) listDir(ctx context.Context, p string) ([]os.FileInfo, error) {
+	entries, err := client.sftpConn().ReadDirContext(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !isDotEntry(entry.Name()) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+func isDotEntry(name string) bool {
+	return name == "." || name == ".."
+}
+
+// entryType names the kind of entry described by mode.
+func entryType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode.IsRegular():
+		return "file"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,142 @@
+package sftpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DailyWindow is a time range of every day, given as offsets from
+// midnight. An End before Start spans midnight, e.g. 22:00 to 02:00.
+type DailyWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseDailyWindow parses a window such as "01:00-05:00".
+func ParseDailyWindow(s string) (DailyWindow, error) {
+	var startH, startM, endH, endM int
+	_, err := fmt.Sscanf(s, "%d:%d-%d:%d", &startH, &startM, &endH, &endM)
+	if err != nil {
+		return DailyWindow{}, fmt.Errorf("invalid daily window %q: %w", s, err)
+	}
+	for _, m := range []int{startM, endM} {
+		if m < 0 || m > 59 {
+			return DailyWindow{}, fmt.Errorf("invalid daily window %q: minute %d out of range", s, m)
+		}
+	}
+	window := DailyWindow{
+		Start: time.Duration(startH)*time.Hour + time.Duration(startM)*time.Minute,
+		End:   time.Duration(endH)*time.Hour + time.Duration(endM)*time.Minute,
+	}
+	return window, window.validate()
+}
+
+func (w DailyWindow) validate() error {
+	if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour || w.Start == w.End {
+		return fmt.Errorf("invalid daily window %s-%s", w.Start, w.End)
+	}
+	return nil
+}
+
+// at returns the wall clock time offset past midnight of day, so that DST
+// changes shift the window with the local clock.
+func at(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		int(offset/time.Hour), int(offset%time.Hour/time.Minute), int(offset%time.Minute/time.Second), 0, day.Location())
+}
+
+// bounds returns the occurrence of the window starting on day.
+func (w DailyWindow) bounds(day time.Time) (time.Time, time.Time) {
+	start := at(day, w.Start)
+	if w.End <= w.Start {
+		return start, at(day.AddDate(0, 0, 1), w.End)
+	}
+	return start, at(day, w.End)
+}
+
+// transferWindow restricts when new transfers may start.
+type transferWindow struct {
+	loc     *time.Location
+	windows []DailyWindow
+}
+
+// untilOpen returns how long to wait from now for a window to be open, zero
+// when one already is.
+func (tw *transferWindow) untilOpen(now time.Time) time.Duration {
+	now = now.In(tw.loc)
+	var next time.Time
+	for _, w := range tw.windows {
+		for _, day := range []time.Time{now.AddDate(0, 0, -1), now, now.AddDate(0, 0, 1)} {
+			start, end := w.bounds(day)
+			if !now.Before(start) && now.Before(end) {
+				return 0
+			}
+			if start.After(now) && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+	return next.Sub(now)
+}
+
+// WithTransferWindow lets batch operations start new file transfers only
+// inside one of the daily windows, in the time zone loc. At the end of a
+// window the transfer in flight completes, then the operation pauses until
+// the next window opens, reporting EventPaused and EventResumed and
+// accounting the wait in the result.
+func WithTransferWindow(loc *time.Location, windows ...DailyWindow) TransferOption {
+	return func(params *transferParams) error {
+		if loc == nil {
+			return fmt.Errorf("transfer window location is nil")
+		}
+		if len(windows) == 0 {
+			return fmt.Errorf("transfer window needs at least one daily window")
+		}
+		for _, w := range windows {
+			if err := w.validate(); err != nil {
+				return err
+			}
+		}
+		params.window = &transferWindow{loc: loc, windows: windows}
+		return nil
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// awaitWindow blocks until the transfer window, if any, is open.
+func (client *SFTPClient) awaitWindow(params *transferParams, result *BatchResult) error {
+	if params.window == nil || params.dryRun {
+		return nil
+	}
+	wait := params.window.untilOpen(client.now())
+	if wait <= 0 {
+		return nil
+	}
+
+	ctx := params.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	params.emit(TransferEvent{Type: EventPaused, ResumeAt: client.now().Add(wait)})
+	start := client.now()
+	err := client.sleepContext(ctx, wait)
+	result.Paused += client.now().Sub(start)
+	result.Pauses++
+	if err != nil {
+		return fmt.Errorf("failed to wait for transfer window: %w", err)
+	}
+	params.emit(TransferEvent{Type: EventResumed})
+	return nil
+}